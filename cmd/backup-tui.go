@@ -1,21 +1,39 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 
 	checkServer "github.com/Chanadu/backup-tui/cmd/checkserver"
 	"github.com/Chanadu/backup-tui/cmd/createbackups"
 	"github.com/Chanadu/backup-tui/cmd/getfiles"
+	"github.com/Chanadu/backup-tui/cmd/history"
+	"github.com/Chanadu/backup-tui/cmd/historyview"
+	"github.com/Chanadu/backup-tui/cmd/hookpane"
+	"github.com/Chanadu/backup-tui/cmd/jobs"
 	"github.com/Chanadu/backup-tui/cmd/parameters"
+	"github.com/Chanadu/backup-tui/cmd/review"
+	"github.com/Chanadu/backup-tui/cmd/snapshots"
 	"github.com/Chanadu/backup-tui/cmd/stage"
+	"github.com/Chanadu/backup-tui/cmd/stream"
 	"github.com/Chanadu/backup-tui/cmd/uploadbackups"
+	"github.com/Chanadu/backup-tui/pkg/engine"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
+// afterRun tracks what the TUI still does in the background for a run that
+// ended, sending notifications and running the on-failure hook. The program
+// waits for it before exiting.
+var afterRun sync.WaitGroup
+
 type model struct {
 	stage stage.Stage
 
@@ -26,13 +44,40 @@ type model struct {
 
 	filesModel    getfiles.FileSelectorModel
 	filesSelected []string
+	commands      []engine.CommandSource
+
+	reviewModel  review.ReviewModel
+	archive      engine.ArchiveSettings
+	retention    engine.Retention
+	incremental  engine.Incremental
+	replication  engine.Replication
+	repository   engine.RepositorySettings
+	repoPassword string
+	hooks        engine.Hooks
+	jobName      string
 
 	createBackupsModel createbackups.CreateBackupsModel
-	archivePaths       []string
+	archives           []string
+	// created is how Create ended, until the Upload stage took over the
+	// backup going on in its stream.
+	created createbackups.CreateBackupsMessage
 
 	uploadBackupsModel uploadbackups.UploadBackupsModel
 
-	tempDir string
+	snapshotsModel snapshots.SnapshotsModel
+
+	historyModel historyview.HistoryModel
+	// recorder records the run from creating archives until the upload
+	// finished, it is nil otherwise.
+	recorder *history.Recorder
+	// lock is the job's lock, held from creating archives until the upload
+	// finished.
+	lock *jobs.Lock
+	// hookPane shows the output of the job's hooks.
+	hookPane hookpane.HookPaneModel
+
+	tempDir        string
+	confirmingQuit bool
 }
 
 // Paramters -> check server, create backups, upload to remote server, delete local backups
@@ -41,18 +86,106 @@ func (m model) Init() tea.Cmd {
 	return textinput.Blink
 }
 
-func (m model) cleanUp() tea.Msg {
-	err := os.RemoveAll(m.tempDir)
+// shutdownMsg is sent when the process is asked to terminate by a signal.
+type shutdownMsg struct {
+	sig os.Signal
+}
+
+// busy reports whether quitting now would abort a backup in progress.
+func (m model) busy() bool {
+	switch m.stage {
+	case stage.Create:
+		// Waiting for another run's lock aborts nothing.
+		return m.lock != nil && !m.createBackupsModel.Done()
+	case stage.Upload:
+		return !m.uploadBackupsModel.Done()
+	case stage.Snapshots:
+		return m.snapshotsModel.Busy()
+	}
+	return false
+}
+
+// cleanUp aborts the stage that was still running when the program exited,
+// waiting for it to remove its partial local and remote files, and removes
+// the temp dir.
+func (m model) cleanUp() {
+	switch m.stage {
+	case stage.Check:
+		m.checkModel.Cancel()
+	case stage.Create:
+		if !m.createBackupsModel.Done() {
+			fmt.Println("Aborting, removing partial archives...")
+			m.createBackupsModel.Cancel()
+			m.finishRun(context.Canceled)
+			break
+		}
+		// Create failed, but uploads of the archives it made may still be
+		// running.
+		m.createBackupsModel.Cancel()
+	case stage.Upload:
+		if !m.uploadBackupsModel.Done() {
+			fmt.Println("Aborting, removing partial uploads...")
+			m.uploadBackupsModel.Cancel()
+			m.finishRun(context.Canceled)
+		}
+	case stage.Snapshots:
+		if m.snapshotsModel.Busy() {
+			fmt.Println("Aborting restore...")
+			m.snapshotsModel.Cancel()
+		}
+	}
+
+	// Notifications and hooks of a run that just ended may still be
+	// running, and hooks in the temp dir.
+	afterRun.Wait()
+
 	log.Printf("Cleaning up temp dir: %s", m.tempDir)
-	if err != nil {
+	if err := os.RemoveAll(m.tempDir); err != nil {
 		log.Printf("Couldn't remove temp dir %s, error: %v", m.tempDir, err)
 	}
+}
 
-	if m.stage == stage.Create {
-		m.createBackupsModel.KillProcess()
-		log.Printf("Killed create backups process")
+// finishRun records the run being made as ended with err, tells the job's
+// notifiers, runs its on-failure hook if it failed and releases the job's
+// lock.
+func (m *model) finishRun(err error) {
+	if m.recorder != nil {
+		m.recorder.Finish(err)
+		notifyRunInBackground(m.jobName, m.recorder.Run())
+		m.recorder = nil
+		if err != nil {
+			m.runFailureHook(err)
+		}
+	}
+	if m.lock != nil {
+		m.lock.Release()
+		m.lock = nil
 	}
-	return tea.Quit
+}
+
+// runFailureHook runs the job's on-failure hook in the background, the TUI
+// may be about to exit. Its output only goes to the log.
+func (m model) runFailureHook(err error) {
+	job := m.job()
+	afterRun.Add(1)
+	go func() {
+		defer afterRun.Done()
+		state := engine.HookState{Archives: m.archives, Err: err}
+		if err := engine.RunHook(context.Background(), job, engine.HookOnFailure, state, nil); err != nil {
+			log.Printf("error: %v", err)
+		}
+	}()
+}
+
+func (m model) updateConfirmQuit(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "y", "Y", "enter", "ctrl+c":
+		log.Printf("User confirmed quit during %s", m.stage)
+		return m, tea.Quit
+	case "n", "N", "esc":
+		m.confirmingQuit = false
+	}
+	return m, nil
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -60,49 +193,119 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 
+	case shutdownMsg:
+		log.Printf("Received %v, shutting down", msg.sig)
+		return m, tea.Quit
 	case tea.KeyMsg:
+		if m.confirmingQuit {
+			return m.updateConfirmQuit(msg)
+		}
+
 		strMsg := msg.String()
 		switch strMsg {
 		case "ctrl+c":
 			log.Printf("User initiated quit")
+			if m.busy() {
+				m.confirmingQuit = true
+				return m, nil
+			}
 			return m, tea.Quit
+		case hookpane.ToggleKey:
+			if m.showsHookPane() {
+				m.hookPane = m.hookPane.Toggle()
+				return m, nil
+			}
+		}
+	case stream.EventMsg:
+		// Recorded here, then handled by the stage's model below.
+		if m.recorder != nil {
+			m.recorder.Observe(msg.Event)
 		}
+		m.hookPane = m.hookPane.Observe(msg.Event)
+	case stage.BackMsg:
+		if prev, ok := m.stage.Previous(); ok {
+			return m.transition(prev)
+		}
+	case parameters.JobLoadedMsg:
+		log.Printf("Loaded job %s", msg.Job.Name)
+		m.jobName = msg.Job.Name
+		m.filesSelected = msg.Job.Paths
+		m.commands = msg.Job.Commands
+		m.archive = msg.Job.Archive
+		m.retention = msg.Job.Retention
+		m.incremental = msg.Job.Incremental
+		m.replication = msg.Job.Replication
+		m.repository = msg.Job.Repository
+		m.hooks = msg.Job.Hooks
 	case parameters.InputDataMessage:
-		m.stage++
 		m.paramsData = msg.Data
-		m.checkModel = checkServer.InitialCheckServerModel(m.paramsData)
+		m.archive.Level = msg.Data.Level
+		m.archive.Method = msg.Data.Method
+		m.archive.Threads = msg.Data.Threads
 		log.Printf("Input Data Collected: %v, %s", m.paramsData, m.stage)
-		m.inputsModel.SetCurrentIndex(0)
-		return m, m.checkModel.Init()
+		return m.transition(stage.Check)
+	case parameters.ShowHistoryMsg:
+		return m.transition(stage.History)
 	case checkServer.CheckServerMessage:
-		if msg.Ok {
-			m.stage++
-			m.filesModel = getfiles.InitialFilesSelectorModel([]string{}, m.tempDir)
-			return m, m.filesModel.Init()
+		if msg.Ok && m.stage == stage.Check {
+			return m.transition(stage.Files)
 		}
 	case checkServer.TryAgainMessage:
-		m.stage = stage.Input
+		return m.transition(stage.Input)
 
 	case getfiles.FilesSelectedMsg:
-		if len(msg.Paths) == 0 {
+		if len(msg.Paths) == 0 && len(m.commands) == 0 {
 			log.Println("No files selected, exiting")
 			return m, tea.Quit
 		}
 
 		m.filesSelected = msg.Paths
-		m.stage++
-		m.createBackupsModel = createbackups.InitialCreateBackupsModel(m.paramsData, m.filesSelected, m.tempDir)
-		return m, m.createBackupsModel.Init()
+		return m.transition(stage.Review)
+	case review.EditMsg:
+		return m.transition(msg.Stage)
+	case review.ConfirmMsg:
+		return m.transition(stage.Create)
+	case review.BrowseSnapshotsMsg:
+		return m.transition(stage.Snapshots)
+	case createbackups.LockMsg:
+		if m.stage != stage.Create || !m.createBackupsModel.Awaits(msg) {
+			// The Create stage that asked for it was left meanwhile.
+			if msg.Lock != nil {
+				msg.Lock.Release()
+			}
+			return m, nil
+		}
+		if msg.Err == nil {
+			m.lock = msg.Lock
+			m.recorder = history.NewRecorder(m.job())
+		}
 	case createbackups.CreateBackupsMessage:
 		if !msg.Ok {
 			for _, err := range msg.Errs {
 				log.Printf("Error during backup creation: %v", err)
-				return m, tea.Quit
 			}
+			err := errors.Join(msg.Errs...)
+			if err == nil {
+				err = errors.New("backup creation failed")
+			}
+			m.finishRun(err)
+			return m, tea.Quit
+		}
+		m.archives = msg.Archives
+		m.created = msg
+		log.Printf("Created backups: %v", m.archives)
+		return m.transition(stage.Upload)
+	case uploadbackups.UploadBackupsMessage:
+		if m.stage == stage.Upload {
+			if msg.Ok {
+				m.finishRun(nil)
+			} else {
+				m.finishRun(errors.Join(msg.Errs...))
+			}
+			model, cmd := m.transition(stage.Delete)
+			model.uploadBackupsModel, _ = model.uploadBackupsModel.Update(msg)
+			return model, cmd
 		}
-		m.stage++
-		m.uploadBackupsModel = uploadbackups.InitialUploadBackupsModel(m.paramsData, m.archivePaths)
-		log.Printf("Created backups: %v", m.archivePaths)
 
 	}
 
@@ -114,11 +317,17 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.checkModel, cmd = m.checkModel.Update(msg)
 	case stage.Files:
 		m.filesModel, cmd = m.filesModel.Update(msg)
+	case stage.Review:
+		m.reviewModel, cmd = m.reviewModel.Update(msg)
 	case stage.Create:
 		m.createBackupsModel, cmd = m.createBackupsModel.Update(msg)
 	case stage.Upload:
 		m.uploadBackupsModel, cmd = m.uploadBackupsModel.Update(msg)
 	case stage.Delete:
+	case stage.Snapshots:
+		m.snapshotsModel, cmd = m.snapshotsModel.Update(msg)
+	case stage.History:
+		m.historyModel, cmd = m.historyModel.Update(msg)
 	}
 	cmds = append(cmds, cmd)
 
@@ -134,23 +343,57 @@ func (m model) View() string {
 		s.WriteString(m.checkModel.View())
 	case stage.Files:
 		s.WriteString(m.filesModel.View())
+	case stage.Review:
+		s.WriteString(m.reviewModel.View())
 	case stage.Create:
 		s.WriteString(m.createBackupsModel.View())
 	case stage.Upload:
 		s.WriteString(m.uploadBackupsModel.View())
 	case stage.Delete:
+		s.WriteString(m.uploadBackupsModel.View())
+		if !m.repository.Enabled {
+			s.WriteString("\nLocal backups removed.\n")
+		}
+	case stage.Snapshots:
+		s.WriteString(m.snapshotsModel.View())
+	case stage.History:
+		s.WriteString(m.historyModel.View())
 	}
 
-	s.WriteString("\nPress Ctrl+C to quit.")
+	if m.showsHookPane() {
+		s.WriteString(m.hookPane.View())
+	}
+
+	if m.confirmingQuit {
+		s.WriteString("\nA backup is still running. Quit and abort it, removing partial files? (y/n)")
+	} else if _, ok := m.stage.Previous(); ok {
+		s.WriteString("\nPress Esc to go back, Ctrl+C to quit.")
+	} else {
+		s.WriteString("\nPress Ctrl+C to quit.")
+	}
 
 	return s.String()
 }
 
+// showsHookPane reports whether the hook pane is shown below the stage,
+// during the stages hooks run in once one ran.
+func (m model) showsHookPane() bool {
+	if m.hookPane.Empty() {
+		return false
+	}
+	switch m.stage {
+	case stage.Check, stage.Create, stage.Upload, stage.Delete:
+		return true
+	}
+	return false
+}
+
 func initialModel(tempDir string) model {
 
 	return model{
 		stage:       stage.Input,
 		inputsModel: parameters.InitialParametersInputs(),
+		archive:     engine.DefaultArchiveSettings(),
 		tempDir:     tempDir,
 	}
 }
@@ -166,11 +409,26 @@ func Start() {
 	}
 
 	m := initialModel(tempDir)
-	p := tea.NewProgram(m)
+	// Signals are handled here rather than by Bubble Tea so a running backup
+	// is aborted cleanly instead of the program just exiting.
+	p := tea.NewProgram(m, tea.WithMouseCellMotion(), tea.WithoutSignalHandler())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			p.Send(shutdownMsg{sig: sig})
+		}
+	}()
 
-	defer m.cleanUp()
+	final, err := p.Run()
+	if err != nil {
+		log.Printf("error: %v", err)
+	}
 
-	if _, err := p.Run(); err != nil {
-		log.Fatalf("error: %v", err)
+	if finalModel, ok := final.(model); ok {
+		m = finalModel
 	}
+	m.cleanUp()
 }
@@ -1,13 +1,15 @@
 package backup
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
 
-	"github.com/Chanadu/backup-tui/cmd/parameters"
+	"github.com/Chanadu/backup-tui/cmd/stage"
+	"github.com/Chanadu/backup-tui/cmd/stream"
+	"github.com/Chanadu/backup-tui/pkg/engine"
 	tea "github.com/charmbracelet/bubbletea"
-	"golang.org/x/crypto/ssh"
 )
 
 type CheckServerMessage struct {
@@ -22,49 +24,34 @@ func TryAgainCmd() tea.Msg {
 }
 
 type CheckServerModel struct {
-	data     parameters.InputData
+	job      engine.Job
+	stream   *stream.Stream
 	done     bool
 	success  bool
 	err      error
 	attempts int
 }
 
-func (m *CheckServerModel) checkServer() tea.Msg {
-	log.Println("checking server")
-	config := &ssh.ClientConfig{
-		User: m.data.User,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(m.data.Password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * 1e9,
-	}
-
-	client, err := ssh.Dial("tcp", m.data.Server+":22", config)
-
-	if err != nil {
-		log.Printf("Connection failed")
-		log.Printf("error: %v", err)
-
-		return CheckServerMessage{
-			Ok:  false,
-			Err: fmt.Errorf("connecting to server: %v", err),
+// checkServer runs the job's pre-check hook and checks its destinations.
+func (m CheckServerModel) checkServer() tea.Cmd {
+	log.Printf("checking %d destinations", len(m.job.Destinations))
+	return m.stream.Start(func(ctx context.Context, events chan<- engine.Event) error {
+		if err := engine.RunHook(ctx, m.job, engine.HookPreCheck, engine.HookState{}, events); err != nil {
+			return err
 		}
-	}
-	err = client.Close()
-	if err != nil {
-		log.Fatalf("error closing connection: %v", err)
-	}
+		return engine.Check(ctx, m.job, events)
+	})
+}
 
-	log.Printf("Connection success")
-	return CheckServerMessage{
-		Ok:  true,
-		Err: nil,
+// Cancel abandons a check that is still connecting.
+func (m CheckServerModel) Cancel() {
+	if m.stream != nil {
+		m.stream.Cancel()
 	}
 }
 
 func (m CheckServerModel) Init() tea.Cmd {
-	return m.checkServer
+	return m.checkServer()
 }
 
 func (m CheckServerModel) Update(msg tea.Msg) (CheckServerModel, tea.Cmd) {
@@ -72,11 +59,41 @@ func (m CheckServerModel) Update(msg tea.Msg) (CheckServerModel, tea.Cmd) {
 
 	switch msg := msg.(type) {
 
+	case stream.EventMsg:
+		if msg.Stream == m.stream {
+			return m, m.stream.Next()
+		}
+	case stream.DoneMsg:
+		if msg.Stream != m.stream {
+			break
+		}
+		if msg.Err != nil {
+			log.Printf("Connection failed")
+			log.Printf("error: %v", msg.Err)
+		} else {
+			log.Printf("Connection success")
+		}
+		return m, func() tea.Msg {
+			return CheckServerMessage{Ok: msg.Err == nil, Err: msg.Err}
+		}
 	case CheckServerMessage:
 		m.done = true
 		m.success = msg.Ok
 		m.err = msg.Err
+	case retryMessage:
+		m.done = false
+		m.attempts += 1
+		m.stream = stream.New()
+		return m, m.checkServer()
+	case tea.MouseMsg:
+		if !m.done || m.success {
+			break
+		}
+		return m, m.clickedButton(msg)
 	case tea.KeyMsg:
+		if msg.String() == "esc" {
+			return m, stage.BackCmd
+		}
 		if !m.done || m.success {
 			break
 		}
@@ -87,35 +104,78 @@ func (m CheckServerModel) Update(msg tea.Msg) (CheckServerModel, tea.Cmd) {
 		case "enter":
 			return m, TryAgainCmd
 		case "R":
-			m.done = false
-			m.attempts += 1
-			return m, m.checkServer
+			return m, m.retry
 		}
 	}
 
 	return m, tea.Batch(cmds...)
 }
 
+const (
+	retryButton = "[ Retry ]"
+	backButton  = "[ Back ]"
+	buttonGap   = "  "
+)
+
+// failureView renders the failure message above the buttons row.
+func (m CheckServerModel) failureView() string {
+	var s strings.Builder
+	s.WriteString("Destination Connection Failed.")
+	if m.attempts > 1 {
+		fmt.Fprintf(&s, " (%d)", m.attempts)
+	}
+	s.WriteString("\n")
+	fmt.Fprintf(&s, "error %v", m.err)
+	s.WriteString("\n\n")
+
+	s.WriteString("Press Enter to change destination details.\n")
+	s.WriteString("Press R to retry.\n\n")
+
+	return s.String()
+}
+
+// clickedButton returns the command of the button under a left click on the
+// failure screen, if any.
+func (m CheckServerModel) clickedButton(msg tea.MouseMsg) tea.Cmd {
+	if msg.Action != tea.MouseActionPress || msg.Button != tea.MouseButtonLeft {
+		return nil
+	}
+	if msg.Y != strings.Count(m.failureView(), "\n") {
+		return nil
+	}
+
+	retryEnd := len(retryButton)
+	backStart := retryEnd + len(buttonGap)
+	switch {
+	case msg.X < retryEnd:
+		return m.retry
+	case msg.X >= backStart && msg.X < backStart+len(backButton):
+		return TryAgainCmd
+	}
+	return nil
+}
+
+func (m CheckServerModel) retry() tea.Msg {
+	return retryMessage{}
+}
+
+type retryMessage struct{}
+
 func (m CheckServerModel) View() string {
 	// log.Printf("done: %t, success: %t, attempts: %d", m.done, m.success, m.attempts)
 	log.Printf("Checking Server Model: done: %t, success: %t, attempts: %d", m.done, m.success, m.attempts)
 
 	var s strings.Builder
 	if !m.done {
-		s.WriteString("Checking Server...")
-	} else if !m.success {
-		s.WriteString("Server Connection Failed.")
-		if m.attempts > 1 {
-			fmt.Fprintf(&s, " (%d)", m.attempts)
+		s.WriteString("Checking destinations...")
+		for _, dest := range m.job.Destinations {
+			fmt.Fprintf(&s, "\n  %s", dest)
 		}
-		s.WriteString("\n")
-		fmt.Fprintf(&s, "error %v", m.err)
-		s.WriteString("\n\n")
-
-		s.WriteString("Press Enter to change server details.\n")
-		s.WriteString("Press R to retry.")
+	} else if !m.success {
+		s.WriteString(m.failureView())
+		s.WriteString(retryButton + buttonGap + backButton)
 	} else {
-		s.WriteString("Server Connected")
+		s.WriteString("Destination Reachable")
 	}
 
 	s.WriteString("\n")
@@ -123,9 +183,10 @@ func (m CheckServerModel) View() string {
 	return s.String()
 }
 
-func InitialCheckServerModel(data parameters.InputData) CheckServerModel {
+func InitialCheckServerModel(job engine.Job) CheckServerModel {
 	return CheckServerModel{
-		data:     data,
+		job:      job,
+		stream:   stream.New(),
 		done:     false,
 		attempts: 1,
 	}
@@ -1,146 +1,341 @@
 package createbackups
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
-	"path/filepath"
+	"slices"
 	"strings"
-	"syscall"
+	"time"
 
-	"github.com/Chanadu/backup-tui/cmd/parameters"
+	"github.com/Chanadu/backup-tui/cmd/jobs"
+	"github.com/Chanadu/backup-tui/cmd/stage"
+	"github.com/Chanadu/backup-tui/cmd/stream"
+	"github.com/Chanadu/backup-tui/pkg/engine"
+	"github.com/Chanadu/backup-tui/pkg/lock"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-type CreateBackupsMessage struct {
-	Ok   bool
-	Errs []error
-}
+// cancelTimeout bounds how long Cancel waits for the archiver to clean up.
+const cancelTimeout = 10 * time.Second
+
+// lockTimeout bounds how long taking the job's lock may take, remote markers
+// included.
+const lockTimeout = 30 * time.Second
 
-type BackupOutputMsg struct {
-	Done bool
+// lockRetryInterval is how often a held lock is tried again while waiting.
+const lockRetryInterval = 2 * time.Second
+
+// LockMsg is sent once an attempt to lock the job finished. The lock is
+// held until the run ends, so the model running the pipeline keeps it.
+type LockMsg struct {
+	Lock *jobs.Lock
 	Err  error
+
+	stream *stream.Stream
 }
 
-type CreateBackupsModel struct {
-	data    parameters.InputData
-	tempDir string
-	paths   []string
-	done    bool
-	success bool
-	errs    []error
-
-	current     int
-	currentFile string
-}
-
-var runningCmd *exec.Cmd
-
-func (m *CreateBackupsModel) KillProcess() {
-	log.Printf("KillProcess called: m.cmd=%v", runningCmd)
-	if runningCmd != nil && runningCmd.Process != nil {
-		pgid, err := syscall.Getpgid(runningCmd.Process.Pid)
-		if err == nil {
-			log.Printf("Killing process group %d", pgid)
-			_ = syscall.Kill(-pgid, syscall.SIGKILL)
-		} else {
-			log.Printf("Killing process PID %d", runningCmd.Process.Pid)
-			_ = runningCmd.Process.Kill()
-		}
-	}
+// retryLockMsg asks to try the lock again while waiting for it.
+type retryLockMsg struct {
+	stream *stream.Stream
 }
 
-func (m *CreateBackupsModel) stream7zOutput() tea.Msg {
-	filePath := m.paths[m.current]
-	baseName := filepath.Base(filePath)
-	archiveName := baseName + "-backup.7z"
-	archivePath := filepath.Join(m.tempDir, archiveName)
-	log.Printf("Creating archive for %s at %s", filePath, archivePath)
+// CreateBackupsMessage is sent once every archive was created. The
+// uploads go on in Stream, which the Upload stage takes over, Events are
+// those of the uploads received so far.
+type CreateBackupsMessage struct {
+	Ok       bool
+	Errs     []error
+	Archives []string
+	Stream   *stream.Stream
+	Events   []engine.Event
+}
 
-	m.currentFile = filePath
+type CreateBackupsModel struct {
+	job      engine.Job
+	stream   *stream.Stream
+	done     bool
+	success  bool
+	errs     []error
+	archives []string
 
-	cmd := exec.Command("7z", "a", "-mx=9", archivePath, filePath)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	current int
+	// workers are the items being archived, by worker slot, an empty
+	// slot being idle.
+	workers []string
+	// uploads are the events of uploads, and of the retention following
+	// them, that came before every archive was created.
+	uploads  []engine.Event
+	uploaded int
 
-	log.Printf("Executing command: %s", strings.Join(cmd.Args, " "))
+	// locked is set once the job's lock is held and archiving started.
+	locked  bool
+	held    *lock.HeldError
+	waiting bool
+}
 
-	if err := cmd.Start(); err != nil {
-		return BackupOutputMsg{
-			Done: true,
-			Err:  err,
-		}
-	}
+// Awaits reports whether msg answers this model's attempt to lock the job,
+// rather than one of a model that was left since.
+func (m CreateBackupsModel) Awaits(msg LockMsg) bool {
+	return msg.stream == m.stream && !m.locked
+}
 
-	log.Printf("Started 7z process with PID %d", cmd.Process.Pid)
-	runningCmd = cmd
-	log.Printf("Waiting for process to finish for %s", filePath)
+// lockJob tries to lock the job.
+func (m CreateBackupsModel) lockJob() tea.Msg {
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+	l, err := jobs.AcquireLock(ctx, m.job)
+	return LockMsg{Lock: l, Err: err, stream: m.stream}
+}
 
-	if err := cmd.Wait(); err != nil {
-		log.Printf("7z command failed for %s: %v", filePath, err)
-		return BackupOutputMsg{
-			Done: true,
-			Err:  err,
-		}
+// Cancel stops archiving and the uploads already started, and waits for the
+// running archivers to exit and the partial archives to be removed. The
+// uploads go on in the same stream once every archive was created, so it
+// must be called even when Done unless the Upload stage took over.
+func (m CreateBackupsModel) Cancel() {
+	if m.stream == nil {
+		return
 	}
-
-	return BackupOutputMsg{
-		Done: true,
-		Err:  nil,
+	log.Printf("Cancelling backup creation")
+	if !m.stream.CancelAndWait(cancelTimeout) {
+		log.Printf("Backup creation didn't stop within %s", cancelTimeout)
 	}
 }
 
+// Done reports whether all archives have been created.
+func (m CreateBackupsModel) Done() bool {
+	return m.done
+}
+
 func (m CreateBackupsModel) Init() tea.Cmd {
-	log.Printf("Starting backup creation for %d files.", len(m.paths))
-	return m.stream7zOutput
+	return m.lockJob
+}
+
+// start runs the backup once the job is locked, after the job's pre-create
+// hook. This model follows it until every archive was created, the Upload
+// stage follows the rest.
+func (m CreateBackupsModel) start() tea.Cmd {
+	log.Printf("Starting backup creation for %d files and %d commands.", len(m.job.Paths), len(m.job.Commands))
+	return m.stream.Start(func(ctx context.Context, events chan<- engine.Event) error {
+		if err := engine.RunHook(ctx, m.job, engine.HookPreCreate, engine.HookState{}, events); err != nil {
+			return err
+		}
+		return engine.Backup(ctx, m.job, events)
+	})
 }
 
 func (m CreateBackupsModel) Update(msg tea.Msg) (CreateBackupsModel, tea.Cmd) {
 	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if m.held != nil {
+			switch msg.String() {
+			case "w":
+				if !m.waiting {
+					m.waiting = true
+					return m, m.retryLock()
+				}
+			case "a", "esc":
+				return m, stage.BackCmd
+			}
+			return m, nil
+		}
+		if msg.String() == "esc" && !m.done {
+			return m, stage.BackCmd
+		}
 
-	case BackupOutputMsg:
+	case LockMsg:
+		if !m.Awaits(msg) {
+			break
+		}
+		var held *lock.HeldError
+		if errors.As(msg.Err, &held) {
+			log.Printf("Job is %v", held)
+			m.held = held
+			if m.waiting {
+				return m, m.retryLock()
+			}
+			return m, nil
+		}
 		if msg.Err != nil {
-			m.errs = append(m.errs, msg.Err)
+			log.Printf("Couldn't lock job: %v", msg.Err)
+			m.done = true
+			m.errs = append(m.errs, fmt.Errorf("locking job: %w", msg.Err))
+			return m, func() tea.Msg {
+				return CreateBackupsMessage{Errs: m.errs}
+			}
 		}
-		m.current++
-		if m.current < len(m.paths) {
-			return m, m.stream7zOutput
+		m.locked = true
+		m.held = nil
+		return m, m.start()
+
+	case retryLockMsg:
+		if msg.stream == m.stream && !m.locked {
+			return m, m.lockJob
 		}
 
-		m.done = true
-		m.success = len(m.errs) == 0
-		log.Printf("Backup creation done. Success: %v, Errors: %d\n", m.success, len(m.errs))
-		return m, func() tea.Msg {
-			return CreateBackupsMessage{
-				Ok:   m.success,
-				Errs: m.errs,
+	case stream.EventMsg:
+		if msg.Stream != m.stream {
+			break
+		}
+		// Uploads, and retention after them, may start before every
+		// archive was created.
+		if s := eventStage(msg.Event); s != "" && s != engine.StageCreate {
+			m.uploads = append(m.uploads, msg.Event)
+			if done, ok := msg.Event.(engine.FileDone); ok && done.Destination != "" {
+				m.uploaded++
 			}
+			return m, m.stream.Next()
 		}
+		switch event := msg.Event.(type) {
+		case engine.Progress:
+			m = m.startWorker(event.Item)
+		case engine.FileDone:
+			m.current++
+			m = m.stopWorker(event.Item)
+			// Unchanged paths of incremental jobs have no archive.
+			if event.Path != "" {
+				m.archives = append(m.archives, event.Path)
+			}
+		case engine.Error:
+			m.current++
+			m = m.stopWorker(event.Item)
+			m.errs = append(m.errs, event.Err)
+		case engine.HookDone:
+			if event.Err != nil {
+				m.errs = append(m.errs, event.Err)
+			}
+		case engine.StageDone:
+			if event.Stage == engine.StageCreate {
+				return m.finish()
+			}
+		}
+		return m, m.stream.Next()
+
+	case stream.DoneMsg:
+		if msg.Stream != m.stream {
+			break
+		}
+		// The run ended before every archive was created.
+		if msg.Err != nil && len(m.errs) == 0 {
+			m.errs = append(m.errs, msg.Err)
+		}
+		return m.finish()
 	}
 
 	return m, nil
 }
 
+// finish reports every archive was created, handing the stream over if it
+// is still running.
+func (m CreateBackupsModel) finish() (CreateBackupsModel, tea.Cmd) {
+	m.done = true
+	m.success = len(m.errs) == 0
+	log.Printf("Backup creation done. Success: %v, Errors: %d\n", m.success, len(m.errs))
+	return m, func() tea.Msg {
+		return CreateBackupsMessage{
+			Ok:       m.success,
+			Errs:     m.errs,
+			Archives: m.archives,
+			Stream:   m.stream,
+			Events:   m.uploads,
+		}
+	}
+}
+
+// eventStage returns the stage event is about, if any.
+func eventStage(event engine.Event) engine.Stage {
+	switch event := event.(type) {
+	case engine.StageStarted:
+		return event.Stage
+	case engine.Progress:
+		return event.Stage
+	case engine.FileDone:
+		return event.Stage
+	case engine.Error:
+		return event.Stage
+	}
+	return ""
+}
+
+// startWorker shows item in the first idle worker slot.
+func (m CreateBackupsModel) startWorker(item string) CreateBackupsModel {
+	if slices.Contains(m.workers, item) {
+		return m
+	}
+	m.workers = slices.Clone(m.workers)
+	if i := slices.Index(m.workers, ""); i >= 0 {
+		m.workers[i] = item
+	} else {
+		m.workers = append(m.workers, item)
+	}
+	return m
+}
+
+// stopWorker frees the slot of the worker that archived item.
+func (m CreateBackupsModel) stopWorker(item string) CreateBackupsModel {
+	if i := slices.Index(m.workers, item); i >= 0 {
+		m.workers = slices.Clone(m.workers)
+		m.workers[i] = ""
+	}
+	return m
+}
+
+// retryLock tries the lock again after lockRetryInterval.
+func (m CreateBackupsModel) retryLock() tea.Cmd {
+	s := m.stream
+	return tea.Tick(lockRetryInterval, func(time.Time) tea.Msg {
+		return retryLockMsg{stream: s}
+	})
+}
+
 func (m CreateBackupsModel) View() string {
 	var s strings.Builder
 	s.WriteString("\n")
+	if m.held != nil {
+		fmt.Fprintf(&s, "Another backup of this job is running.\nHeld by %s\nLock: %s\n\n", m.held.Holder, m.held.Where)
+		if m.waiting {
+			s.WriteString("Waiting for it to finish... (a/esc: abort)\n")
+		} else {
+			s.WriteString("w: wait for it to finish, a/esc: abort\n")
+		}
+		return s.String()
+	}
+	if !m.locked && !m.done {
+		s.WriteString("Locking job...\n")
+		return s.String()
+	}
 	if !m.done {
-		fmt.Fprintf(&s, "Creating backup for: %s\n",
-			m.currentFile)
+		total := len(m.job.ArchivedCommands())
+		if !m.job.Archive.Streamed() {
+			total += len(m.job.Paths)
+		}
+		fmt.Fprintf(&s, "Created %d of %d backups\n", m.current, total)
+		for i, item := range m.workers {
+			if item == "" {
+				fmt.Fprintf(&s, "  worker %d: idle\n", i+1)
+			} else {
+				fmt.Fprintf(&s, "  worker %d: %s\n", i+1, item)
+			}
+		}
+		if m.uploaded > 0 {
+			fmt.Fprintf(&s, "Uploaded %d so far\n", m.uploaded)
+		}
 		return s.String()
 	}
 	if m.success {
 		s.WriteString("All backups created successfully!")
 	} else {
-		fmt.Fprintf(&s, "Backups finished with %d errors.", len(m.errs))
+		fmt.Fprintf(&s, "Backups finished with %d errors.\n", len(m.errs))
+		s.WriteString(errors.Join(m.errs...).Error())
 	}
 	return s.String()
 }
 
-func InitialCreateBackupsModel(data parameters.InputData, paths []string, tempDir string) CreateBackupsModel {
+func InitialCreateBackupsModel(job engine.Job) CreateBackupsModel {
 	model := CreateBackupsModel{
-		data:    data,
-		tempDir: tempDir,
-		paths:   paths,
+		job:    job,
+		stream: stream.New(),
 	}
 	return model
 }
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Chanadu/backup-tui/cmd/daemon"
+	"github.com/Chanadu/backup-tui/cmd/history"
+	"github.com/Chanadu/backup-tui/cmd/jobs"
+	"github.com/Chanadu/backup-tui/pkg/schedule"
+)
+
+// RunDaemon runs the saved jobs that have a schedule whenever they are due,
+// until it is interrupted. It returns the process exit code.
+func RunDaemon(args []string) int {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: backup-tui daemon")
+		fmt.Fprintln(flags.Output(), "Runs saved jobs on their schedule, set as \"schedule\" in the job file:")
+		fmt.Fprintln(flags.Output(), "a cron expression, @hourly/@daily/@weekly/@monthly, or \"@every 6h\".")
+		fmt.Fprintf(flags.Output(), "Passwords are read from $%s_<n> and $%s once at startup.\n", PasswordEnv, PasswordEnv)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	all, err := jobs.List()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	type loaded struct {
+		job       jobs.Job
+		passwords []string
+	}
+	byName := map[string]loaded{}
+	entries := []daemon.Entry{}
+	for _, saved := range all {
+		if saved.Schedule == "" {
+			continue
+		}
+		sched, err := schedule.Parse(saved.Schedule)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: job %s: %v\n", saved.Name, err)
+			return 1
+		}
+
+		job, passwords, err := loadJob(saved.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: job %s: %v\n", saved.Name, err)
+			return 1
+		}
+		byName[job.Name] = loaded{job: job, passwords: passwords}
+		entries = append(entries, daemon.Entry{Name: job.Name, Schedule: sched})
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(os.Stderr, "error: no saved job has a schedule")
+		return 1
+	}
+
+	runs, err := history.Load()
+	if err != nil {
+		log.Printf("Couldn't load history, missed runs won't be caught up: %v", err)
+	}
+	d := daemon.New(entries, runs, func(ctx context.Context, name string) error {
+		l := byName[name]
+		// A run started by hand holds the lock, this one is skipped.
+		return runJob(ctx, l.job, l.passwords, "["+name+"]", false)
+	})
+
+	listener, err := daemon.Listen()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+
+	for _, status := range d.Status() {
+		fmt.Printf("%s: %s, next run %s\n", status.Name, status.Schedule, status.Next.Local().Format("2006-01-02 15:04"))
+	}
+	log.Printf("Daemon started with %d jobs on %s", len(entries), listener.Addr())
+
+	go d.Serve(ctx, listener)
+	d.Run(ctx)
+
+	fmt.Println("daemon stopped")
+	return 0
+}
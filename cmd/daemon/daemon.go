@@ -0,0 +1,164 @@
+// Package daemon runs saved jobs on their schedules and reports what it is
+// doing over a local Unix socket.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Chanadu/backup-tui/cmd/history"
+	"github.com/Chanadu/backup-tui/pkg/schedule"
+)
+
+// pollInterval is how often the daemon checks for due jobs. The wall clock
+// is compared on every check, so runs missed while the machine slept are
+// caught up at most this long after it wakes.
+const pollInterval = 30 * time.Second
+
+// Runner runs the job called name until it finishes or ctx is done.
+type Runner func(ctx context.Context, name string) error
+
+// Entry is a job the daemon runs on a schedule.
+type Entry struct {
+	Name     string
+	Schedule schedule.Schedule
+}
+
+// JobStatus is what the daemon knows about one of its jobs.
+type JobStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Next     time.Time `json:"next"`
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started,omitzero"`
+
+	LastRun    time.Time      `json:"last_run,omitzero"`
+	LastStatus history.Status `json:"last_status,omitempty"`
+	LastError  string         `json:"last_error,omitempty"`
+	// Skipped counts the runs skipped because the previous one was still
+	// going.
+	Skipped int `json:"skipped,omitempty"`
+}
+
+// Daemon runs jobs when they are due.
+type Daemon struct {
+	run Runner
+
+	mu      sync.Mutex
+	entries []Entry
+	status  []JobStatus
+}
+
+// New creates a daemon running entries with run. A job whose last recorded
+// run is older than its schedule allows, because the daemon wasn't running
+// or the machine was off, runs as soon as the daemon starts.
+func New(entries []Entry, runs []history.Run, run Runner) *Daemon {
+	d := &Daemon{run: run, entries: entries}
+	now := time.Now()
+	for _, entry := range entries {
+		status := JobStatus{Name: entry.Name, Schedule: entry.Schedule.String()}
+		for _, past := range runs {
+			// Runs are newest first.
+			if past.Job == entry.Name {
+				status.LastRun = past.Started
+				status.LastStatus = past.Status
+				break
+			}
+		}
+
+		if status.LastRun.IsZero() {
+			status.Next = entry.Schedule.Next(now)
+		} else {
+			status.Next = entry.Schedule.Next(status.LastRun)
+			if status.Next.Before(now) {
+				log.Printf("Job %s missed its run at %s, catching up", entry.Name, status.Next.Format(time.RFC3339))
+				status.Next = now
+			}
+		}
+		d.status = append(d.status, status)
+	}
+	return d
+}
+
+// Status returns the status of each job.
+func (d *Daemon) Status() []JobStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := make([]JobStatus, len(d.status))
+	copy(status, d.status)
+	return status
+}
+
+// Run starts due jobs until ctx is done, then waits for the running ones to
+// stop.
+func (d *Daemon) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		d.startDue(ctx, &wg)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Daemon) startDue(ctx context.Context, wg *sync.WaitGroup) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Round(0) drops the monotonic reading: it stops while the machine
+	// sleeps, the wall clock doesn't.
+	now := time.Now().Round(0)
+	for i := range d.status {
+		status := &d.status[i]
+		if now.Before(status.Next) {
+			continue
+		}
+		status.Next = d.entries[i].Schedule.Next(now)
+
+		if status.Running {
+			status.Skipped++
+			log.Printf("Skipping run of %s, the previous one started at %s is still going", status.Name, status.Started.Format(time.RFC3339))
+			continue
+		}
+
+		status.Running = true
+		status.Started = now
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.runJob(ctx, i)
+		}()
+	}
+}
+
+func (d *Daemon) runJob(ctx context.Context, i int) {
+	name := d.entries[i].Name
+	log.Printf("Starting scheduled run of %s", name)
+	err := d.run(ctx, name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := &d.status[i]
+	status.Running = false
+	status.LastRun = status.Started
+	status.LastError = ""
+	switch {
+	case err == nil:
+		status.LastStatus = history.StatusSuccess
+	case errors.Is(err, context.Canceled):
+		status.LastStatus = history.StatusCancelled
+	default:
+		status.LastStatus = history.StatusFailed
+		status.LastError = err.Error()
+	}
+	log.Printf("Scheduled run of %s finished: %s", name, status.LastStatus)
+}
@@ -0,0 +1,125 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Chanadu/backup-tui/cmd/utils"
+)
+
+const (
+	socketName = "daemon.sock"
+	// statusCommand is the only request the socket answers.
+	statusCommand = "status"
+	connTimeout   = 2 * time.Second
+)
+
+// ErrNotRunning is returned by Query when no daemon is listening.
+var ErrNotRunning = errors.New("daemon not running")
+
+// SocketPath is where the daemon listens, in the state dir.
+func SocketPath() (string, error) {
+	stateDir, err := utils.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, socketName), nil
+}
+
+// Listen opens the daemon's socket, replacing a stale one left by a daemon
+// that didn't exit cleanly. It fails if another daemon is listening.
+func Listen() (net.Listener, error) {
+	path, err := SocketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	if conn, err := net.DialTimeout("unix", path, connTimeout); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another daemon is listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Only the user running the daemon may ask it anything.
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Serve answers status requests on listener until ctx is done.
+func (d *Daemon) Serve(ctx context.Context, listener net.Listener) {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Daemon socket accept failed: %v", err)
+			}
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *Daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(connTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	if command := strings.TrimSpace(line); command != statusCommand {
+		fmt.Fprintf(conn, "unknown command %q\n", command)
+		return
+	}
+	if err := json.NewEncoder(conn).Encode(d.Status()); err != nil {
+		log.Printf("Couldn't send daemon status: %v", err)
+	}
+}
+
+// Query asks the running daemon for the status of its jobs.
+func Query(ctx context.Context) ([]JobStatus, error) {
+	path, err := SocketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{Timeout: connTimeout}
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(connTimeout))
+
+	if _, err := fmt.Fprintln(conn, statusCommand); err != nil {
+		return nil, err
+	}
+	var status []JobStatus
+	if err := json.NewDecoder(conn).Decode(&status); err != nil {
+		return nil, fmt.Errorf("reading daemon status: %w", err)
+	}
+	return status, nil
+}
@@ -6,10 +6,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
@@ -34,6 +34,8 @@ func New() Model {
 		ShowPermissions:  true,
 		ShowSize:         true,
 		ShowHidden:       false,
+		SortKey:          SortByName,
+		AgeFilters:       DefaultAgeFilters,
 		DirAllowed:       false,
 		FileAllowed:      true,
 		AutoHeight:       true,
@@ -74,6 +76,12 @@ type KeyMap struct {
 	Back     key.Binding
 	Open     key.Binding
 	Select   key.Binding
+
+	CycleSort    key.Binding
+	ReverseSort  key.Binding
+	CycleAge     key.Binding
+	CycleType    key.Binding
+	ToggleHidden key.Binding
 }
 
 // DefaultKeyMap defines the default keybindings.
@@ -88,6 +96,12 @@ func DefaultKeyMap() KeyMap {
 		Back:     key.NewBinding(key.WithKeys("h", "backspace", "left", "esc"), key.WithHelp("h", "back")),
 		Open:     key.NewBinding(key.WithKeys("l", "right", "enter"), key.WithHelp("l", "open")),
 		Select:   key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "select")),
+
+		CycleSort:    key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "sort by")),
+		ReverseSort:  key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "reverse")),
+		CycleAge:     key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "age filter")),
+		CycleType:    key.NewBinding(key.WithKeys("t"), key.WithHelp("t", "type filter")),
+		ToggleHidden: key.NewBinding(key.WithKeys("."), key.WithHelp(".", "hidden")),
 	}
 }
 
@@ -151,10 +165,26 @@ type Model struct {
 	DirAllowed      bool
 	FileAllowed     bool
 
+	// SortKey and SortDescending order the listing, directories first.
+	SortKey        SortKey
+	SortDescending bool
+
+	// MaxAge hides files modified longer ago than it, zero shows all.
+	// AgeFilters are the values the CycleAge key steps through.
+	MaxAge     time.Duration
+	AgeFilters []time.Duration
+
+	// TypeFilter limits the listing to directories or files.
+	TypeFilter TypeFilter
+
 	FileSelected  string
 	selected      int
 	selectedStack stack
 
+	// clickedSelected is set when the last message was a click on the
+	// already highlighted row, which selects it like the Select key.
+	clickedSelected bool
+
 	min      int
 	max      int
 	maxStack stack
@@ -203,39 +233,29 @@ func (m *Model) popView() (int, int, int) {
 	return m.selectedStack.Pop(), m.minStack.Pop(), m.maxStack.Pop()
 }
 
-func (m Model) readDir(path string, showHidden bool) tea.Cmd {
+func (m Model) readDir(path string) tea.Cmd {
 	return func() tea.Msg {
 		dirEntries, err := os.ReadDir(path)
 		if err != nil {
 			return errorMsg{err}
 		}
 
-		sort.Slice(dirEntries, func(i, j int) bool {
-			if dirEntries[i].IsDir() == dirEntries[j].IsDir() {
-				return dirEntries[i].Name() < dirEntries[j].Name()
-			}
-			return dirEntries[i].IsDir()
-		})
-
-		if showHidden {
-			return readDirMsg{id: m.id, entries: dirEntries}
-		}
-
-		var sanitizedDirEntries []os.DirEntry
-		for _, dirEntry := range dirEntries {
-			isHidden, _ := IsHidden(dirEntry.Name())
-			if isHidden {
-				continue
-			}
-			sanitizedDirEntries = append(sanitizedDirEntries, dirEntry)
-		}
-		return readDirMsg{id: m.id, entries: sanitizedDirEntries}
+		return readDirMsg{id: m.id, entries: m.filterAndSort(path, dirEntries)}
 	}
 }
 
+// reload re-reads the current directory after the sort order or filters
+// changed, moving the cursor back to the top.
+func (m Model) reload() (Model, tea.Cmd) {
+	m.selected = 0
+	m.min = 0
+	m.max = max(m.Height-1, 0)
+	return m, m.readDir(m.CurrentDirectory)
+}
+
 // Init initializes the file picker model.
 func (m Model) Init() tea.Cmd {
-	return m.readDir(m.CurrentDirectory, m.ShowHidden)
+	return m.readDir(m.CurrentDirectory)
 }
 
 // SetHeight sets the height of the filepicker.
@@ -248,7 +268,11 @@ func (m *Model) SetHeight(height int) {
 
 // Update handles user interactions within the file picker model.
 func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
+	m.clickedSelected = false
+
 	switch msg := msg.(type) {
+	case tea.MouseMsg:
+		m.handleMouse(msg)
 	case readDirMsg:
 		if msg.id != m.id {
 			break
@@ -271,23 +295,9 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			m.min = len(m.files) - m.Height
 			m.max = len(m.files) - 1
 		case key.Matches(msg, m.KeyMap.Down):
-			m.selected++
-			if m.selected >= len(m.files) {
-				m.selected = len(m.files) - 1
-			}
-			if m.selected > m.max {
-				m.min++
-				m.max++
-			}
+			m.moveDown()
 		case key.Matches(msg, m.KeyMap.Up):
-			m.selected--
-			if m.selected < 0 {
-				m.selected = 0
-			}
-			if m.selected < m.min {
-				m.min--
-				m.max--
-			}
+			m.moveUp()
 		case key.Matches(msg, m.KeyMap.PageDown):
 			m.selected += m.Height
 			if m.selected >= len(m.files) {
@@ -312,6 +322,21 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 				m.min = 0
 				m.max = m.min + m.Height
 			}
+		case key.Matches(msg, m.KeyMap.CycleSort):
+			m.SortKey = (m.SortKey + 1) % (SortByExtension + 1)
+			return m.reload()
+		case key.Matches(msg, m.KeyMap.ReverseSort):
+			m.SortDescending = !m.SortDescending
+			return m.reload()
+		case key.Matches(msg, m.KeyMap.CycleAge):
+			m.cycleAge()
+			return m.reload()
+		case key.Matches(msg, m.KeyMap.CycleType):
+			m.TypeFilter = (m.TypeFilter + 1) % (FilesOnly + 1)
+			return m.reload()
+		case key.Matches(msg, m.KeyMap.ToggleHidden):
+			m.ShowHidden = !m.ShowHidden
+			return m.reload()
 		case key.Matches(msg, m.KeyMap.Back):
 			m.CurrentDirectory = filepath.Dir(m.CurrentDirectory)
 			if m.selectedStack.Length() > 0 {
@@ -321,7 +346,7 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 				m.min = 0
 				m.max = m.Height - 1
 			}
-			return m, m.readDir(m.CurrentDirectory, m.ShowHidden)
+			return m, m.readDir(m.CurrentDirectory)
 		case key.Matches(msg, m.KeyMap.Select):
 			if len(m.files) == 0 {
 				break
@@ -372,7 +397,7 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			m.selected = 0
 			m.min = 0
 			m.max = m.Height - 1
-			return m, m.readDir(m.CurrentDirectory, m.ShowHidden)
+			return m, m.readDir(m.CurrentDirectory)
 		}
 	}
 	return m, nil
@@ -503,6 +528,11 @@ func (m Model) didSelectFile(msg tea.Msg) (bool, string) {
 		return false, ""
 	}
 	switch msg := msg.(type) {
+	case tea.MouseMsg:
+		if !m.clickedSelected {
+			return false, ""
+		}
+		return m.canSelectHighlighted()
 	case tea.KeyMsg:
 		// If the msg does not match the Select keymap then this could not have been a selection.
 		if !key.Matches(msg, m.KeyMap.Select) {
@@ -511,33 +541,38 @@ func (m Model) didSelectFile(msg tea.Msg) (bool, string) {
 
 		// The key press was a selection, let's confirm whether the current file could
 		// be selected or used for navigating deeper into the stack.
-		f := m.files[m.selected]
-		info, err := f.Info()
+		return m.canSelectHighlighted()
+	}
+
+	// If the msg was not a KeyMsg or MouseMsg, then the file could not have been
+	// selected this iteration.
+	return false, ""
+}
+
+// canSelectHighlighted reports whether the entry under the cursor may be
+// selected given DirAllowed and FileAllowed.
+func (m Model) canSelectHighlighted() (bool, string) {
+	f := m.files[m.selected]
+	info, err := f.Info()
+	if err != nil {
+		return false, ""
+	}
+	isSymlink := info.Mode()&os.ModeSymlink != 0
+	isDir := f.IsDir()
+
+	if isSymlink {
+		symlinkPath, _ := filepath.EvalSymlinks(filepath.Join(m.CurrentDirectory, f.Name()))
+		info, err := os.Stat(symlinkPath)
 		if err != nil {
 			return false, ""
 		}
-		isSymlink := info.Mode()&os.ModeSymlink != 0
-		isDir := f.IsDir()
-
-		if isSymlink {
-			symlinkPath, _ := filepath.EvalSymlinks(filepath.Join(m.CurrentDirectory, f.Name()))
-			info, err := os.Stat(symlinkPath)
-			if err != nil {
-				break
-			}
-			if info.IsDir() {
-				isDir = true
-			}
-		}
-
-		if (!isDir && m.FileAllowed) || (isDir && m.DirAllowed) && m.Path != "" {
-			return true, m.Path
+		if info.IsDir() {
+			isDir = true
 		}
+	}
 
-		// If the msg was not a KeyMsg, then the file could not have been selected this iteration.
-		// Only a KeyMsg can select a file.
-	default:
-		return false, ""
+	if (!isDir && m.FileAllowed) || (isDir && m.DirAllowed) && m.Path != "" {
+		return true, m.Path
 	}
 	return false, ""
 }
@@ -554,7 +589,30 @@ func (m Model) canSelect(file string) bool {
 	}
 	return false
 }
+
+// SetCurrentDirectory jumps straight to dir, resetting the cursor and the
+// navigation history, and returns the command that reads its contents.
 func (m *Model) SetCurrentDirectory(dir string) tea.Cmd {
 	m.CurrentDirectory = dir
-	return m.readDir(m.CurrentDirectory, m.ShowHidden)
+	m.selected = 0
+	m.min = 0
+	m.max = max(m.Height-1, 0)
+	m.selectedStack = newStack()
+	m.minStack = newStack()
+	m.maxStack = newStack()
+	return m.readDir(m.CurrentDirectory)
+}
+
+// HighlightedPath returns the path of the entry under the cursor with
+// symlinks resolved, or "" if the directory is empty.
+func (m Model) HighlightedPath() string {
+	if m.selected < 0 || m.selected >= len(m.files) {
+		return ""
+	}
+
+	p := filepath.Join(m.CurrentDirectory, m.files[m.selected].Name())
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		return resolved
+	}
+	return p
 }
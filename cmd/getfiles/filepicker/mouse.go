@@ -0,0 +1,61 @@
+package filepicker
+
+import (
+	"path"
+	"path/filepath"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func (m *Model) moveDown() {
+	m.selected++
+	if m.selected >= len(m.files) {
+		m.selected = len(m.files) - 1
+	}
+	if m.selected > m.max {
+		m.min++
+		m.max++
+	}
+}
+
+func (m *Model) moveUp() {
+	m.selected--
+	if m.selected < 0 {
+		m.selected = 0
+	}
+	if m.selected < m.min {
+		m.min--
+		m.max--
+	}
+}
+
+// handleMouse scrolls with the wheel and highlights the clicked row. Clicking
+// the row that is already highlighted selects it. The message's Y is relative
+// to the top of the picker's view.
+func (m *Model) handleMouse(msg tea.MouseMsg) {
+	if msg.Action != tea.MouseActionPress || len(m.files) == 0 {
+		return
+	}
+
+	switch msg.Button {
+	case tea.MouseButtonWheelDown:
+		m.moveDown()
+	case tea.MouseButtonWheelUp:
+		m.moveUp()
+	case tea.MouseButtonLeft:
+		index := m.min + msg.Y
+		if msg.Y < 0 || index > m.max || index >= len(m.files) {
+			return
+		}
+
+		if index != m.selected {
+			m.selected = index
+			return
+		}
+
+		f := m.files[m.selected]
+		symlinkPath, _ := filepath.EvalSymlinks(filepath.Join(m.CurrentDirectory, f.Name()))
+		m.Path = path.Clean(symlinkPath)
+		m.clickedSelected = true
+	}
+}
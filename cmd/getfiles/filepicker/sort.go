@@ -0,0 +1,182 @@
+package filepicker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SortKey is the attribute directory entries are ordered by. Directories are
+// always listed before files.
+type SortKey int
+
+const (
+	SortByName SortKey = iota
+	SortBySize
+	SortByModTime
+	SortByExtension
+)
+
+func (k SortKey) String() string {
+	switch k {
+	case SortBySize:
+		return "size"
+	case SortByModTime:
+		return "modified"
+	case SortByExtension:
+		return "extension"
+	default:
+		return "name"
+	}
+}
+
+// TypeFilter restricts the kind of entries that are listed.
+type TypeFilter int
+
+const (
+	AllTypes TypeFilter = iota
+	DirsOnly
+	FilesOnly
+)
+
+func (t TypeFilter) String() string {
+	switch t {
+	case DirsOnly:
+		return "directories"
+	case FilesOnly:
+		return "files"
+	default:
+		return "all"
+	}
+}
+
+// DefaultAgeFilters are the modification age limits cycled through by the
+// CycleAge key. Zero means no limit.
+var DefaultAgeFilters = []time.Duration{0, 24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour}
+
+type entryInfo struct {
+	entry os.DirEntry
+	info  os.FileInfo
+}
+
+// filterAndSort applies the hidden, type and age filters and then the sort
+// order to the entries of dir. The age filter only applies to files so
+// directories stay navigable. Symlinks are compared by their targets.
+func (m Model) filterAndSort(dir string, entries []os.DirEntry) []os.DirEntry {
+	cutoff := time.Time{}
+	if m.MaxAge > 0 {
+		cutoff = time.Now().Add(-m.MaxAge)
+	}
+
+	infos := make([]entryInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !m.ShowHidden {
+			if isHidden, _ := IsHidden(entry.Name()); isHidden {
+				continue
+			}
+		}
+		if m.TypeFilter == DirsOnly && !entry.IsDir() || m.TypeFilter == FilesOnly && entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(filepath.Join(dir, entry.Name())); err == nil {
+				info = target
+			}
+		}
+		if !cutoff.IsZero() && !entry.IsDir() && info.ModTime().Before(cutoff) {
+			continue
+		}
+		infos = append(infos, entryInfo{entry: entry, info: info})
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.entry.IsDir() != b.entry.IsDir() {
+			return a.entry.IsDir()
+		}
+
+		less, equal := m.compare(a, b)
+		if equal {
+			return a.entry.Name() < b.entry.Name()
+		}
+		return less != m.SortDescending
+	})
+
+	sorted := make([]os.DirEntry, len(infos))
+	for i, e := range infos {
+		sorted[i] = e.entry
+	}
+	return sorted
+}
+
+// compare reports whether a sorts before b by the current key, and whether
+// they are equal by it.
+func (m Model) compare(a, b entryInfo) (bool, bool) {
+	switch m.SortKey {
+	case SortBySize:
+		return a.info.Size() < b.info.Size(), a.info.Size() == b.info.Size()
+	case SortByModTime:
+		return a.info.ModTime().Before(b.info.ModTime()), a.info.ModTime().Equal(b.info.ModTime())
+	case SortByExtension:
+		extA := strings.ToLower(filepath.Ext(a.entry.Name()))
+		extB := strings.ToLower(filepath.Ext(b.entry.Name()))
+		return extA < extB, extA == extB
+	default:
+		return a.entry.Name() < b.entry.Name(), a.entry.Name() == b.entry.Name()
+	}
+}
+
+// cycleAge moves MaxAge to the next entry of AgeFilters.
+func (m *Model) cycleAge() {
+	if len(m.AgeFilters) == 0 {
+		return
+	}
+	next := 0
+	for i, age := range m.AgeFilters {
+		if age == m.MaxAge {
+			next = (i + 1) % len(m.AgeFilters)
+			break
+		}
+	}
+	m.MaxAge = m.AgeFilters[next]
+}
+
+// OptionsSummary describes the current sort order and filters.
+func (m Model) OptionsSummary() string {
+	order := "↑"
+	if m.SortDescending {
+		order = "↓"
+	}
+
+	age := "any age"
+	if m.MaxAge > 0 {
+		age = "changed in last " + formatAge(m.MaxAge)
+	}
+
+	hidden := "hidden shown"
+	if !m.ShowHidden {
+		hidden = "hidden excluded"
+	}
+
+	return fmt.Sprintf("Sort: %s %s • %s • type: %s • %s", m.SortKey, order, age, m.TypeFilter, hidden)
+}
+
+func formatAge(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	switch {
+	case days == 1:
+		return "day"
+	case days > 1:
+		return fmt.Sprintf("%d days", days)
+	default:
+		return d.String()
+	}
+}
@@ -1,17 +1,35 @@
 package getfiles
 
 import (
+	"context"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/Chanadu/backup-tui/cmd/getfiles/filepicker"
+	"github.com/Chanadu/backup-tui/cmd/getfiles/locations"
+	"github.com/Chanadu/backup-tui/cmd/getfiles/preview"
+	"github.com/Chanadu/backup-tui/cmd/stage"
 	"github.com/Chanadu/backup-tui/cmd/utils"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 )
 
+const (
+	defaultWidth    = 100
+	minPreviewWidth = 30
+)
+
+var previewStyle = lipgloss.NewStyle().
+	BorderStyle(lipgloss.NormalBorder()).
+	BorderLeft(true).
+	BorderForeground(lipgloss.Color("240")).
+	PaddingLeft(1).
+	MarginLeft(2)
+
 // Message sent when files are selected
 type FilesSelectedMsg struct {
 	Paths []string
@@ -26,6 +44,23 @@ type FileSelectorModel struct {
 	Dir              string
 	UsingFilteredDir bool
 
+	// Locations panel and jump-to-path state, see locations-panel.go.
+	mode          selectorMode
+	Bookmarks     []string
+	Recent        []string
+	locationIndex int
+	Jump          textinput.Model
+	jumpMatches   []string
+	status        string
+
+	// Preview of the highlighted entry, loaded asynchronously.
+	previewPath string
+	preview     string
+	width       int
+	// cancelPreview stops loading the preview of previewPath, nil if none
+	// is loading.
+	cancelPreview context.CancelFunc
+
 	tempDir string
 }
 
@@ -43,6 +78,11 @@ func initialFilePicker(dir string) filepicker.Model {
 	fp.KeyMap.Up.SetKeys("up", "ctrl+k")
 	fp.KeyMap.Down.SetKeys("down", "ctrl+j")
 	fp.KeyMap.Back.SetKeys("left", "ctrl+h")
+	fp.KeyMap.CycleSort.SetKeys("alt+s")
+	fp.KeyMap.ReverseSort.SetKeys("alt+r")
+	fp.KeyMap.CycleAge.SetKeys("alt+a")
+	fp.KeyMap.CycleType.SetKeys("alt+t")
+	fp.KeyMap.ToggleHidden.SetKeys("alt+h")
 	// fp.KeyMap.Open.SetKeys("right", "ctrl+l")
 	// fp.KeyMap.Select.SetKeys("enter")
 
@@ -69,14 +109,22 @@ func InitialFilesSelectorModel(paths []string, tempDir string) FileSelectorModel
 	search.Width = 30
 	search.Focus()
 
+	jump := textinput.New()
+	jump.Prompt = "Go to: "
+	jump.Placeholder = "~/path/to/dir"
+	jump.CharLimit = 256
+	jump.Width = 50
+
 	fp := initialFilePicker("")
 
 	return FileSelectorModel{
 		Picker:           fp,
 		SelectedPaths:    paths,
 		Search:           search,
+		Jump:             jump,
 		Dir:              fp.CurrentDirectory,
 		UsingFilteredDir: false,
+		width:            defaultWidth,
 		tempDir:          tempDir,
 	}
 }
@@ -98,8 +146,8 @@ func (m FileSelectorModel) createFilteredSymlinkDir(srcDir string, query string)
 		if lowerQuery == "" || strings.Contains(name, lowerQuery) ||
 			strings.Contains(ext, lowerQuery) {
 
-			srcPath := filepath.Join(srcDir, name)
-			dstPath := filepath.Join(m.tempDir, name)
+			srcPath := filepath.Join(srcDir, file.Name())
+			dstPath := filepath.Join(m.tempDir, file.Name())
 
 			err := os.Symlink(srcPath, dstPath)
 
@@ -147,9 +195,41 @@ func (m FileSelectorModel) Update(msg tea.Msg) (FileSelectorModel, tea.Cmd) {
 	}
 
 	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case preview.Msg:
+		if msg.Path == m.previewPath {
+			m.preview = msg.Content
+		}
+		return m, nil
+	case tea.MouseMsg:
+		if m.Prompting || m.mode != modeBrowse {
+			return m, nil
+		}
+		// The picker expects coordinates relative to its own view.
+		msg.Y -= strings.Count(m.headerView(), "\n")
+		return m.updatePicker(msg, cmds)
 	case tea.KeyMsg:
 		strMsg := msg.String()
 
+		if !m.Prompting {
+			if handled, model, cmd := m.handleLocationKeys(msg); handled {
+				return model, cmd
+			}
+
+			// Sort and filter keys only go to the picker so they don't end
+			// up in the search box.
+			keys := m.Picker.KeyMap
+			if key.Matches(msg, keys.CycleSort, keys.ReverseSort, keys.CycleAge, keys.CycleType, keys.ToggleHidden) {
+				m.Picker, cmd = m.Picker.Update(msg)
+				return m, cmd
+			}
+		}
+
+		if strMsg == "esc" {
+			return m, stage.BackCmd
+		}
+
 		if !m.Prompting && strMsg != " " {
 			m, cmd = m.handleSearch(msg)
 			cmds = append(cmds, cmd)
@@ -158,11 +238,16 @@ func (m FileSelectorModel) Update(msg tea.Msg) (FileSelectorModel, tea.Cmd) {
 
 		switch strMsg {
 		case "y":
+			m.stopPreview()
 			m = InitialFilesSelectorModel(m.SelectedPaths, m.tempDir)
 			return m, m.Picker.Init()
 		case "n":
 			m.Done = true
+			m.stopPreview()
 			utils.ClearDir(m.tempDir)
+			if err := locations.AddRecent(m.SelectedPaths...); err != nil {
+				log.Printf("Couldn't save recent paths, error: %v", err)
+			}
 			return m, func() tea.Msg {
 				return FilesSelectedMsg{Paths: m.SelectedPaths}
 			}
@@ -173,18 +258,45 @@ func (m FileSelectorModel) Update(msg tea.Msg) (FileSelectorModel, tea.Cmd) {
 		return m, tea.Batch(cmds...)
 	}
 
+	return m.updatePicker(msg, cmds)
+}
+
+// updatePicker forwards msg to the picker, recording selections and loading
+// the preview when the highlighted entry changes.
+func (m FileSelectorModel) updatePicker(msg tea.Msg, cmds []tea.Cmd) (FileSelectorModel, tea.Cmd) {
+	var cmd tea.Cmd
 	m.Picker, cmd = m.Picker.Update(msg)
 	cmds = append(cmds, cmd)
 
 	if didSelect, path := m.Picker.DidSelectFile(msg); didSelect {
-		m.SelectedPaths = append(m.SelectedPaths, path)
+		m.SelectedPaths = append(m.SelectedPaths, m.realPath(path))
 		m.Prompting = true
 	}
 
+	if highlighted := m.Picker.HighlightedPath(); highlighted != m.previewPath {
+		m.previewPath = highlighted
+		m.preview = "Loading preview..."
+		m.stopPreview()
+		if highlighted != "" {
+			var ctx context.Context
+			ctx, m.cancelPreview = context.WithCancel(context.Background())
+			cmds = append(cmds, preview.Load(ctx, highlighted, m.Picker.Height))
+		}
+	}
+
 	return m, tea.Batch(cmds...)
 }
 
-func (m FileSelectorModel) View() string {
+// stopPreview stops loading the preview, which is no longer wanted.
+func (m *FileSelectorModel) stopPreview() {
+	if m.cancelPreview != nil {
+		m.cancelPreview()
+		m.cancelPreview = nil
+	}
+}
+
+// headerView renders everything above the picker or prompt.
+func (m FileSelectorModel) headerView() string {
 	var s strings.Builder
 
 	if !m.Prompting {
@@ -210,13 +322,50 @@ func (m FileSelectorModel) View() string {
 
 	}
 
+	if !m.Done && !m.Prompting {
+		s.WriteString(m.Picker.Styles.Permission.Render(m.Picker.OptionsSummary()) + "\n")
+	}
+
+	return s.String()
+}
+
+func (m FileSelectorModel) View() string {
+	var s strings.Builder
+
+	if m.mode != modeBrowse && !m.Prompting && !m.Done {
+		return m.locationsView()
+	}
+
+	s.WriteString(m.headerView())
+
 	if m.Done {
 		s.WriteString("File selection complete.")
 	} else if m.Prompting {
 		s.WriteString("Pick another file? (y/n)\n")
 	} else {
-		s.WriteString(m.Picker.View())
+		s.WriteString(m.browserView())
+		if m.status != "" {
+			s.WriteString(m.status + "\n")
+		}
+		s.WriteString("ctrl+b: bookmarks & recent • ctrl+g: go to path • ctrl+s: bookmark this directory\n")
+		s.WriteString("alt+s: sort by • alt+r: reverse • alt+a: age filter • alt+t: type filter • alt+h: hidden files\n")
 	}
 
 	return s.String()
 }
+
+// browserView lays the picker out beside the preview of the highlighted entry.
+func (m FileSelectorModel) browserView() string {
+	pickerView := m.Picker.View()
+	if m.previewPath == "" {
+		return pickerView
+	}
+
+	previewWidth := max(m.width-lipgloss.Width(pickerView)-previewStyle.GetHorizontalFrameSize(), minPreviewWidth)
+	content := lipgloss.NewStyle().
+		MaxWidth(previewWidth).
+		MaxHeight(max(lipgloss.Height(pickerView)-1, 1)).
+		Render(m.preview)
+
+	return lipgloss.JoinHorizontal(lipgloss.Top, pickerView, previewStyle.Render(content)) + "\n"
+}
@@ -0,0 +1,220 @@
+package getfiles
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/Chanadu/backup-tui/cmd/getfiles/locations"
+	"github.com/Chanadu/backup-tui/cmd/utils"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type selectorMode int
+
+const (
+	modeBrowse selectorMode = iota
+	modeLocations
+	modeJump
+)
+
+// currentDir is the real directory being browsed, even while the picker is
+// showing the filtered symlink dir.
+func (m FileSelectorModel) currentDir() string {
+	return m.realPath(m.Picker.CurrentDirectory)
+}
+
+// realPath maps a path inside the filtered symlink dir to the path it links
+// to, so nothing refers to the temp dir once it is cleared.
+func (m FileSelectorModel) realPath(path string) string {
+	rel, err := filepath.Rel(m.tempDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(m.Dir, rel)
+}
+
+// locationEntries lists bookmarks followed by recent paths, in panel order.
+func (m FileSelectorModel) locationEntries() []string {
+	return append(append([]string{}, m.Bookmarks...), m.Recent...)
+}
+
+func (m FileSelectorModel) openLocations() FileSelectorModel {
+	var err error
+	m.Bookmarks, err = locations.LoadBookmarks()
+	if err != nil {
+		log.Printf("Couldn't load bookmarks, error: %v", err)
+	}
+	m.Recent, err = locations.LoadRecent()
+	if err != nil {
+		log.Printf("Couldn't load recent paths, error: %v", err)
+	}
+
+	m.mode = modeLocations
+	m.locationIndex = 0
+	m.status = ""
+	return m
+}
+
+// jumpTo moves the picker straight to dir, leaving any filtered view.
+func (m FileSelectorModel) jumpTo(dir string) (FileSelectorModel, tea.Cmd) {
+	resolved, err := locations.Resolve(dir)
+	if err != nil {
+		m.status = fmt.Sprintf("Can't open %s: %v", dir, err)
+		return m, nil
+	}
+
+	log.Printf("Jumping to %s", resolved)
+	m.mode = modeBrowse
+	m.status = ""
+	m.Dir = resolved
+	m.Search.SetValue("")
+	m.UsingFilteredDir = false
+	utils.ClearDir(m.tempDir)
+
+	return m, m.Picker.SetCurrentDirectory(resolved)
+}
+
+// handleLocationKeys handles the bookmark, recent and jump-to-path keys. It
+// reports whether the key was consumed.
+func (m FileSelectorModel) handleLocationKeys(msg tea.KeyMsg) (bool, FileSelectorModel, tea.Cmd) {
+	switch m.mode {
+	case modeLocations:
+		model, cmd := m.updateLocations(msg)
+		return true, model, cmd
+	case modeJump:
+		model, cmd := m.updateJump(msg)
+		return true, model, cmd
+	}
+
+	switch msg.String() {
+	case "ctrl+b":
+		return true, m.openLocations(), nil
+	case "ctrl+g":
+		m.mode = modeJump
+		m.status = ""
+		m.jumpMatches = nil
+		m.Jump.SetValue(m.currentDir() + string(filepath.Separator))
+		m.Jump.CursorEnd()
+		return true, m, m.Jump.Focus()
+	case "ctrl+s":
+		dir := m.currentDir()
+		added, err := locations.ToggleBookmark(dir)
+		switch {
+		case err != nil:
+			m.status = fmt.Sprintf("Couldn't save bookmark: %v", err)
+		case added:
+			m.status = "Bookmarked " + dir
+		default:
+			m.status = "Removed bookmark " + dir
+		}
+		return true, m, nil
+	}
+
+	return false, m, nil
+}
+
+func (m FileSelectorModel) updateLocations(msg tea.KeyMsg) (FileSelectorModel, tea.Cmd) {
+	entries := m.locationEntries()
+
+	switch msg.String() {
+	case "esc", "ctrl+b":
+		m.mode = modeBrowse
+	case "up", "ctrl+k":
+		m.locationIndex = max(m.locationIndex-1, 0)
+	case "down", "ctrl+j":
+		m.locationIndex = min(m.locationIndex+1, max(len(entries)-1, 0))
+	case "enter":
+		if len(entries) == 0 {
+			break
+		}
+		return m.jumpTo(entries[m.locationIndex])
+	case "d", "delete":
+		if m.locationIndex >= len(m.Bookmarks) {
+			break
+		}
+		if err := locations.RemoveBookmark(m.Bookmarks[m.locationIndex]); err != nil {
+			m.status = fmt.Sprintf("Couldn't remove bookmark: %v", err)
+			break
+		}
+		index := m.locationIndex
+		m = m.openLocations()
+		m.locationIndex = min(index, max(len(m.locationEntries())-1, 0))
+	}
+
+	return m, nil
+}
+
+func (m FileSelectorModel) updateJump(msg tea.KeyMsg) (FileSelectorModel, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.mode = modeBrowse
+		m.Jump.Blur()
+		return m, nil
+	case "enter":
+		m.Jump.Blur()
+		return m.jumpTo(m.Jump.Value())
+	case "tab":
+		completed, matches := locations.Complete(m.Jump.Value())
+		m.Jump.SetValue(completed)
+		m.Jump.CursorEnd()
+		m.jumpMatches = matches
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.Jump, cmd = m.Jump.Update(msg)
+	m.jumpMatches = nil
+	return m, cmd
+}
+
+func (m FileSelectorModel) locationsView() string {
+	var s strings.Builder
+
+	if m.mode == modeJump {
+		s.WriteString(m.Jump.View())
+		s.WriteString("\n")
+		if len(m.jumpMatches) > 1 {
+			s.WriteString(m.Picker.Styles.Permission.Render(strings.Join(m.jumpMatches, "  ")))
+			s.WriteString("\n")
+		}
+		if m.status != "" {
+			s.WriteString(m.status + "\n")
+		}
+		s.WriteString("\ntab: complete • enter: go • esc: cancel\n")
+		return s.String()
+	}
+
+	writeEntry := func(i int, path string) {
+		if i == m.locationIndex {
+			s.WriteString(m.Picker.Styles.Cursor.Render(m.Picker.Cursor) + " " + m.Picker.Styles.Selected.Render(path))
+		} else {
+			s.WriteString("  " + m.Picker.Styles.Directory.Render(path))
+		}
+		s.WriteString("\n")
+	}
+
+	s.WriteString("Bookmarks:\n")
+	if len(m.Bookmarks) == 0 {
+		s.WriteString(m.Picker.Styles.EmptyDirectory.Render("No bookmarks yet, press ctrl+s in a directory to add one.") + "\n")
+	}
+	for i, path := range m.Bookmarks {
+		writeEntry(i, path)
+	}
+
+	s.WriteString("\nRecent:\n")
+	if len(m.Recent) == 0 {
+		s.WriteString(m.Picker.Styles.EmptyDirectory.Render("Nothing selected yet.") + "\n")
+	}
+	for i, path := range m.Recent {
+		writeEntry(len(m.Bookmarks)+i, path)
+	}
+
+	if m.status != "" {
+		s.WriteString("\n" + m.status + "\n")
+	}
+	s.WriteString("\nenter: jump • d: remove bookmark • esc: close\n")
+
+	return s.String()
+}
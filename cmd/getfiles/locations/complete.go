@@ -0,0 +1,98 @@
+package locations
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandHome replaces a leading "~" in path with the user's home directory.
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+// Complete performs shell-style tab completion of a directory path. It returns
+// the input extended by the longest common prefix of all matching directories,
+// and the matches themselves so they can be listed to the user.
+func Complete(input string) (string, []string) {
+	expanded := ExpandHome(input)
+	dir, prefix := filepath.Split(expanded)
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return input, nil
+	}
+
+	matches := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		if isDir(filepath.Join(dir, name)) {
+			matches = append(matches, name)
+		}
+	}
+
+	if len(matches) == 0 {
+		return input, matches
+	}
+
+	common := matches[0]
+	for _, match := range matches[1:] {
+		common = commonPrefix(common, match)
+	}
+
+	completed := input + strings.TrimPrefix(common, prefix)
+	if len(matches) == 1 {
+		completed += string(filepath.Separator)
+	}
+	return completed, matches
+}
+
+// Resolve turns user input into a directory to jump to. Paths to files
+// resolve to the directory containing them.
+func Resolve(input string) (string, error) {
+	path, err := filepath.Abs(ExpandHome(strings.TrimSpace(input)))
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		path = filepath.Dir(path)
+	}
+	return path, nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func commonPrefix(a, b string) string {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return a[:i]
+		}
+	}
+	return a[:n]
+}
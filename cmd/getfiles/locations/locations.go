@@ -0,0 +1,139 @@
+package locations
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/Chanadu/backup-tui/cmd/utils"
+)
+
+const (
+	bookmarksFile = "bookmarks.json"
+	recentFile    = "recent.json"
+
+	// MaxRecent is how many recently selected paths are remembered.
+	MaxRecent = 10
+)
+
+func readList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func writeList(path string, list []string) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func bookmarksPath() (string, error) {
+	dir, err := utils.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, bookmarksFile), nil
+}
+
+func recentPath() (string, error) {
+	dir, err := utils.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, recentFile), nil
+}
+
+// LoadBookmarks returns the persisted favourite directories.
+func LoadBookmarks() ([]string, error) {
+	path, err := bookmarksPath()
+	if err != nil {
+		return nil, err
+	}
+	return readList(path)
+}
+
+// ToggleBookmark adds dir to the bookmarks, or removes it if it is already
+// bookmarked. It reports whether dir is bookmarked afterwards.
+func ToggleBookmark(dir string) (bool, error) {
+	path, err := bookmarksPath()
+	if err != nil {
+		return false, err
+	}
+	bookmarks, err := readList(path)
+	if err != nil {
+		return false, err
+	}
+
+	dir = filepath.Clean(dir)
+	added := !slices.Contains(bookmarks, dir)
+	if added {
+		bookmarks = append(bookmarks, dir)
+	} else {
+		bookmarks = slices.DeleteFunc(bookmarks, func(b string) bool { return b == dir })
+	}
+
+	return added, writeList(path, bookmarks)
+}
+
+// RemoveBookmark removes dir from the bookmarks.
+func RemoveBookmark(dir string) error {
+	path, err := bookmarksPath()
+	if err != nil {
+		return err
+	}
+	bookmarks, err := readList(path)
+	if err != nil {
+		return err
+	}
+
+	bookmarks = slices.DeleteFunc(bookmarks, func(b string) bool { return b == dir })
+	return writeList(path, bookmarks)
+}
+
+// LoadRecent returns the most recently selected paths, newest first.
+func LoadRecent() ([]string, error) {
+	path, err := recentPath()
+	if err != nil {
+		return nil, err
+	}
+	return readList(path)
+}
+
+// AddRecent records paths as the most recently selected ones, keeping at most
+// MaxRecent entries without duplicates.
+func AddRecent(paths ...string) error {
+	path, err := recentPath()
+	if err != nil {
+		return err
+	}
+	recent, err := readList(path)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		recent = slices.DeleteFunc(recent, func(r string) bool { return r == p })
+		recent = slices.Insert(recent, 0, p)
+	}
+	if len(recent) > MaxRecent {
+		recent = recent[:MaxRecent]
+	}
+
+	return writeList(path, recent)
+}
@@ -0,0 +1,207 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	sniffLen = 8 * 1024
+
+	// maxWalkEntries bounds how much of a directory tree is walked to
+	// compute its size, so huge trees still preview quickly.
+	maxWalkEntries = 20000
+)
+
+// Msg carries a rendered preview back to the file selector.
+type Msg struct {
+	Path    string
+	Content string
+}
+
+// Load returns a command rendering the preview of path in the background,
+// cut short once ctx is done.
+func Load(ctx context.Context, path string, height int) tea.Cmd {
+	return func() tea.Msg {
+		return Msg{
+			Path:    path,
+			Content: Render(ctx, path, height),
+		}
+	}
+}
+
+// Render builds the preview of path: a child listing for directories, the
+// first lines for text files and the detected type for anything else.
+// Sizing a directory stops early once ctx is done.
+func Render(ctx context.Context, path string, height int) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("Can't preview: %v", err)
+	}
+
+	if info.IsDir() {
+		return renderDir(ctx, path, info, height)
+	}
+	return renderFile(path, info, height)
+}
+
+func renderDir(ctx context.Context, path string, info fs.FileInfo, height int) string {
+	var s strings.Builder
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Sprintf("Can't read directory: %v", err)
+	}
+
+	size, complete := dirSize(ctx, path)
+	sizeStr := humanize.Bytes(uint64(size)) //nolint:gosec
+	if !complete {
+		sizeStr = "≥ " + sizeStr
+	}
+
+	fmt.Fprintf(&s, "Directory, %d entries\n", len(entries))
+	fmt.Fprintf(&s, "Size:     %s\n", sizeStr)
+	fmt.Fprintf(&s, "Modified: %s\n\n", info.ModTime().Format("2006-01-02 15:04"))
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() == entries[j].IsDir() {
+			return entries[i].Name() < entries[j].Name()
+		}
+		return entries[i].IsDir()
+	})
+
+	limit := max(height-4, 1)
+	for i, entry := range entries {
+		if i == limit {
+			fmt.Fprintf(&s, "… %d more\n", len(entries)-limit)
+			break
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		s.WriteString(name + "\n")
+	}
+
+	return s.String()
+}
+
+// dirSize sums the sizes of the files under path. It reports false if the
+// walk was cut short, by its size or by ctx being done.
+func dirSize(ctx context.Context, path string) (int64, bool) {
+	var size int64
+	seen := 0
+	complete := true
+
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		seen++
+		if seen > maxWalkEntries || ctx.Err() != nil {
+			complete = false
+			return filepath.SkipAll
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+
+	return size, complete
+}
+
+func renderFile(path string, info fs.FileInfo, height int) string {
+	var s strings.Builder
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Sprintf("Can't open file: %v", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Sprintf("Can't read file: %v", err)
+	}
+	head = head[:n]
+
+	size := humanize.Bytes(uint64(info.Size())) //nolint:gosec
+	modified := info.ModTime().Format("2006-01-02 15:04")
+
+	if !isText(head) {
+		fmt.Fprintf(&s, "Binary file\n")
+		fmt.Fprintf(&s, "Type:     %s\n", http.DetectContentType(head))
+		fmt.Fprintf(&s, "Size:     %s\n", size)
+		fmt.Fprintf(&s, "Modified: %s\n", modified)
+		return s.String()
+	}
+
+	fmt.Fprintf(&s, "%s, modified %s\n\n", size, modified)
+
+	lines := strings.Split(string(head), "\n")
+	// The last line may have been cut off by the sniff buffer.
+	if n == sniffLen && len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+	lines = lines[:min(len(lines), max(height-2, 1))]
+	text := strings.ReplaceAll(strings.Join(lines, "\n"), "\t", "    ")
+
+	s.WriteString(highlight(path, text))
+	return s.String()
+}
+
+func isText(head []byte) bool {
+	if bytes.IndexByte(head, 0) != -1 {
+		return false
+	}
+	// Allow a multi-byte rune to be cut off at the end of the buffer.
+	for i := 0; i < utf8.UTFMax && len(head) > 0; i++ {
+		if utf8.Valid(head) {
+			return true
+		}
+		head = head[:len(head)-1]
+	}
+	return utf8.Valid(head)
+}
+
+// highlight syntax highlights text for the terminal, returning it unchanged
+// if no lexer matches the file.
+func highlight(path, text string) string {
+	lexer := lexers.Match(filepath.Base(path))
+	if lexer == nil {
+		lexer = lexers.Analyse(text)
+	}
+	if lexer == nil {
+		return text
+	}
+
+	iterator, err := lexer.Tokenise(nil, text)
+	if err != nil {
+		return text
+	}
+
+	var out bytes.Buffer
+	if err := formatters.TTY256.Format(&out, styles.Get("monokai"), iterator); err != nil {
+		return text
+	}
+	return out.String()
+}
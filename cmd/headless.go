@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Chanadu/backup-tui/cmd/history"
+	"github.com/Chanadu/backup-tui/cmd/jobs"
+	"github.com/Chanadu/backup-tui/pkg/engine"
+	"github.com/Chanadu/backup-tui/pkg/lock"
+	"golang.org/x/term"
+)
+
+// PasswordEnv is the environment variable the headless runner reads
+// destination passwords and secret keys from. PasswordEnv_<n> sets the
+// password of the n-th destination only, taking precedence.
+const PasswordEnv = "BACKUP_TUI_PASSWORD"
+
+// RepositoryPasswordEnv is the environment variable the password of an
+// encrypted repository is read from.
+const RepositoryPasswordEnv = "BACKUP_TUI_REPO_PASSWORD"
+
+// readPassword returns the password of the n-th (1-based) destination from
+// the environment, or prompts for it if stdin is a terminal.
+func readPassword(stdin *bufio.Reader, n int, label string) (string, error) {
+	if password, ok := os.LookupEnv(fmt.Sprintf("%s_%d", PasswordEnv, n)); ok {
+		return password, nil
+	}
+	return promptPassword(stdin, PasswordEnv, label)
+}
+
+// promptPassword returns the password in env, or prompts for it if stdin is
+// a terminal, or reads a line of stdin.
+func promptPassword(stdin *bufio.Reader, env, label string) (string, error) {
+	if password, ok := os.LookupEnv(env); ok {
+		return password, nil
+	}
+
+	fd := int(os.Stdin.Fd()) //nolint:gosec
+	if term.IsTerminal(fd) {
+		fmt.Printf("Password for %s: ", label)
+		password, err := term.ReadPassword(fd)
+		fmt.Println()
+		return string(password), err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("no password for %s: set %s or pass it on stdin", label, env)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// loadJob loads the saved job called name and reads the passwords of the
+// destinations that need one, and of its repository if it is encrypted.
+func loadJob(name string) (jobs.Job, []string, error) {
+	job, err := jobs.Load(name)
+	if err != nil {
+		return jobs.Job{}, nil, err
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	passwords := make([]string, len(job.Destinations))
+	for i, dest := range job.Destinations {
+		if !dest.NeedsPassword() {
+			continue
+		}
+		passwords[i], err = readPassword(stdin, i+1, dest.String())
+		if err != nil {
+			return jobs.Job{}, nil, err
+		}
+	}
+
+	if job.Repository.Enabled && job.Repository.Encrypt {
+		job.RepositoryPassword, err = promptPassword(stdin, RepositoryPasswordEnv, "the repository")
+		if err != nil {
+			return jobs.Job{}, nil, err
+		}
+	}
+	return job, passwords, nil
+}
+
+// lockRetryInterval is how often a run waiting for a job's lock retries.
+const lockRetryInterval = 10 * time.Second
+
+// lockJob takes the lock of job. If another run holds it, lockJob fails or,
+// with wait, retries until the lock is free.
+func lockJob(ctx context.Context, job engine.Job, wait bool) (*jobs.Lock, error) {
+	for {
+		l, err := jobs.AcquireLock(ctx, job)
+		var held *lock.HeldError
+		if !wait || !errors.As(err, &held) {
+			return l, err
+		}
+
+		log.Printf("Waiting for lock of %s: %v", job.Name, err)
+		fmt.Printf("waiting, %v\n", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// runJob runs job through the engine, printing its events prefixed with
+// prefix, recording the run in the history and telling the job's notifiers
+// how it ended. The job is locked for the
+// run, if another run holds its lock runJob fails or, with wait, waits.
+func runJob(ctx context.Context, job jobs.Job, passwords []string, prefix string, wait bool) error {
+	engineJob := job.EngineJob(passwords, "")
+	l, err := lockJob(ctx, engineJob, wait)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	recorder := history.NewRecorder(engineJob)
+	events := make(chan engine.Event)
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		for event := range events {
+			line := fmt.Sprint(event)
+			if prefix != "" {
+				line = prefix + " " + line
+			}
+			log.Println(line)
+			fmt.Println(line)
+			recorder.Observe(event)
+		}
+	}()
+
+	log.Printf("Running job %s headless", job.Name)
+	err = engine.Run(ctx, engineJob, events)
+	<-printed
+	recorder.Finish(err)
+	if notifyErr := notifyRun(withPasswords(job.Notify), recorder.Run()); notifyErr != nil {
+		fmt.Fprintln(os.Stderr, "notifying:", notifyErr)
+	}
+	return err
+}
+
+// RunHeadless runs a saved job without the TUI, printing its events as they
+// happen. It returns the process exit code.
+func RunHeadless(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	wait := flags.Bool("wait", false, "wait for another run of the job to finish instead of failing")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: backup-tui run [-wait] <job>")
+		fmt.Fprintf(flags.Output(), "Passwords are read from $%s_<n> or $%s, or prompted for.\n", PasswordEnv, PasswordEnv)
+		fmt.Fprintf(flags.Output(), "The password of an encrypted repository is read from $%s.\n", RepositoryPasswordEnv)
+		fmt.Fprintf(flags.Output(), "The password of email notifiers is read from $%s.\n", SMTPPasswordEnv)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	job, passwords, err := loadJob(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+
+	err = runJob(ctx, job, passwords, "", *wait)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "cancelled")
+		return 130
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	fmt.Println("backup finished")
+	return 0
+}
@@ -0,0 +1,244 @@
+// Package history records every backup run in a JSON lines file under the
+// state dir, so past runs can be looked at after the program exits.
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Chanadu/backup-tui/cmd/utils"
+	"github.com/Chanadu/backup-tui/pkg/engine"
+)
+
+const fileName = "history.jsonl"
+
+// Status is how a run ended.
+type Status string
+
+const (
+	StatusSuccess   Status = "success"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Statuses are all statuses, in the order the history view filters by them.
+var Statuses = []Status{StatusSuccess, StatusFailed, StatusCancelled}
+
+// Archive is an archive or snapshot made by a run.
+type Archive struct {
+	// Name is the archive's file name, or the snapshot ID in repository
+	// mode.
+	Name     string `json:"name"`
+	Path     string `json:"path,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Checksum string `json:"sha256,omitempty"`
+	// Destinations are the destinations it reached.
+	Destinations []string `json:"destinations,omitempty"`
+}
+
+// Run is one recorded backup run.
+type Run struct {
+	Job          string    `json:"job,omitempty"`
+	Started      time.Time `json:"started"`
+	Finished     time.Time `json:"finished"`
+	Status       Status    `json:"status"`
+	Paths        []string  `json:"paths"`
+	Destinations []string  `json:"destinations"`
+	Archives     []Archive `json:"archives,omitempty"`
+	Errors       []string  `json:"errors,omitempty"`
+}
+
+// Duration is how long the run took.
+func (r Run) Duration() time.Duration {
+	return r.Finished.Sub(r.Started)
+}
+
+// Size is the total size of the run's archives.
+func (r Run) Size() int64 {
+	var size int64
+	for _, archive := range r.Archives {
+		size += archive.Size
+	}
+	return size
+}
+
+func path() (string, error) {
+	stateDir, err := utils.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, fileName), nil
+}
+
+// Append adds run to the history.
+func Append(run Run) error {
+	p, err := path()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load returns all recorded runs, newest first. Lines that can't be read are
+// skipped.
+func Load() ([]Run, error) {
+	p, err := path()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Run{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	runs := []Run{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			log.Printf("Skipping bad history line %d: %v", line, err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Started.After(runs[j].Started) })
+	return runs, nil
+}
+
+// Recorder builds the Run of a job from the events of its stages.
+type Recorder struct {
+	run Run
+	// archives maps local archive paths and snapshot IDs to their index
+	// in run.Archives.
+	archives map[string]int
+	finished bool
+}
+
+// NewRecorder starts recording a run of job now.
+func NewRecorder(job engine.Job) *Recorder {
+	dests := []string{}
+	for _, dest := range job.Destinations {
+		dests = append(dests, dest.String())
+	}
+
+	return &Recorder{
+		run: Run{
+			Job:          job.Name,
+			Started:      time.Now(),
+			Paths:        job.Paths,
+			Destinations: dests,
+		},
+		archives: map[string]int{},
+	}
+}
+
+func (r *Recorder) archive(item string) *Archive {
+	i, ok := r.archives[item]
+	if !ok {
+		i = len(r.run.Archives)
+		r.archives[item] = i
+		r.run.Archives = append(r.run.Archives, Archive{})
+	}
+	return &r.run.Archives[i]
+}
+
+// Observe records what event says about the run.
+func (r *Recorder) Observe(event engine.Event) {
+	switch event := event.(type) {
+	case engine.FileDone:
+		switch event.Stage {
+		case engine.StageCreate:
+			if event.Path == "" {
+				return
+			}
+			archive := r.archive(event.Path)
+			archive.Name = filepath.Base(event.Path)
+			archive.Path = event.Path
+			archive.Size = event.Size
+			archive.Checksum = event.Checksum
+		case engine.StageUpload:
+			// Uploads are of archives made earlier. In repository mode
+			// Item is a backed up path instead and Path the snapshot,
+			// which is the same for all of them. Streamed command sources
+			// have their name as Item and are keyed by their upload.
+			key := event.Item
+			if _, ok := r.archives[key]; !ok {
+				key = event.Path
+			}
+			archive := r.archive(key)
+			if archive.Name == "" {
+				archive.Name = event.Path
+			}
+			if archive.Size == 0 {
+				// Streamed command output is only sized as it's uploaded.
+				archive.Size = event.Size
+			}
+			if !slices.Contains(archive.Destinations, event.Destination) {
+				archive.Destinations = append(archive.Destinations, event.Destination)
+			}
+		}
+	case engine.Error:
+		r.run.Errors = append(r.run.Errors, event.String())
+	}
+}
+
+// Run is the run as recorded so far.
+func (r *Recorder) Run() Run {
+	return r.run
+}
+
+// Finish records the run as ended with err and appends it to the history.
+// Only the first call has an effect.
+func (r *Recorder) Finish(err error) {
+	if r.finished {
+		return
+	}
+	r.finished = true
+	r.run.Finished = time.Now()
+	switch {
+	case err == nil:
+		r.run.Status = StatusSuccess
+	case errors.Is(err, context.Canceled):
+		r.run.Status = StatusCancelled
+	default:
+		r.run.Status = StatusFailed
+		if msg := err.Error(); !slices.Contains(r.run.Errors, msg) {
+			r.run.Errors = append(r.run.Errors, msg)
+		}
+	}
+
+	if err := Append(r.run); err != nil {
+		log.Printf("Couldn't record run in history, error: %v", err)
+	}
+}
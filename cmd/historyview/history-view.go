@@ -0,0 +1,260 @@
+package historyview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Chanadu/backup-tui/cmd/daemon"
+	"github.com/Chanadu/backup-tui/cmd/history"
+	"github.com/Chanadu/backup-tui/cmd/stage"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dustin/go-humanize"
+)
+
+// pageSize is how many runs are listed at once.
+const pageSize = 15
+
+type loadedMsg struct {
+	runs []history.Run
+	err  error
+}
+
+type daemonMsg struct {
+	status []daemon.JobStatus
+	err    error
+}
+
+// HistoryModel lists past runs, filtered by status, and shows the details of
+// one of them. Above them it shows what the scheduler daemon is up to.
+type HistoryModel struct {
+	loading bool
+	runs    []history.Run
+	err     error
+
+	daemon    []daemon.JobStatus
+	daemonErr error
+
+	// filter indexes history.Statuses, -1 shows all runs.
+	filter    int
+	cursor    int
+	offset    int
+	detailing bool
+}
+
+func load() tea.Msg {
+	runs, err := history.Load()
+	if err != nil {
+		log.Printf("Couldn't load history, error: %v", err)
+	}
+	return loadedMsg{runs: runs, err: err}
+}
+
+func queryDaemon() tea.Msg {
+	status, err := daemon.Query(context.Background())
+	return daemonMsg{status: status, err: err}
+}
+
+func (m HistoryModel) Init() tea.Cmd {
+	return tea.Batch(load, queryDaemon)
+}
+
+// visible returns the runs passing the filter.
+func (m HistoryModel) visible() []history.Run {
+	if m.filter < 0 {
+		return m.runs
+	}
+	runs := []history.Run{}
+	for _, run := range m.runs {
+		if run.Status == history.Statuses[m.filter] {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+func (m HistoryModel) filterName() string {
+	if m.filter < 0 {
+		return "all"
+	}
+	return string(history.Statuses[m.filter])
+}
+
+func (m HistoryModel) Update(msg tea.Msg) (HistoryModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case loadedMsg:
+		m.loading = false
+		m.runs = msg.runs
+		m.err = msg.err
+	case daemonMsg:
+		m.daemon = msg.status
+		m.daemonErr = msg.err
+	case tea.KeyMsg:
+		if m.detailing {
+			switch msg.String() {
+			case "esc", "backspace", "enter":
+				m.detailing = false
+			}
+			return m, nil
+		}
+
+		runs := m.visible()
+		switch msg.String() {
+		case "esc":
+			return m, stage.BackCmd
+		case "up", "k":
+			m.cursor = max(m.cursor-1, 0)
+		case "down", "j":
+			m.cursor = min(m.cursor+1, max(len(runs)-1, 0))
+		case "f":
+			m.filter++
+			if m.filter >= len(history.Statuses) {
+				m.filter = -1
+			}
+			m.cursor, m.offset = 0, 0
+		case "r":
+			m.loading = true
+			return m, tea.Batch(load, queryDaemon)
+		case "enter":
+			if m.cursor < len(runs) {
+				m.detailing = true
+			}
+		}
+
+		if m.cursor < m.offset {
+			m.offset = m.cursor
+		} else if m.cursor >= m.offset+pageSize {
+			m.offset = m.cursor - pageSize + 1
+		}
+	}
+	return m, nil
+}
+
+func describeJob(run history.Run) string {
+	if run.Job == "" {
+		return "(unsaved)"
+	}
+	return run.Job
+}
+
+func (m HistoryModel) View() string {
+	var s strings.Builder
+	runs := m.visible()
+	if m.detailing && m.cursor < len(runs) {
+		detailView(&s, runs[m.cursor])
+		s.WriteString("\nPress esc to go back to the list.\n")
+		return s.String()
+	}
+
+	m.daemonView(&s)
+	fmt.Fprintf(&s, "Run History (showing %s)\n\n", m.filterName())
+	switch {
+	case m.loading:
+		s.WriteString("Loading...\n")
+	case m.err != nil:
+		fmt.Fprintf(&s, "Couldn't load history: %v\n", m.err)
+	case len(runs) == 0:
+		s.WriteString("No runs recorded.\n")
+	}
+
+	end := min(m.offset+pageSize, len(runs))
+	for i := m.offset; i < end; i++ {
+		run := runs[i]
+		cursor := "  "
+		if i == m.cursor {
+			cursor = "> "
+		}
+		fmt.Fprintf(&s, "%s%s  %-9s  %-16s  %3d archives  %9s  %s\n", cursor,
+			run.Started.Local().Format("2006-01-02 15:04"), run.Status, describeJob(run),
+			len(run.Archives), humanize.Bytes(uint64(run.Size())), //nolint:gosec
+			run.Duration().Round(time.Second))
+	}
+	if len(runs) > pageSize {
+		fmt.Fprintf(&s, "  … %d-%d of %d\n", m.offset+1, end, len(runs))
+	}
+
+	s.WriteString("\nenter: details, f: filter by status, r: reload\n")
+	return s.String()
+}
+
+func (m HistoryModel) daemonView(s *strings.Builder) {
+	switch {
+	case errors.Is(m.daemonErr, daemon.ErrNotRunning):
+		s.WriteString("Scheduler: not running, start it with backup-tui daemon\n\n")
+		return
+	case m.daemonErr != nil:
+		fmt.Fprintf(s, "Scheduler: %v\n\n", m.daemonErr)
+		return
+	case m.daemon == nil:
+		return
+	}
+
+	s.WriteString("Scheduler\n")
+	for _, job := range m.daemon {
+		state := "never run"
+		switch {
+		case job.Running:
+			state = "running since " + job.Started.Local().Format("15:04")
+		case !job.LastRun.IsZero():
+			state = fmt.Sprintf("last %s %s", job.LastStatus, job.LastRun.Local().Format("2006-01-02 15:04"))
+		}
+		fmt.Fprintf(s, "  %-16s  %-16s  next %s  %s", job.Name, job.Schedule, job.Next.Local().Format("2006-01-02 15:04"), state)
+		if job.Skipped > 0 {
+			fmt.Fprintf(s, ", %d skipped", job.Skipped)
+		}
+		s.WriteString("\n")
+	}
+	s.WriteString("\n")
+}
+
+func detailView(s *strings.Builder, run history.Run) {
+	fmt.Fprintf(s, "Run of %s\n\n", describeJob(run))
+	fmt.Fprintf(s, "  Status:    %s\n", run.Status)
+	fmt.Fprintf(s, "  Started:   %s\n", run.Started.Local().Format(time.DateTime))
+	fmt.Fprintf(s, "  Finished:  %s (%s)\n", run.Finished.Local().Format(time.DateTime), run.Duration().Round(time.Second))
+
+	s.WriteString("\nPaths\n")
+	for _, path := range run.Paths {
+		fmt.Fprintf(s, "  %s\n", path)
+	}
+	s.WriteString("\nDestinations\n")
+	for _, dest := range run.Destinations {
+		fmt.Fprintf(s, "  %s\n", dest)
+	}
+
+	if len(run.Archives) > 0 {
+		s.WriteString("\nArchives\n")
+	}
+	for _, archive := range run.Archives {
+		fmt.Fprintf(s, "  %s", archive.Name)
+		if archive.Size > 0 {
+			fmt.Fprintf(s, "  %s", humanize.Bytes(uint64(archive.Size))) //nolint:gosec
+		}
+		s.WriteString("\n")
+		if archive.Checksum != "" {
+			fmt.Fprintf(s, "    sha256 %s\n", archive.Checksum)
+		}
+		if len(archive.Destinations) > 0 {
+			fmt.Fprintf(s, "    on %s\n", strings.Join(archive.Destinations, ", "))
+		} else {
+			s.WriteString("    not uploaded\n")
+		}
+	}
+
+	if len(run.Errors) > 0 {
+		s.WriteString("\nErrors\n")
+	}
+	for _, err := range run.Errors {
+		fmt.Fprintf(s, "  %s\n", err)
+	}
+}
+
+func InitialHistoryModel() HistoryModel {
+	return HistoryModel{
+		loading: true,
+		filter:  -1,
+	}
+}
@@ -0,0 +1,116 @@
+// Package hookpane shows the output of a job's hooks below the running
+// stage, collapsed to a single status line unless expanded.
+package hookpane
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Chanadu/backup-tui/pkg/engine"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ToggleKey expands or collapses the pane.
+const ToggleKey = "o"
+
+const (
+	// maxLines is how many lines of output are kept.
+	maxLines = 500
+	// shownLines is how many of the last lines an expanded pane shows.
+	shownLines = 12
+)
+
+var paneStyle = lipgloss.NewStyle().
+	BorderStyle(lipgloss.NormalBorder()).
+	BorderLeft(true).
+	BorderForeground(lipgloss.Color("240")).
+	PaddingLeft(1)
+
+var failedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+
+type HookPaneModel struct {
+	lines    []string
+	expanded bool
+
+	// last is the hook that ran last, running while it hasn't finished.
+	last    engine.HookPoint
+	running bool
+	err     error
+}
+
+// Observe records what a hook event says, other events are ignored.
+func (m HookPaneModel) Observe(event engine.Event) HookPaneModel {
+	switch event := event.(type) {
+	case engine.HookStarted:
+		m.last = event.Hook
+		m.running = true
+		m.err = nil
+		m = m.add(fmt.Sprintf("$ %s", event.Command))
+	case engine.HookOutput:
+		m = m.add(event.Line)
+	case engine.HookDone:
+		m.running = false
+		m.err = event.Err
+		if event.Err != nil {
+			m = m.add(event.Err.Error())
+			// A failure is worth seeing without asking for it.
+			m.expanded = true
+		}
+	}
+	return m
+}
+
+func (m HookPaneModel) add(line string) HookPaneModel {
+	m.lines = append(m.lines, line)
+	if len(m.lines) > maxLines {
+		m.lines = m.lines[len(m.lines)-maxLines:]
+	}
+	return m
+}
+
+// Toggle expands or collapses the pane.
+func (m HookPaneModel) Toggle() HookPaneModel {
+	m.expanded = !m.expanded
+	return m
+}
+
+// Empty reports whether no hook ran yet, the pane isn't shown then.
+func (m HookPaneModel) Empty() bool {
+	return m.last == ""
+}
+
+func (m HookPaneModel) View() string {
+	if m.Empty() {
+		return ""
+	}
+
+	var status string
+	switch {
+	case m.running:
+		status = fmt.Sprintf("%s hook running", m.last)
+	case m.err != nil:
+		status = failedStyle.Render(fmt.Sprintf("%s hook failed", m.last))
+	default:
+		status = fmt.Sprintf("%s hook done", m.last)
+	}
+
+	var s strings.Builder
+	s.WriteString("\n")
+	if !m.expanded {
+		fmt.Fprintf(&s, "▸ Hooks: %s (%d lines, %s: show)\n", status, len(m.lines), ToggleKey)
+		return s.String()
+	}
+
+	fmt.Fprintf(&s, "▾ Hooks: %s (%s: hide)\n", status, ToggleKey)
+	lines := m.lines
+	if len(lines) > shownLines {
+		lines = lines[len(lines)-shownLines:]
+	}
+	s.WriteString(paneStyle.Render(strings.Join(lines, "\n")))
+	s.WriteString("\n")
+	return s.String()
+}
+
+func InitialHookPaneModel() HookPaneModel {
+	return HookPaneModel{}
+}
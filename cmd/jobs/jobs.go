@@ -0,0 +1,237 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Chanadu/backup-tui/cmd/utils"
+	"github.com/Chanadu/backup-tui/pkg/destination"
+	"github.com/Chanadu/backup-tui/pkg/engine"
+	"github.com/Chanadu/backup-tui/pkg/notify"
+)
+
+const (
+	jobsDirName      = "jobs"
+	manifestsDirName = "manifests"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Job is a reusable backup: where to, what and how. Passwords are never
+// stored and have to be entered on each run.
+type Job struct {
+	Name         string                    `json:"name"`
+	Destinations []destination.Config      `json:"destinations"`
+	Replication  engine.Replication        `json:"replication"`
+	Paths        []string                  `json:"paths"`
+	Commands     []engine.CommandSource    `json:"commands,omitempty"`
+	Archive      engine.ArchiveSettings    `json:"archive"`
+	Retention    engine.Retention          `json:"retention"`
+	Incremental  engine.Incremental        `json:"incremental"`
+	Repository   engine.RepositorySettings `json:"repository"`
+	Hooks        engine.Hooks              `json:"hooks"`
+
+	// Schedule is when the daemon runs the job: a cron expression, a
+	// shorthand such as @daily, or "@every <duration>". Empty jobs only
+	// run when started by hand.
+	Schedule string `json:"schedule,omitempty"`
+
+	// Notify are told how each run of the job ended.
+	Notify []notify.Config `json:"notify,omitempty"`
+
+	// RepositoryPassword unlocks encrypted repositories, it is never saved.
+	RepositoryPassword string `json:"-"`
+}
+
+// legacyJob holds the fields older jobs were saved with: a single
+// destination, or before that SFTP details only.
+type legacyJob struct {
+	Destination *destination.Config `json:"destination"`
+	User        string              `json:"user"`
+	Server      string              `json:"server"`
+	RemoteDir   string              `json:"remote_dir"`
+}
+
+func (l legacyJob) destinations() []destination.Config {
+	if l.Destination != nil {
+		return []destination.Config{*l.Destination}
+	}
+	return []destination.Config{{
+		Type:   destination.TypeSFTP,
+		User:   l.User,
+		Server: l.Server,
+		Dir:    l.RemoteDir,
+	}}
+}
+
+// FromEngineJob saves the reusable parts of job under name.
+func FromEngineJob(name string, job engine.Job) Job {
+	return Job{
+		Name:         name,
+		Destinations: job.Destinations,
+		Replication:  job.Replication,
+		Paths:        job.Paths,
+		Commands:     job.Commands,
+		Archive:      job.Archive,
+		Retention:    job.Retention,
+		Incremental:  job.Incremental,
+		Repository:   job.Repository,
+		Hooks:        job.Hooks,
+	}
+}
+
+// EngineJob turns the saved job into one the engine can run, archiving into
+// workDir. passwords[i] is the password of the i-th destination.
+func (j Job) EngineJob(passwords []string, workDir string) engine.Job {
+	dests := make([]destination.Config, len(j.Destinations))
+	for i, dest := range j.Destinations {
+		if i < len(passwords) {
+			dest.Password = passwords[i]
+		}
+		dests[i] = dest
+	}
+
+	return engine.Job{
+		Name:         j.Name,
+		Destinations: dests,
+		Replication:  j.Replication,
+		Paths:        j.Paths,
+		Commands:     j.Commands,
+		Archive:      j.Archive,
+		Retention:    j.Retention,
+		Incremental:  j.Incremental,
+		Hooks:        j.Hooks,
+		ManifestDir:  ManifestDir(j.Name),
+		WorkDir:      workDir,
+
+		Repository:         j.Repository,
+		RepositoryPassword: j.RepositoryPassword,
+	}
+}
+
+// ManifestDir is where incremental backups of the job called name keep their
+// manifests. Unsaved jobs share one directory. It returns "" if the state
+// dir is unavailable, which makes incremental backups fail.
+func ManifestDir(name string) string {
+	stateDir, err := utils.StateDir()
+	if err != nil {
+		log.Printf("Couldn't get state dir, error: %v", err)
+		return ""
+	}
+	if name == "" {
+		name = "unsaved"
+	}
+	return filepath.Join(stateDir, manifestsDirName, name)
+}
+
+// ValidateName checks name can be used as a job's file name.
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid job name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+func dir() (string, error) {
+	configDir, err := utils.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	jobsDir := filepath.Join(configDir, jobsDirName)
+	return jobsDir, os.MkdirAll(jobsDir, 0o755)
+}
+
+func path(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	jobsDir, err := dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(jobsDir, name+".json"), nil
+}
+
+// Save writes job to the jobs directory, replacing any job with the same
+// name.
+func Save(job Job) error {
+	p, err := path(job.Name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o600)
+}
+
+// Load reads the job called name.
+func Load(name string) (Job, error) {
+	p, err := path(name)
+	if err != nil {
+		return Job{}, err
+	}
+
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return Job{}, fmt.Errorf("job %q not found", name)
+	}
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := Job{Archive: engine.DefaultArchiveSettings()}
+	if err := json.Unmarshal(data, &job); err != nil {
+		return Job{}, fmt.Errorf("reading job %q: %w", name, err)
+	}
+	if len(job.Destinations) == 0 {
+		var legacy legacyJob
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return Job{}, fmt.Errorf("reading job %q: %w", name, err)
+		}
+		job.Destinations = legacy.destinations()
+	}
+	job.Name = name
+	return job, nil
+}
+
+// List returns all saved jobs sorted by name. Jobs that can't be read are
+// logged and left out, so one broken file doesn't hide the others.
+func List() ([]Job, error) {
+	jobsDir, err := dir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(jobsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []Job{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		job, err := Load(name)
+		if err != nil {
+			log.Printf("Skipping job %s: %v", name, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs, nil
+}
@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+
+	"github.com/Chanadu/backup-tui/cmd/utils"
+	"github.com/Chanadu/backup-tui/pkg/engine"
+	"github.com/Chanadu/backup-tui/pkg/lock"
+)
+
+const locksDirName = "locks"
+
+// Lock is held while a job runs, on this machine and on the job's
+// destinations.
+type Lock struct {
+	file   *lock.File
+	unlock func()
+}
+
+// AcquireLock locks job, failing with a *lock.HeldError if another run of
+// it holds the lock here or on one of its destinations.
+func AcquireLock(ctx context.Context, job engine.Job) (*Lock, error) {
+	stateDir, err := utils.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	name := job.Name
+	if name == "" {
+		name = "unsaved"
+	}
+
+	file, err := lock.Acquire(filepath.Join(stateDir, locksDirName, name+".lock"))
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := engine.LockDestinations(ctx, job)
+	if err != nil {
+		file.Release()
+		return nil, err
+	}
+	log.Printf("Locked job %s", name)
+	return &Lock{file: file, unlock: unlock}, nil
+}
+
+// Release removes the job's locks.
+func (l *Lock) Release() {
+	l.unlock()
+	if err := l.file.Release(); err != nil {
+		log.Printf("Couldn't remove lock file: %v", err)
+	}
+}
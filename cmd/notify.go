@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Chanadu/backup-tui/cmd/history"
+	"github.com/Chanadu/backup-tui/cmd/jobs"
+	"github.com/Chanadu/backup-tui/pkg/notify"
+)
+
+// SMTPPasswordEnv holds the password of email notifiers that log in.
+const SMTPPasswordEnv = "BACKUP_TUI_SMTP_PASSWORD"
+
+// notifiers are the notifiers of the saved job called name, with their
+// passwords read from the environment. Unsaved jobs have none.
+func notifiers(name string) []notify.Config {
+	if name == "" {
+		return nil
+	}
+	job, err := jobs.Load(name)
+	if err != nil {
+		log.Printf("Couldn't load notifiers of job %s: %v", name, err)
+		return nil
+	}
+	return withPasswords(job.Notify)
+}
+
+func withPasswords(configs []notify.Config) []notify.Config {
+	configs = slices.Clone(configs)
+	for i := range configs {
+		if configs[i].Type == notify.TypeSMTP && configs[i].User != "" {
+			configs[i].Password = os.Getenv(SMTPPasswordEnv)
+		}
+	}
+	return configs
+}
+
+// summary describes a recorded run to notifiers.
+func summary(run history.Run) notify.Summary {
+	host, _ := os.Hostname()
+	archives := []notify.Archive{}
+	for _, archive := range run.Archives {
+		archives = append(archives, notify.Archive{
+			Name:         archive.Name,
+			Size:         archive.Size,
+			Checksum:     archive.Checksum,
+			Destinations: archive.Destinations,
+		})
+	}
+	errs := run.Errors
+	if errs == nil {
+		errs = []string{}
+	}
+
+	return notify.Summary{
+		Job:          run.Job,
+		Host:         host,
+		Status:       string(run.Status),
+		Started:      run.Started,
+		Finished:     run.Finished,
+		Duration:     run.Duration(),
+		Size:         run.Size(),
+		Paths:        run.Paths,
+		Destinations: run.Destinations,
+		Archives:     archives,
+		Errors:       errs,
+	}
+}
+
+// notifyRun tells configs how run ended. Failures are logged, a broken
+// notifier doesn't fail the backup.
+func notifyRun(configs []notify.Config, run history.Run) error {
+	if len(configs) == 0 {
+		return nil
+	}
+	err := notify.SendAll(context.Background(), configs, summary(run))
+	if err != nil {
+		log.Printf("Couldn't send notifications: %v", err)
+	}
+	return err
+}
+
+// notifyRunInBackground is notifyRun for the TUI, which mustn't block on
+// slow notifiers.
+func notifyRunInBackground(name string, run history.Run) {
+	configs := notifiers(name)
+	if len(configs) == 0 {
+		return
+	}
+	afterRun.Add(1)
+	go func() {
+		defer afterRun.Done()
+		_ = notifyRun(configs, run)
+	}()
+}
+
+// RunNotify sends a made up run through a saved job's notifiers, to try
+// them out. It returns the process exit code.
+func RunNotify(args []string) int {
+	flags := flag.NewFlagSet("notify", flag.ContinueOnError)
+	status := flags.String("status", string(history.StatusFailed), "status of the test run: success, failed or cancelled")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: backup-tui notify [-status <status>] <job>")
+		fmt.Fprintln(flags.Output(), "Sends a test notification through the job's notifiers, set as \"notify\" in the job file.")
+		fmt.Fprintf(flags.Output(), "The password of email notifiers is read from $%s.\n", SMTPPasswordEnv)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || !slices.Contains(history.Statuses, history.Status(*status)) {
+		flags.Usage()
+		return 2
+	}
+
+	job, err := jobs.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	if len(job.Notify) == 0 {
+		fmt.Fprintf(os.Stderr, "error: job %s has no notifiers\n", job.Name)
+		return 1
+	}
+
+	now := time.Now()
+	run := history.Run{
+		Job:      job.Name,
+		Started:  now.Add(-time.Minute),
+		Finished: now,
+		Status:   history.Status(*status),
+		Paths:    job.Paths,
+		Archives: []history.Archive{{Name: "test-backup.7z", Size: 1 << 20}},
+	}
+	for _, dest := range job.Destinations {
+		run.Destinations = append(run.Destinations, dest.String())
+	}
+	run.Archives[0].Destinations = run.Destinations
+	if run.Status == history.StatusFailed {
+		run.Errors = []string{"test notification, nothing failed"}
+	}
+
+	failed := false
+	for _, config := range withPasswords(job.Notify) {
+		// Sent one by one so each gets its own result, and regardless of
+		// OnlyFailures.
+		config.OnlyFailures = false
+		if err := notifyRun([]notify.Config{config}, run); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("sent %s\n", config)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
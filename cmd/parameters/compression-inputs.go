@@ -0,0 +1,94 @@
+package parameters
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Chanadu/backup-tui/pkg/engine"
+)
+
+// maxLevel is the highest compression level 7z takes.
+const maxLevel = 9
+
+// compressionInputNames are the inputs of compressionInputs, which the
+// destination inputs leave alone.
+var compressionInputNames = []string{"level", "method", "threads"}
+
+// compressionInputs creates the inputs choosing how archives are
+// compressed, set to the default settings.
+func compressionInputs() []TextModel {
+	defaults := engine.DefaultArchiveSettings()
+
+	level := InitalTextModel("level", "Compression Level: ", "0-9", false)
+	level.Ti.SetValue(strconv.Itoa(defaults.Level))
+	method := InitialChoiceModel("method", "Compression Method: ", engine.Methods)
+	method.Ti.SetValue(defaults.Method)
+	threads := InitalTextModel("threads", "Threads: ", "ex: 4 (empty for all cores)", false)
+	threads.Optional = true
+
+	return []TextModel{level, method, threads}
+}
+
+// fillCompression sets the compression inputs from archive.
+func (m *InputModel) fillCompression(archive engine.ArchiveSettings) {
+	for i := range m.TextInputs {
+		switch m.TextInputs[i].Name {
+		case "level":
+			m.TextInputs[i].Ti.SetValue(strconv.Itoa(archive.Level))
+		case "method":
+			// Jobs saved before methods could be chosen use LZMA2.
+			m.TextInputs[i].Ti.SetValue(cmp.Or(archive.Method, engine.MethodLZMA2))
+		case "threads":
+			threads := ""
+			if archive.Threads > 0 {
+				threads = strconv.Itoa(archive.Threads)
+			}
+			m.TextInputs[i].Ti.SetValue(threads)
+		}
+	}
+}
+
+// compressionSettings reads the compression inputs into data, reporting
+// the first invalid one.
+func (m InputModel) compressionSettings(data *InputData) error {
+	for _, textModel := range m.TextInputs {
+		value := strings.TrimSpace(textModel.Ti.Value())
+		switch textModel.Name {
+		case "level":
+			level, err := strconv.Atoi(value)
+			if err != nil || level < 0 || level > maxLevel {
+				return fmt.Errorf("compression level must be a number from 0 to %d", maxLevel)
+			}
+			data.Level = level
+		case "method":
+			if !slices.Contains(engine.Methods, value) {
+				return fmt.Errorf("unknown compression method %q", value)
+			}
+			data.Method = value
+		case "threads":
+			if value == "" {
+				data.Threads = 0
+				continue
+			}
+			threads, err := strconv.Atoi(value)
+			if err != nil || threads < 1 {
+				return fmt.Errorf("threads must be a positive number, or empty for all cores")
+			}
+			data.Threads = threads
+		}
+	}
+	return nil
+}
+
+// validateCompression checks the compression inputs can be read.
+func (m InputModel) validateCompression() (InputModel, bool) {
+	if err := m.compressionSettings(&InputData{}); err != nil {
+		m.status = fmt.Sprintf("Check the compression settings: %v.", err)
+		return m, false
+	}
+	m.status = ""
+	return m, true
+}
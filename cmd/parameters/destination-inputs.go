@@ -0,0 +1,239 @@
+package parameters
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// longCharLimit bounds inputs holding paths and URLs, which can be much
+// longer than the other fields.
+const longCharLimit = 4096
+
+// destinationInputs creates the text inputs a destination of kind needs,
+// after the type picker itself.
+func destinationInputs(kind string) []TextModel {
+	optional := func(m TextModel) TextModel {
+		m.Optional = true
+		return m
+	}
+	long := func(m TextModel) TextModel {
+		m.Ti.CharLimit = longCharLimit
+		return m
+	}
+
+	switch kind {
+	case destination.TypeLocal:
+		return []TextModel{
+			long(InitalTextModel("dir", "Path: ", "ex: /media/usb (where the drive is mounted)", false)),
+		}
+	case destination.TypeS3:
+		return []TextModel{
+			optional(long(InitalTextModel("endpoint", "Endpoint: ", "ex: http://localhost:9000 (empty for AWS)", false))),
+			optional(InitalTextModel("region", "Region: ", "ex: us-east-1", false)),
+			long(InitalTextModel("bucket", "Bucket: ", "ex: backups", false)),
+			optional(long(InitalTextModel("prefix", "Prefix: ", "ex: pi/home", false))),
+			InitalTextModel("accesskey", "Access Key: ", "ex: minioadmin", false),
+			InitalTextModel("password", "Secret Key: ", "ex: minioadmin", true),
+		}
+	}
+
+	return []TextModel{
+		InitalTextModel("user", "User: ", "ex: pi", false),
+		InitalTextModel("server", "Server: ", "ex: 192.168.1.1 or raspberrypi", false),
+		InitalTextModel("password", "Password: ", "ex: 1234", true),
+		optional(long(InitalTextModel("dir", "Remote Dir: ", "ex: backups (empty for home)", false))),
+	}
+}
+
+// destinationType returns the type currently picked in the form.
+func (m InputModel) destinationType() string {
+	for _, textModel := range m.TextInputs {
+		if textModel.Name == "type" {
+			return textModel.Ti.Value()
+		}
+	}
+	return destination.TypeSFTP
+}
+
+// setDestinationType swaps the destination inputs for the ones kind needs,
+// keeping values of inputs both types share and the compression inputs.
+func (m *InputModel) setDestinationType(kind string) {
+	values := map[string]string{}
+	for _, textModel := range m.TextInputs {
+		values[textModel.Name] = textModel.Ti.Value()
+	}
+
+	typeInput := InitialChoiceModel("type", "Destination: ", destination.Types)
+	typeInput.Ti.SetValue(kind)
+	textInputs := []TextModel{typeInput}
+	for _, textModel := range destinationInputs(kind) {
+		textModel.Ti.SetValue(values[textModel.Name])
+		textInputs = append(textInputs, textModel)
+	}
+	for _, textModel := range compressionInputs() {
+		textModel.Ti.SetValue(values[textModel.Name])
+		textInputs = append(textInputs, textModel)
+	}
+
+	m.blurCurrentIndex()
+	m.TextInputs = textInputs
+	m.currentIndex = min(m.currentIndex, m.totalItemCount()-1)
+	m.focusCurrentIndex()
+}
+
+// configValue returns the field of config an input called name edits.
+func configValue(config destination.Config, name string) string {
+	switch name {
+	case "user":
+		return config.User
+	case "server":
+		return config.Server
+	case "password":
+		return config.Password
+	case "dir":
+		return config.Dir
+	case "endpoint":
+		return config.Endpoint
+	case "region":
+		return config.Region
+	case "bucket":
+		return config.Bucket
+	case "prefix":
+		return config.Prefix
+	case "accesskey":
+		return config.AccessKey
+	}
+	return ""
+}
+
+// setConfigValue sets the field of config an input called name edits.
+func setConfigValue(config *destination.Config, name, val string) {
+	switch name {
+	case "user":
+		config.User = val
+	case "server":
+		config.Server = val
+	case "password":
+		config.Password = val
+	case "dir":
+		config.Dir = val
+	case "endpoint":
+		config.Endpoint = val
+	case "region":
+		config.Region = val
+	case "bucket":
+		config.Bucket = val
+	case "prefix":
+		config.Prefix = val
+	case "accesskey":
+		config.AccessKey = val
+	}
+}
+
+// missingField returns the prompt of the first required field config leaves
+// empty, or "" if it is complete.
+func missingField(config destination.Config) string {
+	for _, input := range destinationInputs(config.Type) {
+		if !input.Optional && configValue(config, input.Name) == "" {
+			return strings.TrimSuffix(input.Ti.Prompt, ": ")
+		}
+	}
+	return ""
+}
+
+// destinationConfig collects the destination inputs into a config.
+func (m InputModel) destinationConfig() destination.Config {
+	config := destination.Config{Type: m.destinationType()}
+	for _, textModel := range m.TextInputs {
+		setConfigValue(&config, textModel.Name, textModel.Ti.Value())
+	}
+	return config
+}
+
+// fillDestination sets the inputs from a destination config.
+func (m *InputModel) fillDestination(config destination.Config) {
+	if config.Type == "" {
+		config.Type = destination.TypeSFTP
+	}
+	m.setDestinationType(config.Type)
+
+	for i := range m.TextInputs {
+		if m.TextInputs[i].Name == "type" || slices.Contains(compressionInputNames, m.TextInputs[i].Name) {
+			continue
+		}
+		m.TextInputs[i].Ti.SetValue(configValue(config, m.TextInputs[i].Name))
+	}
+}
+
+// storeDestination saves the inputs into the destination being edited. The
+// slice is copied so earlier copies of the model aren't changed.
+func (m *InputModel) storeDestination() {
+	m.destinations = slices.Clone(m.destinations)
+	m.destinations[m.destIndex] = m.destinationConfig()
+}
+
+// showDestination switches the inputs to the i-th destination.
+func (m *InputModel) showDestination(i int) {
+	m.destIndex = i
+	m.fillDestination(m.destinations[i])
+}
+
+// setDestinations replaces all destinations, showing the first.
+func (m *InputModel) setDestinations(configs []destination.Config) {
+	m.destinations = append([]destination.Config{}, configs...)
+	if len(m.destinations) == 0 {
+		m.destinations = []destination.Config{{Type: destination.TypeSFTP}}
+	}
+	m.status = ""
+	m.showDestination(0)
+}
+
+// updateDestinations handles the keys adding, removing and switching
+// destinations. It reports whether the key was consumed.
+func (m InputModel) updateDestinations(msg tea.KeyMsg) (bool, InputModel) {
+	switch msg.String() {
+	case "ctrl+n":
+		m.storeDestination()
+		m.destinations = append(m.destinations, destination.Config{Type: m.destinationType()})
+		m.showDestination(len(m.destinations) - 1)
+	case "ctrl+x":
+		if len(m.destinations) == 1 {
+			m.status = "A job needs at least one destination."
+			return true, m
+		}
+		m.destinations = slices.Delete(slices.Clone(m.destinations), m.destIndex, m.destIndex+1)
+		m.showDestination(min(m.destIndex, len(m.destinations)-1))
+	case "pgdown":
+		m.storeDestination()
+		m.showDestination(wrap(m.destIndex+1, len(m.destinations)))
+	case "pgup":
+		m.storeDestination()
+		m.showDestination(wrap(m.destIndex-1, len(m.destinations)))
+	default:
+		return false, m
+	}
+
+	m.status = ""
+	return true, m
+}
+
+// validateDestinations stores the inputs and checks every destination is
+// complete, switching to the first one that isn't.
+func (m InputModel) validateDestinations() (InputModel, bool) {
+	m.storeDestination()
+	for i, config := range m.destinations {
+		if field := missingField(config); field != "" {
+			if i != m.destIndex {
+				m.showDestination(i)
+			}
+			m.status = fmt.Sprintf("Destination %d needs a %s.", i+1, strings.ToLower(field))
+			return m, false
+		}
+	}
+	m.status = ""
+	return m, true
+}
@@ -1,15 +1,22 @@
 package parameters
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/Chanadu/backup-tui/cmd/jobs"
+	"github.com/Chanadu/backup-tui/pkg/destination"
+
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 type InputData struct {
-	User     string
-	Server   string
-	Password string
+	Destinations []destination.Config
+	// Level, Method and Threads are the compression settings, see
+	// engine.ArchiveSettings.
+	Level    int
+	Method   string
+	Threads  int
 	Debug    bool
 	Commands bool
 	Progress bool
@@ -18,20 +25,13 @@ type InputDataMessage struct {
 	Data InputData
 }
 
+// ShowHistoryMsg is sent when the user wants to browse past runs.
+type ShowHistoryMsg struct{}
+
 func (m InputModel) ParametersDoneCmd() tea.Msg {
-	data := InputData{}
-	for _, textModel := range m.TextInputs {
-		name := textModel.Name
-		val := textModel.Ti.Value()
-		switch name {
-		case "user":
-			data.User = val
-		case "server":
-			data.Server = val
-		case "password":
-			data.Password = val
-		}
-	}
+	data := InputData{Destinations: m.destinations}
+	// Checked by validateCompression before submitting.
+	_ = m.compressionSettings(&data)
 	for _, switchModel := range m.SwitchInputs {
 		val := switchModel.enabled
 		switch switchModel.name {
@@ -51,6 +51,17 @@ type InputModel struct {
 	TextInputs   []TextModel
 	SwitchInputs []SwitchModel
 	currentIndex int
+
+	// The inputs edit destinations[destIndex], see destination-inputs.go.
+	destinations []destination.Config
+	destIndex    int
+	status       string
+
+	// Saved job picker state, see job-picker.go.
+	pickingJob bool
+	jobs       []jobs.Job
+	jobIndex   int
+	jobErr     error
 }
 
 func (m InputModel) Init() tea.Cmd {
@@ -60,23 +71,46 @@ func (m InputModel) Init() tea.Cmd {
 func (m InputModel) Update(msg tea.Msg) (InputModel, tea.Cmd) {
 	cmds := []tea.Cmd{}
 
+	if m.pickingJob {
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			return m.updateJobPicker(msg)
+		}
+		return m, nil
+	}
+
 	switch msg := msg.(type) {
+	case tea.MouseMsg:
+		// Each item is rendered on its own row, starting at the top.
+		if msg.Action != tea.MouseActionPress || msg.Button != tea.MouseButtonLeft {
+			break
+		}
+		if msg.Y < 0 || msg.Y >= m.totalItemCount() {
+			break
+		}
+		m.SetCurrentIndex(msg.Y)
+		if m.switchInputSelected() {
+			m.SwitchInputs[m.switchIndex()].Toggle()
+		}
+		return m, nil
 	case tea.KeyMsg:
+		if handled, model := m.updateDestinations(msg); handled {
+			return model, nil
+		}
+
 		switch strMsg := msg.String(); strMsg {
+		case "ctrl+o":
+			return m.openJobPicker(), nil
+		case "ctrl+r":
+			return m, func() tea.Msg { return ShowHistoryMsg{} }
 		case "tab", "shift+tab", "up", "down", "ctrl+j", "ctrl+k", "enter":
 
 			if strMsg == "enter" && m.currentIndex == m.totalItemCount()-1 {
-				isDone := true
-				for i := range m.TextInputs {
-					if m.TextInputs[i].Ti.Value() == "" {
-						isDone = false
-						break
+				var isDone bool
+				if m, isDone = m.validateDestinations(); isDone {
+					if m, isDone = m.validateCompression(); isDone {
+						return m, m.ParametersDoneCmd
 					}
 				}
-
-				if isDone {
-					return m, m.ParametersDoneCmd
-				}
 			}
 			m.blurCurrentIndex()
 
@@ -92,11 +126,15 @@ func (m InputModel) Update(msg tea.Msg) (InputModel, tea.Cmd) {
 		}
 	}
 
+	kind := m.destinationType()
 	var cmd tea.Cmd
 	for i := range m.TextInputs {
 		m.TextInputs[i], cmd = m.TextInputs[i].Update(msg)
 		cmds = append(cmds, cmd)
 	}
+	if newKind := m.destinationType(); newKind != kind {
+		m.setDestinationType(newKind)
+	}
 
 	for i := range m.SwitchInputs {
 		m.SwitchInputs[i], cmd = m.SwitchInputs[i].Update(msg)
@@ -109,6 +147,10 @@ func (m InputModel) Update(msg tea.Msg) (InputModel, tea.Cmd) {
 func (m InputModel) View() string {
 	var s strings.Builder
 
+	if m.pickingJob {
+		return m.jobPickerView()
+	}
+
 	for i := range m.totalItemCount() {
 		if i == m.currentIndex {
 			s.WriteString("> ")
@@ -124,16 +166,19 @@ func (m InputModel) View() string {
 		s.WriteString("\n")
 	}
 
-	s.WriteString("Press tab to switch, enter to submit.\n")
+	fmt.Fprintf(&s, "\nDestination %d of %d • ctrl+n: add • ctrl+x: remove • pgup/pgdown: switch\n", m.destIndex+1, len(m.destinations))
+	if m.status != "" {
+		s.WriteString(m.status + "\n")
+	}
+	s.WriteString("Press tab to switch, left/right to pick the destination type or method, enter to submit, ctrl+o to load a saved job, ctrl+r for run history.\n")
 
 	return s.String()
 }
 
 func InitialParametersInputs() InputModel {
-	textInputs := []TextModel{}
-	textInputs = append(textInputs, InitalTextModel("user", "User: ", "ex: pi", false))
-	textInputs = append(textInputs, InitalTextModel("server", "Server: ", "ex: 192.168.1.1 or raspberrypi", false))
-	textInputs = append(textInputs, InitalTextModel("password", "Password: ", "ex: 1234", true))
+	textInputs := []TextModel{InitialChoiceModel("type", "Destination: ", destination.Types)}
+	textInputs = append(textInputs, destinationInputs(destination.TypeSFTP)...)
+	textInputs = append(textInputs, compressionInputs()...)
 
 	switchInputs := []SwitchModel{}
 	switchInputs = append(switchInputs, InitialSwitchModel("debug", "Debug", false))
@@ -145,5 +190,6 @@ func InitialParametersInputs() InputModel {
 	return InputModel{
 		TextInputs:   textInputs,
 		SwitchInputs: switchInputs,
+		destinations: []destination.Config{{Type: destination.TypeSFTP}},
 	}
 }
@@ -0,0 +1,80 @@
+package parameters
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Chanadu/backup-tui/cmd/jobs"
+	"github.com/Chanadu/backup-tui/pkg/destination"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// JobLoadedMsg is sent when a saved job was picked, after its destinations
+// and compression settings have been filled into the inputs.
+type JobLoadedMsg struct {
+	Job jobs.Job
+}
+
+func (m InputModel) openJobPicker() InputModel {
+	m.pickingJob = true
+	m.jobIndex = 0
+	m.jobs, m.jobErr = jobs.List()
+	return m
+}
+
+func (m InputModel) updateJobPicker(msg tea.KeyMsg) (InputModel, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "ctrl+o":
+		m.pickingJob = false
+	case "up", "ctrl+k", "shift+tab":
+		m.jobIndex = max(m.jobIndex-1, 0)
+	case "down", "ctrl+j", "tab":
+		m.jobIndex = min(m.jobIndex+1, max(len(m.jobs)-1, 0))
+	case "enter":
+		if len(m.jobs) == 0 {
+			break
+		}
+		job := m.jobs[m.jobIndex]
+		m.pickingJob = false
+		m.setDestinations(job.Destinations)
+		m.fillCompression(job.Archive)
+		return m, func() tea.Msg {
+			return JobLoadedMsg{Job: job}
+		}
+	}
+	return m, nil
+}
+
+func (m InputModel) jobPickerView() string {
+	var s strings.Builder
+	s.WriteString("Saved jobs:\n")
+
+	if m.jobErr != nil {
+		fmt.Fprintf(&s, "Couldn't list jobs: %v\n", m.jobErr)
+	} else if len(m.jobs) == 0 {
+		s.WriteString("  No saved jobs yet, save one from the review screen.\n")
+	}
+
+	for i, job := range m.jobs {
+		if i == m.jobIndex {
+			s.WriteString("> ")
+		} else {
+			s.WriteString("  ")
+		}
+		fmt.Fprintf(&s, "%s (%s, %d paths)\n", job.Name, describeDestinations(job.Destinations), len(job.Paths))
+	}
+
+	s.WriteString("\nPress enter to load, esc to cancel.\n")
+	return s.String()
+}
+
+// describeDestinations names the first destination and counts the others.
+func describeDestinations(configs []destination.Config) string {
+	switch len(configs) {
+	case 0:
+		return "no destination"
+	case 1:
+		return configs[0].String()
+	}
+	return fmt.Sprintf("%s +%d more", configs[0], len(configs)-1)
+}
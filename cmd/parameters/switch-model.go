@@ -29,7 +29,7 @@ func (m SwitchModel) Update(msg tea.Msg) (SwitchModel, tea.Cmd) {
 	case tea.KeyMsg:
 		switch strMsg := msg.String(); strMsg {
 		case " ":
-			m.enabled = !m.enabled
+			m.Toggle()
 		}
 	}
 
@@ -65,6 +65,10 @@ func (m *SwitchModel) Blur() {
 	m.focused = false
 }
 
+func (m *SwitchModel) Toggle() {
+	m.enabled = !m.enabled
+}
+
 func (m *SwitchModel) String() string {
 	return fmt.Sprintf("{name: %s, enabled: %t, focused: %t}", m.name, m.enabled, m.focused)
 }
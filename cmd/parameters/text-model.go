@@ -10,6 +10,13 @@ import (
 type TextModel struct {
 	Name string
 	Ti   textinput.Model
+
+	// Optional inputs may be left empty when submitting.
+	Optional bool
+
+	// Choices turns the input into a picker cycled with left and right
+	// instead of a free text field.
+	Choices []string
 }
 
 func (m TextModel) Init() tea.Cmd {
@@ -19,12 +26,39 @@ func (m TextModel) Init() tea.Cmd {
 func (m TextModel) Update(msg tea.Msg) (TextModel, tea.Cmd) {
 	var cmd tea.Cmd
 
+	if m.Choices != nil {
+		if msg, ok := msg.(tea.KeyMsg); ok && m.Ti.Focused() {
+			switch msg.String() {
+			case "left", "ctrl+h":
+				m.cycle(-1)
+			case "right", "ctrl+l", " ":
+				m.cycle(1)
+			}
+		}
+		return m, nil
+	}
+
 	m.Ti, cmd = m.Ti.Update(msg)
 	return m, cmd
 }
 
+func (m *TextModel) cycle(delta int) {
+	index := 0
+	for i, choice := range m.Choices {
+		if choice == m.Ti.Value() {
+			index = i
+		}
+	}
+	m.Ti.SetValue(m.Choices[wrap(index+delta, len(m.Choices))])
+}
+
 func (m TextModel) View() string {
 	var s strings.Builder
+	if m.Choices != nil {
+		s.WriteString(m.Ti.Prompt)
+		s.WriteString("< " + m.Ti.Value() + " >")
+		return s.String()
+	}
 	s.WriteString(m.Ti.View())
 
 	return s.String()
@@ -46,3 +80,12 @@ func InitalTextModel(name string, prompt string, placeholder string, isPassword
 		Ti:   ti,
 	}
 }
+
+// InitialChoiceModel creates an input that picks one of choices, starting at
+// the first.
+func InitialChoiceModel(name string, prompt string, choices []string) TextModel {
+	m := InitalTextModel(name, prompt, "", false)
+	m.Choices = choices
+	m.Ti.SetValue(choices[0])
+	return m
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/engine"
+	"github.com/dustin/go-humanize"
+)
+
+// RunList prints the archives, or for repository mode jobs the snapshots,
+// stored at each of a saved job's destinations. It returns the process exit
+// code.
+func RunList(args []string) int {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: backup-tui list <job>")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	job, passwords, err := loadJob(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+
+	engineJob := job.EngineJob(passwords, "")
+	if job.Repository.Enabled {
+		return listSnapshots(ctx, engineJob)
+	}
+
+	code := 0
+	for _, dest := range engineJob.Destinations {
+		fmt.Printf("%s:\n", dest)
+		files, err := engine.List(ctx, dest)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			code = 1
+			continue
+		}
+
+		for _, file := range files {
+			fmt.Printf("  %s  %10s  %s\n", file.ModTime.Format("2006-01-02 15:04"), humanize.Bytes(uint64(file.Size)), file.Name) //nolint:gosec
+		}
+	}
+	return code
+}
+
+func listSnapshots(ctx context.Context, job engine.Job) int {
+	code := 0
+	for _, dest := range job.Destinations {
+		fmt.Printf("%s:\n", dest)
+		snapshots, err := engine.Snapshots(ctx, job, dest)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			code = 1
+			continue
+		}
+
+		for _, snapshot := range snapshots {
+			fmt.Printf("  %s  %s  %10s  %s\n", snapshot.ID, snapshot.Job, humanize.Bytes(uint64(snapshot.Size())), strings.Join(snapshot.Paths, ", ")) //nolint:gosec
+		}
+	}
+	return code
+}
+
+// restoreSnapshot restores the snapshot with the given ID from the first of
+// the job's destinations that has it.
+func restoreSnapshot(ctx context.Context, job engine.Job, id, targetDir string, events chan<- engine.Event) error {
+	var errs []error
+	for _, dest := range job.Destinations {
+		snapshots, err := engine.Snapshots(ctx, job, dest)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dest, err))
+			continue
+		}
+		for _, snapshot := range snapshots {
+			if snapshot.ID == id {
+				return engine.RestoreSnapshot(ctx, job, dest, id, targetDir, events)
+			}
+		}
+	}
+	errs = append(errs, fmt.Errorf("snapshot %s not found", id))
+	return errors.Join(errs...)
+}
+
+// RunRestore downloads an archive from the first of a saved job's
+// destinations that has it and extracts it. It returns the process exit code.
+func RunRestore(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	at := flags.String("at", "", "restore `time` (RFC 3339 or YYYY-MM-DD): the second argument is the backed up path")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: backup-tui restore <job> <archive> <target dir>")
+		fmt.Fprintln(flags.Output(), "       backup-tui restore -at <time> <job> <path> <target dir>")
+		fmt.Fprintln(flags.Output(), "       backup-tui restore <job> <snapshot> <target dir>   (repository mode jobs)")
+		fmt.Fprintln(flags.Output(), "Use backup-tui list <job> to see the stored archives. Incremental")
+		fmt.Fprintln(flags.Output(), "archives are restored together with the archives they build on.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 3 {
+		flags.Usage()
+		return 2
+	}
+
+	var atTime time.Time
+	if *at != "" {
+		var err error
+		if atTime, err = parseTime(*at); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 2
+		}
+	}
+
+	job, passwords, err := loadJob(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	workDir, err := os.MkdirTemp("", "backup-tui-restore-*")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	defer os.RemoveAll(workDir)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+
+	events := make(chan engine.Event)
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		for event := range events {
+			log.Println(event)
+			fmt.Println(event)
+		}
+	}()
+
+	log.Printf("Restoring %s from job %s into %s", flags.Arg(1), job.Name, flags.Arg(2))
+	engineJob := job.EngineJob(passwords, workDir)
+	switch {
+	case job.Repository.Enabled:
+		err = restoreSnapshot(ctx, engineJob, flags.Arg(1), flags.Arg(2), events)
+	case *at != "":
+		err = engine.RestoreAt(ctx, engineJob, flags.Arg(1), atTime, flags.Arg(2), events)
+	default:
+		err = engine.Restore(ctx, engineJob, flags.Arg(1), flags.Arg(2), events)
+	}
+	close(events)
+	<-printed
+
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "cancelled")
+		return 130
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	fmt.Println("restore finished")
+	return 0
+}
+
+// parseTime accepts an RFC 3339 time or a date, meaning the end of that day
+// in local time.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339 or YYYY-MM-DD", value)
+	}
+	return day.AddDate(0, 0, 1).Add(-time.Second), nil
+}
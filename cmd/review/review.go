@@ -0,0 +1,596 @@
+package review
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Chanadu/backup-tui/cmd/jobs"
+	"github.com/Chanadu/backup-tui/cmd/stage"
+	"github.com/Chanadu/backup-tui/cmd/utils"
+	"github.com/Chanadu/backup-tui/pkg/engine"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dustin/go-humanize"
+)
+
+// ConfirmMsg is sent when the user confirms the backup should be created.
+type ConfirmMsg struct{}
+
+// BrowseSnapshotsMsg is sent when the user wants to browse the snapshots of
+// a repository mode job.
+type BrowseSnapshotsMsg struct{}
+
+// EditMsg is sent when the user wants to change the section handled by
+// Stage.
+type EditMsg struct {
+	Stage stage.Stage
+}
+
+type sizesMsg struct {
+	sizes map[string]int64
+	errs  map[string]error
+}
+
+// compressionRates are rough 7z throughputs in bytes per second for each
+// compression level, used for the time estimate.
+var compressionRates = [...]float64{
+	0: 200e6,
+	1: 50e6,
+	2: 40e6,
+	3: 20e6,
+	4: 15e6,
+	5: 8e6,
+	6: 6e6,
+	7: 4e6,
+	8: 3e6,
+	9: 2e6,
+}
+
+type ReviewModel struct {
+	job     engine.Job
+	jobName string
+
+	sizing   bool
+	sizes    map[string]int64
+	sizeErrs map[string]error
+
+	saving bool
+	name   textinput.Model
+	status string
+
+	// enteringPassword prompts for the repository password, then sends
+	// afterPassword if set.
+	enteringPassword bool
+	password         textinput.Model
+	afterPassword    tea.Msg
+
+	// addingCommand prompts for a command source, uploaded as a stream if
+	// streamCommand is set.
+	addingCommand bool
+	streamCommand bool
+	command       textinput.Model
+}
+
+func InitialReviewModel(job engine.Job, jobName string) ReviewModel {
+	name := textinput.New()
+	name.Prompt = "Job name: "
+	name.Placeholder = "ex: pi-home"
+	name.CharLimit = 64
+	name.Width = 30
+	name.SetValue(jobName)
+
+	password := textinput.New()
+	password.Prompt = "Repository password: "
+	password.EchoMode = textinput.EchoPassword
+	password.Width = 30
+	password.SetValue(job.RepositoryPassword)
+
+	command := textinput.New()
+	command.Prompt = "Command source: "
+	command.Placeholder = "ex: mydb.sql: pg_dump mydb"
+	command.Width = 50
+
+	return ReviewModel{
+		job:      job,
+		jobName:  jobName,
+		sizing:   true,
+		name:     name,
+		password: password,
+		command:  command,
+	}
+}
+
+// Archive returns the archive settings, including any changes made here.
+func (m ReviewModel) Archive() engine.ArchiveSettings {
+	return m.job.Archive
+}
+
+// Replication returns the replication policy, including any changes made
+// here.
+func (m ReviewModel) Replication() engine.Replication {
+	return m.job.Replication
+}
+
+// Incremental returns the incremental settings, including any changes made
+// here.
+func (m ReviewModel) Incremental() engine.Incremental {
+	return m.job.Incremental
+}
+
+// Repository returns the repository mode settings, including any changes
+// made here.
+func (m ReviewModel) Repository() engine.RepositorySettings {
+	return m.job.Repository
+}
+
+// RepositoryPassword returns the password entered for the repository.
+func (m ReviewModel) RepositoryPassword() string {
+	return m.job.RepositoryPassword
+}
+
+// Commands returns the command sources, including any changes made here.
+func (m ReviewModel) Commands() []engine.CommandSource {
+	return m.job.Commands
+}
+
+// Retention returns the retention, including any changes made here.
+func (m ReviewModel) Retention() engine.Retention {
+	return m.job.Retention
+}
+
+// JobName returns the name the job was last saved as.
+func (m ReviewModel) JobName() string {
+	return m.jobName
+}
+
+func (m ReviewModel) computeSizes() tea.Msg {
+	msg := sizesMsg{
+		sizes: map[string]int64{},
+		errs:  map[string]error{},
+	}
+	for _, path := range m.job.Paths {
+		size, err := utils.PathSize(path)
+		if err != nil {
+			log.Printf("Couldn't size %s, error: %v", path, err)
+			msg.errs[path] = err
+		}
+		msg.sizes[path] = size
+	}
+	return msg
+}
+
+func (m ReviewModel) Init() tea.Cmd {
+	return m.computeSizes
+}
+
+func (m ReviewModel) Update(msg tea.Msg) (ReviewModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case sizesMsg:
+		m.sizing = false
+		m.sizes = msg.sizes
+		m.sizeErrs = msg.errs
+	case tea.KeyMsg:
+		if m.saving {
+			return m.updateSaving(msg)
+		}
+		if m.enteringPassword {
+			return m.updatePassword(msg)
+		}
+		if m.addingCommand {
+			return m.updateCommand(msg)
+		}
+
+		switch msg.String() {
+		case "enter":
+			return m.needPassword(ConfirmMsg{})
+		case "esc":
+			return m, stage.BackCmd
+		case "1":
+			return m, func() tea.Msg { return EditMsg{Stage: stage.Input} }
+		case "2":
+			return m, func() tea.Msg { return EditMsg{Stage: stage.Files} }
+		case "q":
+			// Cycles through requiring all destinations, then 1..n-1.
+			n := len(m.job.Destinations)
+			if n > 1 {
+				m.job.Replication.Quorum = (m.job.Replication.Quorum + 1) % n
+			}
+		case "c":
+			m.job.Replication.Concurrent = !m.job.Replication.Concurrent
+		case "i":
+			if !m.job.Archive.Streamed() {
+				m.job.Incremental = nextIncremental(m.job.Incremental)
+				m.job.Archive.NameTemplate = fitNameTemplate(m.job)
+			}
+		case "w":
+			if !m.job.Repository.Enabled {
+				i := slices.Index(engine.WorkerCounts, max(m.job.Archive.Workers, 1))
+				m.job.Archive.Workers = engine.WorkerCounts[(i+1)%len(engine.WorkerCounts)]
+			}
+		case "u":
+			if !m.job.Repository.Enabled {
+				m.job.Archive.StoreCompressed = !m.job.Archive.StoreCompressed
+			}
+		case "n":
+			if !m.job.Repository.Enabled {
+				// Jobs saved before templates could be chosen use the
+				// default one.
+				templates := engine.NameTemplatesFor(m.job)
+				i := max(slices.Index(templates, m.job.Archive.NameTemplate), 0)
+				m.job.Archive.NameTemplate = templates[(i+1)%len(templates)]
+			}
+		case "v":
+			if m.job.Repository.Enabled {
+				break
+			}
+			i := slices.Index(engine.VolumeSizes, m.job.Archive.VolumeSize)
+			m.job.Archive.VolumeSize = engine.VolumeSizes[(i+1)%len(engine.VolumeSizes)]
+		case "f":
+			if m.job.Repository.Enabled {
+				break
+			}
+			i := slices.Index(engine.ArchiveFormats, m.job.Archive.Format)
+			m.job.Archive.Format = engine.ArchiveFormats[(i+1)%len(engine.ArchiveFormats)]
+			if m.job.Archive.Streamed() {
+				// Streamed archives are always full ones.
+				m.job.Incremental.Enabled = false
+			}
+			m.job.Archive.NameTemplate = fitNameTemplate(m.job)
+		case "m":
+			m.job.Repository.Enabled = !m.job.Repository.Enabled
+		case "z":
+			if m.job.Repository.Enabled {
+				m.job.Repository.Compress = !m.job.Repository.Compress
+			}
+		case "e":
+			if !m.job.Repository.Enabled {
+				break
+			}
+			m.job.Repository.Encrypt = !m.job.Repository.Encrypt
+			if m.job.Repository.Encrypt {
+				return m.askPassword(nil)
+			}
+		case "b":
+			if m.job.Repository.Enabled {
+				return m.needPassword(BrowseSnapshotsMsg{})
+			}
+		case "]":
+			m.job.Retention.KeepLast++
+		case "[":
+			m.job.Retention.KeepLast = max(m.job.Retention.KeepLast-1, 0)
+		case "s":
+			m.saving = true
+			m.status = ""
+			return m, m.name.Focus()
+		case "a", "A":
+			if m.job.Repository.Enabled {
+				break
+			}
+			m.addingCommand = true
+			m.streamCommand = msg.String() == "A"
+			m.status = ""
+			m.command.SetValue("")
+			return m, m.command.Focus()
+		case "x":
+			if n := len(m.job.Commands); n > 0 {
+				m.job.Commands = m.job.Commands[:n-1]
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func (m ReviewModel) updateSaving(msg tea.KeyMsg) (ReviewModel, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.saving = false
+		m.name.Blur()
+		return m, nil
+	case "enter":
+		job := jobs.FromEngineJob(strings.TrimSpace(m.name.Value()), m.job)
+		if existing, err := jobs.Load(job.Name); err == nil {
+			// The schedule and notifiers aren't edited here, keep the
+			// ones already set.
+			job.Schedule = existing.Schedule
+			job.Notify = existing.Notify
+		}
+		if err := jobs.Save(job); err != nil {
+			m.status = fmt.Sprintf("Couldn't save job: %v", err)
+			return m, nil
+		}
+
+		log.Printf("Saved job %s", job.Name)
+		m.saving = false
+		m.name.Blur()
+		m.jobName = job.Name
+		m.status = fmt.Sprintf("Saved job %q.", job.Name)
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.name, cmd = m.name.Update(msg)
+	return m, cmd
+}
+
+// needPassword sends next, first asking for the repository password if the
+// job's repository is encrypted and none was entered yet.
+func (m ReviewModel) needPassword(next tea.Msg) (ReviewModel, tea.Cmd) {
+	repo := m.job.Repository
+	if repo.Enabled && repo.Encrypt && m.job.RepositoryPassword == "" {
+		m.status = "The repository is encrypted, enter its password."
+		return m.askPassword(next)
+	}
+	return m, func() tea.Msg { return next }
+}
+
+func (m ReviewModel) askPassword(next tea.Msg) (ReviewModel, tea.Cmd) {
+	m.enteringPassword = true
+	m.afterPassword = next
+	return m, m.password.Focus()
+}
+
+func (m ReviewModel) updatePassword(msg tea.KeyMsg) (ReviewModel, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.enteringPassword = false
+		m.password.Blur()
+		return m, nil
+	case "enter":
+		if m.password.Value() == "" {
+			m.status = "The password can't be empty."
+			return m, nil
+		}
+		m.enteringPassword = false
+		m.password.Blur()
+		m.job.RepositoryPassword = m.password.Value()
+		m.status = ""
+		if next := m.afterPassword; next != nil {
+			return m, func() tea.Msg { return next }
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.password, cmd = m.password.Update(msg)
+	return m, cmd
+}
+
+// updateCommand reads a command source as "<file name>: <command>".
+func (m ReviewModel) updateCommand(msg tea.KeyMsg) (ReviewModel, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.addingCommand = false
+		m.command.Blur()
+		return m, nil
+	case "enter":
+		name, command, _ := strings.Cut(m.command.Value(), ":")
+		source := engine.CommandSource{
+			Name:    strings.TrimSpace(name),
+			Command: strings.TrimSpace(command),
+			Stream:  m.streamCommand,
+		}
+		if err := source.Validate(); err != nil {
+			m.status = fmt.Sprintf("Expected <file name>: <command>, %v.", err)
+			return m, nil
+		}
+		m.addingCommand = false
+		m.command.Blur()
+		m.job.Commands = append(m.job.Commands, source)
+		m.status = ""
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.command, cmd = m.command.Update(msg)
+	return m, cmd
+}
+
+func (m ReviewModel) totalSize() int64 {
+	var total int64
+	for _, size := range m.sizes {
+		total += size
+	}
+	return total
+}
+
+// fullEveryChoices are the chain lengths the i key cycles through.
+var fullEveryChoices = []int{engine.DefaultFullEvery, 14, 30}
+
+// nextIncremental cycles from full backups through incremental ones with
+// increasingly long chains and back.
+func nextIncremental(current engine.Incremental) engine.Incremental {
+	if !current.Enabled {
+		return engine.Incremental{Enabled: true, FullEvery: fullEveryChoices[0]}
+	}
+	for i, fullEvery := range fullEveryChoices[:len(fullEveryChoices)-1] {
+		if current.FullEvery == fullEvery {
+			return engine.Incremental{Enabled: true, FullEvery: fullEveryChoices[i+1]}
+		}
+	}
+	return engine.Incremental{}
+}
+
+// fitNameTemplate returns the job's name template, or the default one if
+// the job's is an offered template that doesn't fit it anymore, such as a
+// dated one after switching to incremental backups.
+func fitNameTemplate(job engine.Job) string {
+	template := job.Archive.NameTemplate
+	if slices.Contains(engine.NameTemplates, template) && !slices.Contains(engine.NameTemplatesFor(job), template) {
+		return ""
+	}
+	return template
+}
+
+// estimate guesses how long compressing size bytes with archive's settings
+// takes.
+func estimate(size int64, archive engine.ArchiveSettings) time.Duration {
+	// Levels come from job files, which may be edited by hand.
+	level := max(0, min(archive.Level, len(compressionRates)-1))
+	switch {
+	case archive.Method == engine.MethodStore:
+		level = 0
+	case archive.Method == engine.MethodZstd || archive.Streamed():
+		// zstd runs closer to the speed of LZMA2's lowest levels.
+		level = min(level, 1)
+	}
+	seconds := float64(size) / compressionRates[level]
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+}
+
+func (m ReviewModel) View() string {
+	var s strings.Builder
+	s.WriteString("Review Backup\n\n")
+
+	fmt.Fprintf(&s, "[1] Destinations (%d)\n", len(m.job.Destinations))
+	for _, dest := range m.job.Destinations {
+		fmt.Fprintf(&s, "  %-6s        %s\n", dest.Type, dest)
+	}
+	if len(m.job.Destinations) > 1 {
+		fmt.Fprintf(&s, "  Replication:  %s (q/c to change)\n", m.job.Replication)
+	}
+	s.WriteString("\n")
+
+	fmt.Fprintf(&s, "[2] Files (%d)\n", len(m.job.Paths))
+	for _, path := range m.job.Paths {
+		switch {
+		case m.sizing:
+			fmt.Fprintf(&s, "  %10s  %s\n", "…", path)
+		case m.sizeErrs[path] != nil:
+			fmt.Fprintf(&s, "  %10s  %s (%v)\n", "?", path, m.sizeErrs[path])
+		default:
+			fmt.Fprintf(&s, "  %10s  %s\n", humanize.Bytes(uint64(m.sizes[path])), path) //nolint:gosec
+		}
+	}
+	if !m.sizing {
+		fmt.Fprintf(&s, "  %10s  total\n", humanize.Bytes(uint64(m.totalSize()))) //nolint:gosec
+	}
+	s.WriteString("\n")
+
+	if !m.job.Repository.Enabled {
+		m.commandsView(&s)
+	}
+
+	if m.job.Repository.Enabled {
+		m.repositoryView(&s)
+	} else {
+		m.archiveView(&s)
+	}
+	m.hooksView(&s)
+	s.WriteString("\n")
+
+	switch {
+	case m.saving:
+		s.WriteString(m.name.View() + "\n")
+		s.WriteString("Press enter to save, esc to cancel.\n")
+	case m.enteringPassword:
+		s.WriteString(m.password.View() + "\n")
+		s.WriteString("Press enter to confirm, esc to cancel.\n")
+	case m.addingCommand:
+		s.WriteString(m.command.View() + "\n")
+		if m.streamCommand {
+			s.WriteString("Its output is compressed and uploaded as it is produced.\n")
+		}
+		s.WriteString("Press enter to add, esc to cancel.\n")
+	case m.job.Repository.Enabled:
+		s.WriteString("Press enter to store a snapshot, b to browse snapshots, 1/2 to edit a section, s to save as a job.\n")
+	default:
+		s.WriteString("Press enter to create the backup, 1/2 to edit a section, s to save as a job.\n")
+	}
+	if m.status != "" {
+		s.WriteString(m.status + "\n")
+	}
+
+	return s.String()
+}
+
+// commandsView lists the command sources backed up next to the files.
+func (m ReviewModel) commandsView(s *strings.Builder) {
+	fmt.Fprintf(s, "Commands (%d) (a to add, A to add streamed, x to remove the last)\n", len(m.job.Commands))
+	for _, source := range m.job.Commands {
+		fmt.Fprintf(s, "  %s\n", source)
+	}
+	s.WriteString("\n")
+}
+
+// hooksView lists the job's hooks, set in the job file, if it has any.
+func (m ReviewModel) hooksView(s *strings.Builder) {
+	for _, point := range engine.HookPoints {
+		if hook := m.job.Hooks.Get(point); hook.Command != "" {
+			fmt.Fprintf(s, "  Hook %-12s%s\n", point+":", hook.Command)
+		}
+	}
+}
+
+func (m ReviewModel) retentionView(s *strings.Builder, unit string) {
+	if m.job.Retention.KeepLast > 0 {
+		fmt.Fprintf(s, "  Retention:    keep last %d %s ([/] to change)\n", m.job.Retention.KeepLast, unit)
+	} else {
+		fmt.Fprintf(s, "  Retention:    keep all %s ([/] to change)\n", unit)
+	}
+}
+
+func (m ReviewModel) archiveView(s *strings.Builder) {
+	s.WriteString("Archive (m for repository mode)\n")
+	if m.job.Archive.Streamed() {
+		fmt.Fprintf(s, "  Format:       %s, streamed without a local copy (f to change)\n", m.job.Archive.Format)
+		fmt.Fprintf(s, "  Mode:         %s (incremental needs %s)\n", m.job.Incremental, engine.Format7z)
+	} else {
+		fmt.Fprintf(s, "  Format:       %s (f to change)\n", m.job.Archive.Format)
+		fmt.Fprintf(s, "  Mode:         %s (i to change)\n", m.job.Incremental)
+	}
+	fmt.Fprintf(s, "  Compression:  %s (1 to edit)\n", m.job.Archive.CompressionString())
+	fmt.Fprintf(s, "  Threads:      %s (1 to edit)\n", m.job.Archive.ThreadsString())
+	fmt.Fprintf(s, "  Workers:      %s (w to change)\n", m.job.Archive.WorkersString())
+	fmt.Fprintf(s, "  Compressed:   %s (u to change)\n", onOff(m.job.Archive.StoreCompressed,
+		"stored as is when mostly photos, videos or archives", "compressed again"))
+	m.namesView(s)
+	if m.job.Archive.VolumeSize != "" {
+		fmt.Fprintf(s, "  Volumes:      split every %s (v to change)\n", m.job.Archive.VolumeSize)
+	} else {
+		s.WriteString("  Volumes:      single file (v to split)\n")
+	}
+	s.WriteString("  Encryption:   none\n")
+	m.retentionView(s, "archives")
+	s.WriteString("\n")
+
+	if m.sizing {
+		s.WriteString("Estimated time: calculating...\n")
+	} else {
+		fmt.Fprintf(s, "Estimated time: ~%s to compress\n", estimate(m.totalSize(), m.job.Archive))
+	}
+}
+
+// namesView shows the job's name template with the name it gives the first
+// archive.
+func (m ReviewModel) namesView(s *strings.Builder) {
+	template := m.job.NameTemplateString()
+	example, err := engine.ExampleName(m.job)
+	switch {
+	case err != nil:
+		fmt.Fprintf(s, "  Names:        %s, %v (n to change)\n", template, err)
+	case example != "":
+		fmt.Fprintf(s, "  Names:        %s, such as %s (n to change)\n", template, example)
+	default:
+		fmt.Fprintf(s, "  Names:        %s (n to change)\n", template)
+	}
+}
+
+func (m ReviewModel) repositoryView(s *strings.Builder) {
+	repo := m.job.Repository
+	s.WriteString("Repository (m for archive mode)\n")
+	s.WriteString("  Storage:      deduplicated chunks, only new ones are uploaded\n")
+	fmt.Fprintf(s, "  Compression:  %s (z to change)\n", onOff(repo.Compress, "zstd", "none"))
+	fmt.Fprintf(s, "  Encryption:   %s (e to change)\n", onOff(repo.Encrypt, "AES-256-GCM", "none"))
+	m.retentionView(s, "snapshots")
+}
+
+func onOff(on bool, yes, no string) string {
+	if on {
+		return yes
+	}
+	return no
+}
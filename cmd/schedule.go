@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Chanadu/backup-tui/cmd/jobs"
+	"github.com/Chanadu/backup-tui/cmd/systemd"
+	"github.com/Chanadu/backup-tui/cmd/utils"
+	"golang.org/x/term"
+)
+
+// confirm asks question on the terminal. Without a terminal nothing can be
+// confirmed and it returns false.
+func confirm(question string) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) { //nolint:gosec
+		return false
+	}
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// RunSchedule installs, lists and removes systemd user timers running saved
+// jobs. It returns the process exit code.
+func RunSchedule(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: backup-tui schedule install [-calendar <expr>] [-dir <dir>] [-activate] <job>")
+		fmt.Fprintln(os.Stderr, "       backup-tui schedule list [-dir <dir>]")
+		fmt.Fprintln(os.Stderr, "       backup-tui schedule remove [-dir <dir>] <job>")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	switch args[0] {
+	case "install":
+		return scheduleInstall(args[1:])
+	case "list":
+		return scheduleList(args[1:])
+	case "remove":
+		return scheduleRemove(args[1:])
+	}
+	usage()
+	return 2
+}
+
+// unitFlags adds the flags shared by the schedule commands.
+func unitFlags(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet("schedule "+name, flag.ContinueOnError)
+	defaultDir, _ := systemd.Dir()
+	dir := flags.String("dir", defaultDir, "`directory` the units are written to")
+	return flags, dir
+}
+
+func scheduleInstall(args []string) int {
+	flags, dir := unitFlags("install")
+	calendar := flags.String("calendar", "", "OnCalendar `expression`, instead of converting the job's schedule")
+	activate := flags.Bool("activate", false, "enable and start the timer without asking")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	job, err := jobs.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	var timer systemd.Timer
+	switch {
+	case *calendar != "":
+		timer = systemd.Timer{OnCalendar: *calendar}
+	case job.Schedule != "":
+		if timer, err = systemd.TimerFor(job.Schedule); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\nPass -calendar to give the OnCalendar expression yourself.\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "error: job %s has no schedule, pass -calendar\n", job.Name)
+		return 1
+	}
+
+	ctx := context.Background()
+	if err := timer.Validate(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: finding the backup-tui binary:", err)
+		return 1
+	}
+	configDir, err := utils.ConfigDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	envFile := filepath.Join(configDir, "env", job.Name+".env")
+
+	service, timerUnit := systemd.Units(job.Name, exe, envFile, timer)
+	paths, err := systemd.Write(*dir, job.Name, service, timerUnit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	for _, path := range paths {
+		fmt.Println("wrote", path)
+	}
+	fmt.Printf("Schedule: %s\n", timer)
+	fmt.Printf("Passwords can be set in %s, the scheduled run can't prompt for them.\n", envFile)
+
+	unit := systemd.UnitName(job.Name) + ".timer"
+	if !*activate && !confirm(fmt.Sprintf("Enable and start %s now?", unit)) {
+		fmt.Printf("Not activated. To activate later run:\n  systemctl --user daemon-reload\n  systemctl --user enable --now %s\n", unit)
+		return 0
+	}
+	if err := systemd.Systemctl(ctx, "daemon-reload"); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	if err := systemd.Systemctl(ctx, "enable", "--now", unit); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	fmt.Println("activated", unit)
+	return 0
+}
+
+func scheduleList(args []string) int {
+	flags, dir := unitFlags("list")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	installed, err := systemd.List(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	if len(installed) == 0 {
+		fmt.Println("no scheduled jobs in", *dir)
+		return 0
+	}
+
+	ctx := context.Background()
+	for _, unit := range installed {
+		state := systemd.ActiveState(ctx, filepath.Base(unit.Path))
+		if state == "" {
+			state = "unknown"
+		}
+		fmt.Printf("%-20s  %-30s  %s\n", unit.Job, unit.Timer, state)
+	}
+	return 0
+}
+
+func scheduleRemove(args []string) int {
+	flags, dir := unitFlags("remove")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	name := flags.Arg(0)
+	if err := jobs.ValidateName(name); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	unit := systemd.UnitName(name) + ".timer"
+	state := systemd.ActiveState(ctx, unit)
+	if state == "active" {
+		if !confirm(fmt.Sprintf("%s is active, stop and disable it?", unit)) {
+			fmt.Println("Left it in place.")
+			return 1
+		}
+		if err := systemd.Systemctl(ctx, "disable", "--now", unit); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+	}
+
+	removed, err := systemd.Remove(*dir, name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	if len(removed) == 0 {
+		fmt.Printf("no units for job %s in %s\n", name, *dir)
+		return 1
+	}
+	for _, path := range removed {
+		fmt.Println("removed", path)
+	}
+	if state != "" {
+		if err := systemd.Systemctl(ctx, "daemon-reload"); err != nil {
+			fmt.Fprintln(os.Stderr, "warning:", err)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,323 @@
+package snapshots
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Chanadu/backup-tui/cmd/stage"
+	"github.com/Chanadu/backup-tui/cmd/stream"
+	"github.com/Chanadu/backup-tui/pkg/engine"
+	"github.com/Chanadu/backup-tui/pkg/repository"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dustin/go-humanize"
+)
+
+// cancelTimeout bounds how long Cancel waits for a restore to stop.
+const cancelTimeout = 10 * time.Second
+
+type loadedMsg struct {
+	dest      int
+	snapshots []repository.Snapshot
+	err       error
+}
+
+type forgotMsg struct {
+	ids []string
+	err error
+}
+
+// mode is what the browser is waiting for from the user.
+type mode int
+
+const (
+	browsing mode = iota
+	choosingTarget
+	confirmingForget
+	restoring
+)
+
+// SnapshotsModel lists the snapshots in the repository at each of a job's
+// destinations and restores or forgets them.
+type SnapshotsModel struct {
+	job  engine.Job
+	dest int
+
+	loading   bool
+	snapshots []repository.Snapshot
+	cursor    int
+	err       error
+	status    string
+
+	mode   mode
+	target textinput.Model
+	// forget are the IDs waiting for confirmation in confirmingForget.
+	forget []string
+
+	stream   *stream.Stream
+	restored int64
+	total    int64
+}
+
+// Cancel stops a running restore.
+func (m SnapshotsModel) Cancel() {
+	if m.mode != restoring {
+		return
+	}
+	log.Printf("Cancelling snapshot restore")
+	if !m.stream.CancelAndWait(cancelTimeout) {
+		log.Printf("Snapshot restore didn't stop within %s", cancelTimeout)
+	}
+}
+
+// Busy reports whether a restore is running.
+func (m SnapshotsModel) Busy() bool {
+	return m.mode == restoring
+}
+
+func (m SnapshotsModel) load() tea.Msg {
+	dest := m.dest
+	snapshots, err := engine.Snapshots(context.Background(), m.job, m.job.Destinations[dest])
+	if err != nil {
+		log.Printf("Couldn't list snapshots on %s, error: %v", m.job.Destinations[dest], err)
+	}
+	return loadedMsg{dest: dest, snapshots: snapshots, err: err}
+}
+
+func (m SnapshotsModel) forgetCmd(ids []string) tea.Cmd {
+	config := m.job.Destinations[m.dest]
+	return func() tea.Msg {
+		err := engine.ForgetSnapshots(context.Background(), m.job, config, ids)
+		return forgotMsg{ids: ids, err: err}
+	}
+}
+
+func (m SnapshotsModel) Init() tea.Cmd {
+	if len(m.job.Destinations) == 0 {
+		return nil
+	}
+	return m.load
+}
+
+func (m SnapshotsModel) selected() (repository.Snapshot, bool) {
+	if m.cursor < 0 || m.cursor >= len(m.snapshots) {
+		return repository.Snapshot{}, false
+	}
+	return m.snapshots[m.cursor], true
+}
+
+func (m SnapshotsModel) Update(msg tea.Msg) (SnapshotsModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case loadedMsg:
+		if msg.dest != m.dest {
+			break
+		}
+		m.loading = false
+		m.snapshots = msg.snapshots
+		m.err = msg.err
+		m.cursor = min(m.cursor, max(len(m.snapshots)-1, 0))
+	case forgotMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Couldn't forget snapshots: %v", msg.err)
+			return m, nil
+		}
+		m.status = fmt.Sprintf("Forgot %d snapshots.", len(msg.ids))
+		m.loading = true
+		return m, m.load
+
+	case stream.EventMsg:
+		if msg.Stream != m.stream {
+			break
+		}
+		if event, ok := msg.Event.(engine.Progress); ok {
+			m.restored, m.total = event.Done, event.Total
+		}
+		return m, m.stream.Next()
+	case stream.DoneMsg:
+		if msg.Stream != m.stream {
+			break
+		}
+		m.mode = browsing
+		if msg.Err != nil {
+			m.status = fmt.Sprintf("Restore failed: %v", msg.Err)
+		} else {
+			m.status = fmt.Sprintf("Restored to %s.", m.target.Value())
+		}
+
+	case tea.KeyMsg:
+		switch m.mode {
+		case choosingTarget:
+			return m.updateTarget(msg)
+		case confirmingForget:
+			return m.updateConfirm(msg)
+		case restoring:
+			return m, nil
+		}
+		return m.updateBrowsing(msg)
+	}
+	return m, nil
+}
+
+func (m SnapshotsModel) updateBrowsing(msg tea.KeyMsg) (SnapshotsModel, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		return m, stage.BackCmd
+	case "up", "k":
+		m.cursor = max(m.cursor-1, 0)
+	case "down", "j":
+		m.cursor = min(m.cursor+1, max(len(m.snapshots)-1, 0))
+	case "tab":
+		if len(m.job.Destinations) > 1 {
+			m.dest = (m.dest + 1) % len(m.job.Destinations)
+			m.cursor = 0
+			m.snapshots = nil
+			m.loading = true
+			m.status = ""
+			return m, m.load
+		}
+	case "r":
+		m.loading = true
+		return m, m.load
+	case "enter":
+		snapshot, ok := m.selected()
+		if !ok {
+			break
+		}
+		cwd, _ := os.Getwd()
+		m.target.SetValue(filepath.Join(cwd, "restore-"+snapshot.ID))
+		m.target.CursorEnd()
+		m.mode = choosingTarget
+		m.status = ""
+		return m, m.target.Focus()
+	case "d":
+		if snapshot, ok := m.selected(); ok {
+			m.forget = []string{snapshot.ID}
+			m.mode = confirmingForget
+		}
+	case "p":
+		if m.job.Retention.KeepLast <= 0 {
+			m.status = "The job keeps all snapshots, nothing to prune."
+			break
+		}
+		m.forget = repository.Expired(m.snapshots, m.job.Name, m.job.Retention.KeepLast)
+		if len(m.forget) == 0 {
+			m.status = "No snapshots beyond the retention."
+			break
+		}
+		m.mode = confirmingForget
+	}
+	return m, nil
+}
+
+func (m SnapshotsModel) updateTarget(msg tea.KeyMsg) (SnapshotsModel, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.mode = browsing
+		m.target.Blur()
+		return m, nil
+	case "enter":
+		snapshot, ok := m.selected()
+		target := strings.TrimSpace(m.target.Value())
+		if !ok || target == "" {
+			return m, nil
+		}
+		m.target.Blur()
+		m.mode = restoring
+		m.restored, m.total = 0, snapshot.Size()
+		m.stream = stream.New()
+		config := m.job.Destinations[m.dest]
+		log.Printf("Restoring snapshot %s from %s to %s", snapshot.ID, config, target)
+		return m, m.stream.Start(func(ctx context.Context, events chan<- engine.Event) error {
+			return engine.RestoreSnapshot(ctx, m.job, config, snapshot.ID, target, events)
+		})
+	}
+
+	var cmd tea.Cmd
+	m.target, cmd = m.target.Update(msg)
+	return m, cmd
+}
+
+func (m SnapshotsModel) updateConfirm(msg tea.KeyMsg) (SnapshotsModel, tea.Cmd) {
+	switch msg.String() {
+	case "y", "Y":
+		m.mode = browsing
+		m.status = fmt.Sprintf("Forgetting %d snapshots...", len(m.forget))
+		return m, m.forgetCmd(m.forget)
+	case "n", "N", "esc":
+		m.mode = browsing
+		m.forget = nil
+	}
+	return m, nil
+}
+
+func (m SnapshotsModel) View() string {
+	var s strings.Builder
+	s.WriteString("Snapshots\n\n")
+	if len(m.job.Destinations) == 0 {
+		s.WriteString("No destinations set.\n")
+		return s.String()
+	}
+
+	fmt.Fprintf(&s, "Destination %d of %d: %s\n\n", m.dest+1, len(m.job.Destinations), m.job.Destinations[m.dest])
+	switch {
+	case m.loading:
+		s.WriteString("Loading snapshots...\n")
+	case m.err != nil:
+		fmt.Fprintf(&s, "Couldn't list snapshots: %v\n", m.err)
+	case len(m.snapshots) == 0:
+		s.WriteString("No snapshots yet.\n")
+	}
+	for i, snapshot := range m.snapshots {
+		cursor := "  "
+		if i == m.cursor {
+			cursor = "> "
+		}
+		fmt.Fprintf(&s, "%s%s  %-12s %10s  %d files  %s\n", cursor,
+			snapshot.Time.Local().Format("2006-01-02 15:04"), snapshot.Job,
+			humanize.Bytes(uint64(snapshot.Size())), len(snapshot.Files), //nolint:gosec
+			strings.Join(snapshot.Paths, ", "))
+	}
+	s.WriteString("\n")
+
+	switch m.mode {
+	case choosingTarget:
+		s.WriteString(m.target.View() + "\n")
+		s.WriteString("Press enter to restore, esc to cancel.\n")
+	case confirmingForget:
+		fmt.Fprintf(&s, "Forget %d snapshots and delete the chunks only they use? (y/n)\n", len(m.forget))
+	case restoring:
+		if m.total > 0 {
+			fmt.Fprintf(&s, "Restoring... %d%% (%s of %s)\n", m.restored*100/m.total,
+				humanize.Bytes(uint64(m.restored)), humanize.Bytes(uint64(m.total))) //nolint:gosec
+		} else {
+			s.WriteString("Restoring...\n")
+		}
+	default:
+		s.WriteString("enter: restore, d: forget, p: prune to retention, r: reload")
+		if len(m.job.Destinations) > 1 {
+			s.WriteString(", tab: next destination")
+		}
+		s.WriteString("\n")
+	}
+	if m.status != "" {
+		s.WriteString(m.status + "\n")
+	}
+	return s.String()
+}
+
+func InitialSnapshotsModel(job engine.Job) SnapshotsModel {
+	target := textinput.New()
+	target.Prompt = "Restore to: "
+	target.Width = 50
+
+	return SnapshotsModel{
+		job:     job,
+		loading: len(job.Destinations) > 0,
+		target:  target,
+	}
+}
@@ -11,14 +11,17 @@ func _() {
 	_ = x[Input-0]
 	_ = x[Check-1]
 	_ = x[Files-2]
-	_ = x[Create-3]
-	_ = x[Upload-4]
-	_ = x[Delete-5]
+	_ = x[Review-3]
+	_ = x[Create-4]
+	_ = x[Upload-5]
+	_ = x[Delete-6]
+	_ = x[Snapshots-7]
+	_ = x[History-8]
 }
 
-const _Stage_name = "InputCheckFilesCreateUploadDelete"
+const _Stage_name = "InputCheckFilesReviewCreateUploadDeleteSnapshotsHistory"
 
-var _Stage_index = [...]uint8{0, 5, 10, 15, 21, 27, 33}
+var _Stage_index = [...]uint8{0, 5, 10, 15, 21, 27, 33, 39, 48, 55}
 
 func (i Stage) String() string {
 	idx := int(i) - 0
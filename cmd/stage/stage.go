@@ -8,7 +8,12 @@ const (
 	Input Stage = iota
 	Check
 	Files
+	Review
 	Create
 	Upload
 	Delete
+	// Snapshots browses the snapshots of a repository mode job.
+	Snapshots
+	// History browses the recorded runs.
+	History
 )
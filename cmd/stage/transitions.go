@@ -0,0 +1,54 @@
+package stage
+
+import tea "github.com/charmbracelet/bubbletea"
+
+// BackMsg is sent by a stage's model when the user asks to go back to the
+// previous stage.
+type BackMsg struct{}
+
+func BackCmd() tea.Msg {
+	return BackMsg{}
+}
+
+// transitions lists the stages each stage may move to. Forward moves follow
+// the pipeline; backward moves return to a stage whose state is kept.
+var transitions = map[Stage][]Stage{
+	Input:  {Check, History},
+	Check:  {Input, Files},
+	Files:  {Input, Review},
+	Review: {Input, Files, Create, Snapshots},
+	Create: {Review, Upload},
+	Upload: {Delete},
+	Delete: {},
+
+	Snapshots: {Review},
+	History:   {Input},
+}
+
+// previous is where going back from a stage leads. Stages without an entry
+// can't be left backwards.
+var previous = map[Stage]Stage{
+	Check:  Input,
+	Files:  Input,
+	Review: Files,
+	Create: Review,
+
+	Snapshots: Review,
+	History:   Input,
+}
+
+// CanTransitionTo reports whether moving from s to next is allowed.
+func (s Stage) CanTransitionTo(next Stage) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Previous returns the stage going back from s leads to.
+func (s Stage) Previous() (Stage, bool) {
+	prev, ok := previous[s]
+	return prev, ok
+}
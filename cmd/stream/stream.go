@@ -0,0 +1,98 @@
+package stream
+
+import (
+	"context"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/engine"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// EventMsg delivers an engine event to the model that started the stream.
+type EventMsg struct {
+	Event  engine.Event
+	Stream *Stream
+}
+
+// DoneMsg is sent once the stream's engine call returned.
+type DoneMsg struct {
+	Err    error
+	Stream *Stream
+}
+
+// Stream runs an engine call in the background and turns its events into
+// Bubble Tea messages. Models keep the *Stream they started and ignore
+// messages from any other, so results of abandoned runs are dropped.
+type Stream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	events chan engine.Event
+	err    error
+	done   chan struct{}
+
+	started bool
+}
+
+func New() *Stream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Stream{
+		ctx:    ctx,
+		cancel: cancel,
+		events: make(chan engine.Event),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start runs run in the background and returns the command waiting for its
+// first message.
+func (s *Stream) Start(run func(ctx context.Context, events chan<- engine.Event) error) tea.Cmd {
+	s.started = true
+	go func() {
+		defer close(s.done)
+		s.err = run(s.ctx, s.events)
+		close(s.events)
+	}()
+	return s.Next()
+}
+
+// Next returns the command waiting for the stream's next message. Models
+// call it again after handling each EventMsg.
+func (s *Stream) Next() tea.Cmd {
+	return func() tea.Msg {
+		event, ok := <-s.events
+		if !ok {
+			return DoneMsg{Err: s.err, Stream: s}
+		}
+		return EventMsg{Event: event, Stream: s}
+	}
+}
+
+// Cancel stops the running engine call.
+func (s *Stream) Cancel() {
+	s.cancel()
+}
+
+// CancelAndWait stops the running engine call and waits up to timeout for it
+// to clean up after itself. Events it still sends are discarded. It reports
+// whether the call finished in time.
+func (s *Stream) CancelAndWait(timeout time.Duration) bool {
+	s.cancel()
+	if !s.started {
+		return true
+	}
+
+	events := s.events
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-s.done:
+			return true
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
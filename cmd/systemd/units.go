@@ -0,0 +1,225 @@
+// Package systemd generates systemd user units that run saved jobs on a
+// timer, as an alternative to the built-in daemon.
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/schedule"
+)
+
+const unitPrefix = "backup-tui-"
+
+// Timer is when a job's timer fires: either OnCalendar or, for intervals,
+// Interval after boot and after each run.
+type Timer struct {
+	OnCalendar string
+	Interval   time.Duration
+}
+
+// TimerFor converts a job's schedule into a timer.
+func TimerFor(spec string) (Timer, error) {
+	sched, err := schedule.Parse(spec)
+	if err != nil {
+		return Timer{}, err
+	}
+	if every, ok := sched.(schedule.Every); ok {
+		return Timer{Interval: every.Interval}, nil
+	}
+
+	calendar, err := schedule.OnCalendar(spec)
+	if err != nil {
+		return Timer{}, err
+	}
+	return Timer{OnCalendar: calendar}, nil
+}
+
+func (t Timer) String() string {
+	if t.OnCalendar != "" {
+		return t.OnCalendar
+	}
+	return "every " + t.Interval.String()
+}
+
+// Validate checks the timer's OnCalendar expression, with systemd-analyze
+// when it is installed and otherwise with schedule.ValidateCalendar.
+func (t Timer) Validate(ctx context.Context) error {
+	if t.OnCalendar == "" {
+		if t.Interval < time.Minute {
+			return errors.New("interval is shorter than a minute")
+		}
+		return nil
+	}
+
+	if _, err := exec.LookPath("systemd-analyze"); err != nil {
+		return schedule.ValidateCalendar(t.OnCalendar)
+	}
+	out, err := exec.CommandContext(ctx, "systemd-analyze", "calendar", t.OnCalendar).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemd-analyze rejected %q: %s", t.OnCalendar, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Dir is where systemd looks for the user's units.
+func Dir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "systemd", "user"), nil
+}
+
+// UnitName is the name of job's units without the .service or .timer
+// extension.
+func UnitName(job string) string {
+	return unitPrefix + job
+}
+
+// quote quotes an ExecStart argument if systemd would split it.
+func quote(arg string) string {
+	if !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// Units returns the service and timer units running job with the binary
+// at exe. envFile holds the job's passwords, it is optional.
+func Units(job, exe, envFile string, timer Timer) (service, timerUnit string) {
+	var s strings.Builder
+	fmt.Fprintf(&s, "# Generated by backup-tui schedule install.\n")
+	fmt.Fprintf(&s, "[Unit]\n")
+	fmt.Fprintf(&s, "Description=backup-tui job %s\n\n", job)
+	fmt.Fprintf(&s, "[Service]\n")
+	fmt.Fprintf(&s, "Type=oneshot\n")
+	fmt.Fprintf(&s, "# Set BACKUP_TUI_PASSWORD and friends here, the run can't prompt.\n")
+	fmt.Fprintf(&s, "EnvironmentFile=-%s\n", envFile)
+	fmt.Fprintf(&s, "ExecStart=%s run %s\n", quote(exe), quote(job))
+	service = s.String()
+
+	var t strings.Builder
+	fmt.Fprintf(&t, "# Generated by backup-tui schedule install.\n")
+	fmt.Fprintf(&t, "[Unit]\n")
+	fmt.Fprintf(&t, "Description=Run backup-tui job %s on its schedule\n\n", job)
+	fmt.Fprintf(&t, "[Timer]\n")
+	if timer.OnCalendar != "" {
+		fmt.Fprintf(&t, "OnCalendar=%s\n", timer.OnCalendar)
+		// Runs missed while the machine was off happen at the next boot.
+		fmt.Fprintf(&t, "Persistent=true\n")
+	} else {
+		seconds := int64(timer.Interval / time.Second)
+		fmt.Fprintf(&t, "OnBootSec=%ds\n", seconds)
+		fmt.Fprintf(&t, "OnUnitActiveSec=%ds\n", seconds)
+	}
+	fmt.Fprintf(&t, "\n[Install]\n")
+	fmt.Fprintf(&t, "WantedBy=timers.target\n")
+	return service, t.String()
+}
+
+// Write writes job's units into dir, returning their paths.
+func Write(dir, job, service, timer string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for ext, content := range map[string]string{".service": service, ".timer": timer} {
+		path := filepath.Join(dir, UnitName(job)+ext)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Remove deletes job's units from dir, returning the paths that existed.
+func Remove(dir, job string) ([]string, error) {
+	removed := []string{}
+	for _, ext := range []string{".service", ".timer"} {
+		path := filepath.Join(dir, UnitName(job)+ext)
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// Installed is a job whose timer is in the units dir.
+type Installed struct {
+	Job   string
+	Path  string
+	Timer string
+}
+
+// List returns the jobs with a timer in dir.
+func List(dir string) ([]Installed, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, unitPrefix+"*.timer"))
+	if err != nil {
+		return nil, err
+	}
+
+	installed := []Installed{}
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		job := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), unitPrefix), ".timer")
+		installed = append(installed, Installed{Job: job, Path: path, Timer: timerSetting(data)})
+	}
+	return installed, nil
+}
+
+// timerSetting pulls the schedule out of a generated timer unit.
+func timerSetting(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		switch {
+		case !ok:
+		case key == "OnCalendar":
+			return value
+		case key == "OnUnitActiveSec":
+			return "every " + value
+		}
+	}
+	return "?"
+}
+
+// Systemctl runs systemctl --user with args.
+func Systemctl(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "systemctl", append([]string{"--user"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl --user %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ActiveState returns what systemctl reports about unit, or "" if it can't
+// be asked.
+func ActiveState(ctx context.Context, unit string) string {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return ""
+	}
+	out, _ := exec.CommandContext(ctx, "systemctl", "--user", "is-active", unit).Output()
+	return strings.TrimSpace(string(out))
+}
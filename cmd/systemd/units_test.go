@@ -0,0 +1,129 @@
+package systemd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimerFor(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Timer
+	}{
+		{"0 3 * * *", Timer{OnCalendar: "*-*-* 03:00:00"}},
+		{"30 2 * * 1-5", Timer{OnCalendar: "Mon..Fri *-*-* 02:30:00"}},
+		{"@daily", Timer{OnCalendar: "daily"}},
+		{"@every 6h", Timer{Interval: 6 * time.Hour}},
+	}
+	for _, test := range tests {
+		got, err := TimerFor(test.spec)
+		if err != nil {
+			t.Errorf("TimerFor(%q): %v", test.spec, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("TimerFor(%q) = %+v, want %+v", test.spec, got, test.want)
+		}
+	}
+
+	if _, err := TimerFor("not a schedule"); err == nil {
+		t.Error("TimerFor accepted an invalid schedule")
+	}
+}
+
+const wantService = `# Generated by backup-tui schedule install.
+[Unit]
+Description=backup-tui job pi-home
+
+[Service]
+Type=oneshot
+# Set BACKUP_TUI_PASSWORD and friends here, the run can't prompt.
+EnvironmentFile=-/home/pi/.config/backup-tui/pi-home.env
+ExecStart="/opt/backup tui/backup-tui" run pi-home
+`
+
+func TestUnits(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  string
+		timer string
+		// setting is what List reports for the timer.
+		setting string
+	}{
+		{
+			name: "cron",
+			spec: "0 3 * * *",
+			timer: `# Generated by backup-tui schedule install.
+[Unit]
+Description=Run backup-tui job pi-home on its schedule
+
+[Timer]
+OnCalendar=*-*-* 03:00:00
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`,
+			setting: "*-*-* 03:00:00",
+		},
+		{
+			name: "every",
+			spec: "@every 6h",
+			timer: `# Generated by backup-tui schedule install.
+[Unit]
+Description=Run backup-tui job pi-home on its schedule
+
+[Timer]
+OnBootSec=21600s
+OnUnitActiveSec=21600s
+
+[Install]
+WantedBy=timers.target
+`,
+			setting: "every 21600s",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timer, err := TimerFor(test.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			service, timerUnit := Units("pi-home", "/opt/backup tui/backup-tui", "/home/pi/.config/backup-tui/pi-home.env", timer)
+			if service != wantService {
+				t.Errorf("service unit:\n%s\nwant:\n%s", service, wantService)
+			}
+			if timerUnit != test.timer {
+				t.Errorf("timer unit:\n%s\nwant:\n%s", timerUnit, test.timer)
+			}
+
+			dir := t.TempDir()
+			if _, err := Write(dir, "pi-home", service, timerUnit); err != nil {
+				t.Fatal(err)
+			}
+			installed, err := List(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(installed) != 1 || installed[0].Job != "pi-home" || installed[0].Timer != test.setting {
+				t.Errorf("List = %+v, want pi-home with timer %q", installed, test.setting)
+			}
+		})
+	}
+}
+
+func TestTimerSetting(t *testing.T) {
+	tests := []struct {
+		unit string
+		want string
+	}{
+		{"[Timer]\nOnCalendar=weekly\nPersistent=true\n", "weekly"},
+		{"[Timer]\nOnBootSec=60s\nOnUnitActiveSec=60s\n", "every 60s"},
+		{"[Timer]\n# hand written\n", "?"},
+	}
+	for _, test := range tests {
+		if got := timerSetting([]byte(test.unit)); got != test.want {
+			t.Errorf("timerSetting(%q) = %q, want %q", test.unit, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	checkServer "github.com/Chanadu/backup-tui/cmd/checkserver"
+	"github.com/Chanadu/backup-tui/cmd/createbackups"
+	"github.com/Chanadu/backup-tui/cmd/getfiles"
+	"github.com/Chanadu/backup-tui/cmd/historyview"
+	"github.com/Chanadu/backup-tui/cmd/hookpane"
+	"github.com/Chanadu/backup-tui/cmd/jobs"
+	"github.com/Chanadu/backup-tui/cmd/review"
+	"github.com/Chanadu/backup-tui/cmd/snapshots"
+	"github.com/Chanadu/backup-tui/cmd/stage"
+	"github.com/Chanadu/backup-tui/cmd/uploadbackups"
+	"github.com/Chanadu/backup-tui/cmd/utils"
+	"github.com/Chanadu/backup-tui/pkg/engine"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// job describes the backup built up by the stages so far.
+func (m model) job() engine.Job {
+	return engine.Job{
+		Name:         m.jobName,
+		Destinations: m.paramsData.Destinations,
+		Replication:  m.replication,
+		Paths:        m.filesSelected,
+		Commands:     m.commands,
+		Archive:      m.archive,
+		Retention:    m.retention,
+		Incremental:  m.incremental,
+		Hooks:        m.hooks,
+		ManifestDir:  jobs.ManifestDir(m.jobName),
+		WorkDir:      m.tempDir,
+
+		Repository:         m.repository,
+		RepositoryPassword: m.repoPassword,
+	}
+}
+
+// transition moves the pipeline to the next stage if the move is allowed
+// from the current one, tearing down the stage being left and setting up the
+// one being entered. Entered parameters and selections are kept, so going
+// back and forth doesn't lose them.
+func (m model) transition(to stage.Stage) (model, tea.Cmd) {
+	from := m.stage
+	if !from.CanTransitionTo(to) {
+		log.Printf("Ignoring invalid stage transition %s -> %s", from, to)
+		return m, nil
+	}
+
+	log.Printf("Stage transition %s -> %s", from, to)
+	m.leave(from)
+	m.stage = to
+	return m, m.enter(to)
+}
+
+func (m *model) leave(from stage.Stage) {
+	switch from {
+	case stage.Input:
+		m.inputsModel.SetCurrentIndex(0)
+	case stage.Files:
+		m.filesSelected = m.filesModel.SelectedPaths
+		utils.ClearDir(m.tempDir)
+	case stage.Review:
+		m.archive = m.reviewModel.Archive()
+		m.retention = m.reviewModel.Retention()
+		m.incremental = m.reviewModel.Incremental()
+		m.replication = m.reviewModel.Replication()
+		m.commands = m.reviewModel.Commands()
+		m.repository = m.reviewModel.Repository()
+		m.repoPassword = m.reviewModel.RepositoryPassword()
+		m.jobName = m.reviewModel.JobName()
+	case stage.Check:
+		m.checkModel.Cancel()
+	case stage.Create:
+		if !m.createBackupsModel.Done() {
+			m.createBackupsModel.Cancel()
+			utils.ClearDir(m.tempDir)
+			m.finishRun(context.Canceled)
+		}
+	case stage.Snapshots:
+		m.snapshotsModel.Cancel()
+	}
+}
+
+func (m *model) enter(to stage.Stage) tea.Cmd {
+	switch to {
+	case stage.Input:
+		return textinput.Blink
+	case stage.Check:
+		// A new run starts here, the output of the last one's hooks goes.
+		m.hookPane = hookpane.InitialHookPaneModel()
+		m.checkModel = checkServer.InitialCheckServerModel(m.job())
+		return m.checkModel.Init()
+	case stage.Files:
+		m.filesModel = getfiles.InitialFilesSelectorModel(m.filesSelected, m.tempDir)
+		return m.filesModel.Init()
+	case stage.Review:
+		m.reviewModel = review.InitialReviewModel(m.job(), m.jobName)
+		return m.reviewModel.Init()
+	case stage.Create:
+		// The run is recorded once the job is locked.
+		m.createBackupsModel = createbackups.InitialCreateBackupsModel(m.job())
+		return m.createBackupsModel.Init()
+	case stage.Upload:
+		// The backup goes on in the stream Create started.
+		m.uploadBackupsModel = uploadbackups.InitialUploadBackupsModel(m.job(), m.archives, m.created.Stream, m.created.Events)
+		m.created = createbackups.CreateBackupsMessage{}
+		return m.uploadBackupsModel.Init()
+	case stage.Delete:
+		log.Printf("Removing local backups in %s", m.tempDir)
+		engine.Cleanup(context.Background(), m.archives, nil)
+	case stage.Snapshots:
+		m.snapshotsModel = snapshots.InitialSnapshotsModel(m.job())
+		return m.snapshotsModel.Init()
+	case stage.History:
+		m.historyModel = historyview.InitialHistoryModel()
+		return m.historyModel.Init()
+	}
+	return nil
+}
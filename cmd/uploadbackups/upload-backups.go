@@ -2,17 +2,16 @@ package uploadbackups
 
 import (
 	"fmt"
-	"io"
 	"log"
-	"os"
 	"path/filepath"
+	"slices"
 	"strings"
-	"syscall"
+	"time"
 
-	"github.com/Chanadu/backup-tui/cmd/parameters"
+	"github.com/Chanadu/backup-tui/cmd/stream"
+	"github.com/Chanadu/backup-tui/pkg/engine"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
+	"github.com/dustin/go-humanize"
 )
 
 type UploadBackupsMessage struct {
@@ -20,180 +19,285 @@ type UploadBackupsMessage struct {
 	Errs []error
 }
 
-type UploadFileProgressMsg struct {
-	Err  error
-	Done bool
-}
-
-type UploadBackupsModel struct {
-	data        parameters.InputData
-	tempDir     string
-	files       []string
-	done        bool
-	success     bool
-	errs        []error
-	current     int // 0-based index
-	currentFile string
-}
+// cellState is how far one archive is on one destination.
+type cellState int
 
-var runningCmd *os.Process
+const (
+	cellWaiting cellState = iota
+	cellUploading
+	cellDone
+	cellFailed
+)
 
-func (m *UploadBackupsModel) KillProcess() {
-	log.Printf("KillProcess called: runningCmd=%v", runningCmd)
-	if runningCmd != nil {
-		pgid, err := syscall.Getpgid(runningCmd.Pid)
-		if err == nil {
-			log.Printf("Killing process group %d", pgid)
-			_ = syscall.Kill(-pgid, syscall.SIGKILL)
-		} else {
-			log.Printf("Killing process PID %d", runningCmd.Pid)
-			_ = runningCmd.Kill()
-		}
-	}
+type cell struct {
+	state cellState
+	sent  int64
+	size  int64
 }
 
-func (m *UploadBackupsModel) connectSSH() (*ssh.Client, error) {
-	config := &ssh.ClientConfig{
-		User: m.data.User,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(m.data.Password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * 1e9, // 5 seconds
-	}
-	return ssh.Dial("tcp", m.data.Server+":22", config)
-}
+const columnWidth = 24
 
-func uploadSingleFile(data parameters.InputData, tempDir, fileName string) error {
-	client, err := (&UploadBackupsModel{data: data}).connectSSH()
-	if err != nil {
-		return err
-	}
-	defer client.Close()
+// readWidth is the width of the column showing how much of a streamed path
+// was read.
+const readWidth = 12
 
-	sftpClient, err := sftp.NewClient(client)
-	if err != nil {
-		return fmt.Errorf("failed to start SFTP: %w", err)
-	}
-	defer sftpClient.Close()
+type UploadBackupsModel struct {
+	job      engine.Job
+	stream   *stream.Stream
+	archives []string
+	// files are the rows: the streamed paths, the archives, then the
+	// streamed command sources by name.
+	files   []string
+	dests   []string
+	done    bool
+	success bool
+	errs    []error
 
-	localPath := filepath.Join(tempDir, fileName)
-	remotePath := fileName
+	// cells[i][j] is the status of files[i] on dests[j].
+	cells [][]cell
+	// read is how much of each streamed path was read, the other end of
+	// its uploads.
+	read map[string]cell
+}
 
-	srcFile, err := os.Open(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to open local file %s: %w", localPath, err)
-	}
-	defer srcFile.Close()
+// cancelTimeout bounds how long Cancel waits for the partial remote file to be
+// removed.
+const cancelTimeout = 10 * time.Second
 
-	dstFile, err := sftpClient.Create(remotePath)
-	if err != nil {
-		return fmt.Errorf("failed to create remote file %s: %w", remotePath, err)
+// Cancel stops uploading and waits for the partial remote file to be removed.
+func (m UploadBackupsModel) Cancel() {
+	if m.stream == nil {
+		return
 	}
-	defer dstFile.Close()
-
-	_, err = io.Copy(dstFile, srcFile)
-	if err != nil {
-		return fmt.Errorf("failed to copy file %s: %w", fileName, err)
+	log.Printf("Cancelling upload")
+	if !m.stream.CancelAndWait(cancelTimeout) {
+		log.Printf("Upload didn't stop within %s", cancelTimeout)
 	}
-	return nil
+}
+
+// Done reports whether the upload finished.
+func (m UploadBackupsModel) Done() bool {
+	return m.done
 }
 
 func (m UploadBackupsModel) Init() tea.Cmd {
-	return func() tea.Msg {
-		entries, err := os.ReadDir(m.tempDir)
-		if err != nil {
-			return UploadBackupsMessage{
-				Ok:   false,
-				Errs: []error{fmt.Errorf("failed to read tempDir: %w", err)},
-			}
-		}
-		var files []string
-		for _, entry := range entries {
-			if !entry.IsDir() {
-				files = append(files, entry.Name())
-			}
-		}
-		if len(files) == 0 {
-			return UploadBackupsMessage{
-				Ok:   false,
-				Errs: []error{fmt.Errorf("no files to upload")},
-			}
-		}
-		m.files = files
-		m.current = 0
-		m.currentFile = files[0]
-		return UploadFileProgressMsg{}
-	}
+	return m.stream.Next()
 }
 
 func (m UploadBackupsModel) Update(msg tea.Msg) (UploadBackupsModel, tea.Cmd) {
 	switch msg := msg.(type) {
-	case UploadFileProgressMsg:
-		if len(m.files) == 0 {
-			entries, _ := os.ReadDir(m.tempDir)
-			for _, entry := range entries {
-				if !entry.IsDir() {
-					m.files = append(m.files, entry.Name())
-				}
-			}
+	case stream.EventMsg:
+		if msg.Stream != m.stream {
+			break
 		}
-
-		// Handle error from previous upload
+		m = m.handleEvent(msg.Event)
+		return m, m.stream.Next()
+	case stream.DoneMsg:
+		if msg.Stream != m.stream {
+			break
+		}
+		// Failures on some destinations are fine as long as the
+		// replication policy was met, which the engine decides.
 		if msg.Err != nil {
-			m.errs = append(m.errs, fmt.Errorf("file %s: %w", m.currentFile, msg.Err))
-		}
-		// If done, finish
-		if msg.Done {
-			m.done = true
-			m.success = len(m.errs) == 0
-			return m, func() tea.Msg {
-				return UploadBackupsMessage{
-					Ok:   m.success,
-					Errs: m.errs,
-				}
-			}
+			m.errs = append(m.errs, msg.Err)
 		}
-		// Upload next file
-		if m.current < len(m.files) {
-			fileName := m.files[m.current]
-			m.currentFile = fileName
-			return m, func() tea.Msg {
-				err := uploadSingleFile(m.data, m.tempDir, fileName)
-				m2 := m // copy for closure
-				m2.current++
-				done := m2.current >= len(m2.files)
-				return UploadFileProgressMsg{
-					Err:  err,
-					Done: done,
-				}
+		m.done = true
+		m.success = msg.Err == nil
+		return m, func() tea.Msg {
+			return UploadBackupsMessage{
+				Ok:   m.success,
+				Errs: m.errs,
 			}
 		}
 	case UploadBackupsMessage:
 		m.done = true
 		m.success = msg.Ok
 		m.errs = msg.Errs
-		m.current = len(m.files)
 	}
 	return m, nil
 }
 
+// handleEvent updates the cell of the archive and destination an event is
+// about.
+func (m UploadBackupsModel) handleEvent(event engine.Event) UploadBackupsModel {
+	switch event := event.(type) {
+	case engine.Progress:
+		if event.Stage == engine.StageCreate {
+			m.read[event.Item] = cell{state: cellUploading, sent: event.Done, size: event.Total}
+			break
+		}
+		if c := m.cell(event.Item, event.Destination); c != nil {
+			*c = cell{state: cellUploading, sent: event.Done, size: event.Total}
+		}
+	case engine.FileDone:
+		if event.Stage == engine.StageCreate {
+			m.read[event.Item] = cell{state: cellDone}
+			break
+		}
+		if c := m.cell(event.Item, event.Destination); c != nil {
+			c.state = cellDone
+		}
+	case engine.Error:
+		label := event.Destination
+		if event.Item != "" {
+			label = fmt.Sprintf("%s on %s", filepath.Base(event.Item), event.Destination)
+		}
+		m.errs = append(m.errs, fmt.Errorf("%s: %w", label, event.Err))
+
+		if event.Item != "" {
+			if c := m.cell(event.Item, event.Destination); c != nil {
+				c.state = cellFailed
+			}
+			break
+		}
+		if event.Stage != engine.StageUpload {
+			// Retention failing doesn't undo the uploads.
+			break
+		}
+		// The destination couldn't be opened, nothing will reach it.
+		for i := range m.files {
+			if c := m.cell(m.files[i], event.Destination); c != nil && c.state != cellDone {
+				c.state = cellFailed
+			}
+		}
+	}
+	return m
+}
+
+// cell returns the status of file on dest, or nil if either is unknown.
+func (m UploadBackupsModel) cell(file, dest string) *cell {
+	i := slices.Index(m.files, file)
+	j := slices.Index(m.dests, dest)
+	if i < 0 || j < 0 {
+		return nil
+	}
+	return &m.cells[i][j]
+}
+
+func (c cell) String() string {
+	switch c.state {
+	case cellUploading:
+		if c.size > 0 {
+			return fmt.Sprintf("%3d%% %s", c.sent*100/c.size, humanize.Bytes(uint64(c.sent))) //nolint:gosec
+		}
+		return "uploading"
+	case cellDone:
+		return "done"
+	case cellFailed:
+		return "failed"
+	}
+	return "waiting"
+}
+
+// fit pads or truncates s to exactly width columns.
+func fit(s string, width int) string {
+	if len(s) > width {
+		return s[:width-1] + "…"
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
+
 func (m UploadBackupsModel) View() string {
 	var s strings.Builder
-	s.WriteString("\nUpload Backups\n")
-	if !m.done {
-		fmt.Fprintf(&s, "Uploading file %d of %d: %s\n", m.current+1, len(m.files), m.currentFile)
-	} else if m.success {
-		s.WriteString("All files uploaded successfully!\n")
+	if m.job.Repository.Enabled {
+		s.WriteString("\nStore Snapshot\n")
+	} else {
+		s.WriteString("\nUpload Backups\n")
+	}
+	if len(m.files) == 0 {
+		s.WriteString("Nothing changed since the last backup, nothing to upload.\n")
+		return s.String()
+	}
+
+	if m.job.Repository.Enabled {
+		s.WriteString(fit("Path", columnWidth))
 	} else {
-		fmt.Fprintf(&s, "Upload finished with %d errors.\n", len(m.errs))
+		s.WriteString(fit("Archive", columnWidth))
+	}
+	if m.job.Archive.Streamed() {
+		s.WriteString(" │ " + fit("Read", readWidth))
+	}
+	for _, dest := range m.dests {
+		s.WriteString(" │ " + fit(dest, columnWidth))
+	}
+	s.WriteString("\n")
+	for i, file := range m.files {
+		s.WriteString(fit(filepath.Base(file), columnWidth))
+		if m.job.Archive.Streamed() {
+			s.WriteString(" │ " + fit(m.readView(file), readWidth))
+		}
+		for j := range m.dests {
+			s.WriteString(" │ " + fit(m.cells[i][j].String(), columnWidth))
+		}
+		s.WriteString("\n")
+	}
+
+	if m.done {
+		if m.success {
+			fmt.Fprintf(&s, "\nUploaded to %s.\n", m.job.Replication)
+		} else {
+			s.WriteString("\nUpload failed.\n")
+		}
+	}
+	for _, err := range m.errs {
+		fmt.Fprintf(&s, "  %v\n", err)
 	}
 	return s.String()
 }
 
-func InitialUploadBackupsModel(data parameters.InputData, tempDir string) UploadBackupsModel {
-	return UploadBackupsModel{
-		data:    data,
-		tempDir: tempDir,
+// readView shows how much of file was read, if it is a streamed path.
+func (m UploadBackupsModel) readView(file string) string {
+	if !m.job.Archive.Streamed() || !slices.Contains(m.job.Paths, file) {
+		return ""
+	}
+	c := m.read[file]
+	if c.state == cellUploading && c.size == 0 {
+		return "reading"
+	}
+	return c.String()
+}
+
+// InitialUploadBackupsModel follows the rest of the backup running in s once
+// Create made archives: their uploads to the job's destinations, of which
+// events were received already, then the streamed command sources. Streamed
+// jobs stream their paths after the archives, with a row for each. In
+// repository mode it follows storing a snapshot of the job's paths instead,
+// with a row for each path.
+func InitialUploadBackupsModel(job engine.Job, archives []string, s *stream.Stream, events []engine.Event) UploadBackupsModel {
+	var files []string
+	switch {
+	case job.Repository.Enabled:
+		files = slices.Clone(job.Paths)
+	case job.Archive.Streamed():
+		files = append(slices.Clone(job.Paths), archives...)
+	default:
+		files = slices.Clone(archives)
+	}
+	for _, source := range job.StreamedCommands() {
+		files = append(files, source.Name)
+	}
+
+	dests := []string{}
+	for _, dest := range job.Destinations {
+		dests = append(dests, dest.String())
+	}
+
+	cells := make([][]cell, len(files))
+	for i := range cells {
+		cells[i] = make([]cell, len(dests))
+	}
+
+	m := UploadBackupsModel{
+		job:      job,
+		stream:   s,
+		archives: archives,
+		files:    files,
+		dests:    dests,
+		cells:    cells,
+		read:     map[string]cell{},
+	}
+	for _, event := range events {
+		m = m.handleEvent(event)
 	}
+	return m
 }
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const appDirName = "backup-tui"
+
+// ConfigDir returns the directory user configuration (bookmarks, profiles,
+// jobs) is stored in, creating it if it does not exist yet.
+func ConfigDir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(base, appDirName)
+	return dir, os.MkdirAll(dir, 0o755)
+}
+
+// StateDir returns the directory persistent runtime state is stored in,
+// following $XDG_STATE_HOME and falling back to ~/.local/state.
+func StateDir() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+
+	dir := filepath.Join(base, appDirName)
+	return dir, os.MkdirAll(dir, 0o755)
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// PathSize returns the total size of the regular files at or below path.
+func PathSize(path string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
@@ -10,12 +10,16 @@ import (
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	if len(os.Getenv("DEBUG")) > 0 {
 		fmt.Println("DEBUG MODE")
 		f, err := tea.LogToFile("debug.log", "")
 		if err != nil {
 			fmt.Println("fatal:", err)
-			os.Exit(1)
+			return 1
 		}
 
 		defer func() {
@@ -25,7 +29,7 @@ func main() {
 		f, err := tea.LogToFile(fmt.Sprintf("%s.log", time.Now().Format("2006-01-02_15-04-05")), "debug: ")
 		if err != nil {
 			fmt.Println("fatal:", err)
-			os.Exit(1)
+			return 1
 		}
 
 		defer func() {
@@ -33,5 +37,23 @@ func main() {
 		}()
 	}
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			return cmd.RunHeadless(os.Args[2:])
+		case "list":
+			return cmd.RunList(os.Args[2:])
+		case "restore":
+			return cmd.RunRestore(os.Args[2:])
+		case "daemon":
+			return cmd.RunDaemon(os.Args[2:])
+		case "schedule":
+			return cmd.RunSchedule(os.Args[2:])
+		case "notify":
+			return cmd.RunNotify(os.Args[2:])
+		}
+	}
+
 	cmd.Start()
+	return 0
 }
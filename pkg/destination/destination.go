@@ -0,0 +1,160 @@
+// Package destination abstracts where backups are stored. The engine talks
+// to every backend through the Destination interface, so checking,
+// uploading, pruning and restoring work the same for each of them.
+package destination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Destination types a Config can select.
+const (
+	TypeSFTP  = "sftp"
+	TypeLocal = "local"
+	TypeS3    = "s3"
+)
+
+// Types lists the available destination types.
+var Types = []string{TypeSFTP, TypeLocal, TypeS3}
+
+// ErrNotFound is returned by Stat, Get and Delete for missing files.
+var ErrNotFound = errors.New("file not found")
+
+// FileInfo describes a file stored at a destination.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ProgressFunc is called with the number of bytes transferred so far.
+type ProgressFunc func(done int64)
+
+// Destination stores backup files under flat names relative to its root.
+type Destination interface {
+	// Stat describes the file called name. An empty name checks that the
+	// destination's root is reachable.
+	Stat(ctx context.Context, name string) (FileInfo, error)
+	// Put stores r as name. size may be -1 if unknown. A failed or
+	// cancelled Put leaves no partial file behind.
+	Put(ctx context.Context, name string, r io.Reader, size int64, progress ProgressFunc) error
+	// List returns the files in the destination's root.
+	List(ctx context.Context) ([]FileInfo, error)
+	// Delete removes the file called name.
+	Delete(ctx context.Context, name string) error
+	// Get writes the contents of name to w.
+	Get(ctx context.Context, name string, w io.Writer, progress ProgressFunc) error
+	// Close releases the connection to the destination.
+	Close() error
+	// String describes the destination for display.
+	String() string
+}
+
+// Config selects and configures a destination. Which fields are used
+// depends on Type. Password is never saved: it is the SFTP password or the
+// S3 secret key and is entered on each run.
+type Config struct {
+	Type string `json:"type"`
+
+	// SFTP
+	User   string `json:"user,omitempty"`
+	Server string `json:"server,omitempty"`
+
+	// Dir is the SFTP remote directory or the local destination path.
+	Dir string `json:"dir,omitempty"`
+
+	// S3
+	Endpoint  string `json:"endpoint,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+
+	Password string `json:"-"`
+}
+
+// NeedsPassword reports whether the destination needs a password or secret
+// to be entered before it can be opened.
+func (c Config) NeedsPassword() bool {
+	return c.Type == TypeSFTP || c.Type == TypeS3
+}
+
+func (c Config) String() string {
+	switch c.Type {
+	case TypeSFTP:
+		dir := c.Dir
+		if dir == "" {
+			dir = "~"
+		}
+		return fmt.Sprintf("sftp://%s@%s/%s", c.User, c.Server, dir)
+	case TypeLocal:
+		return "local:" + c.Dir
+	case TypeS3:
+		return fmt.Sprintf("s3://%s/%s (%s)", c.Bucket, c.Prefix, c.Endpoint)
+	}
+	return fmt.Sprintf("unknown destination %q", c.Type)
+}
+
+// Open connects to the destination described by config.
+func Open(ctx context.Context, config Config) (Destination, error) {
+	switch config.Type {
+	case TypeSFTP, "":
+		return openSFTP(ctx, config)
+	case TypeLocal:
+		return openLocal(config)
+	case TypeS3:
+		return openS3(config)
+	}
+	return nil, fmt.Errorf("unknown destination type %q", config.Type)
+}
+
+// progressReader reports how much of r has been read, and stops reading once
+// ctx is cancelled.
+type progressReader struct {
+	ctx      context.Context
+	r        io.Reader
+	done     int64
+	progress ProgressFunc
+}
+
+func newProgressReader(ctx context.Context, r io.Reader, progress ProgressFunc) *progressReader {
+	return &progressReader{ctx: ctx, r: r, progress: progress}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	if p.progress != nil && n > 0 {
+		p.progress(p.done)
+	}
+	return n, err
+}
+
+// progressWriter is the writing counterpart of progressReader.
+type progressWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	done     int64
+	progress ProgressFunc
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	if p.progress != nil && n > 0 {
+		p.progress(p.done)
+	}
+	return n, err
+}
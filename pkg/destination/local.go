@@ -0,0 +1,160 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type localDestination struct {
+	config Config
+}
+
+func openLocal(config Config) (Destination, error) {
+	if config.Dir == "" {
+		return nil, errors.New("no local destination path set")
+	}
+	return &localDestination{config: config}, nil
+}
+
+func (d *localDestination) path(name string) string {
+	return filepath.Join(d.config.Dir, name)
+}
+
+func (d *localDestination) Stat(_ context.Context, name string) (FileInfo, error) {
+	info, err := os.Stat(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return FileInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if name == "" {
+		if !info.IsDir() {
+			return FileInfo{}, fmt.Errorf("%s is not a directory", d.config.Dir)
+		}
+		if err := checkMounted(d.config.Dir); err != nil {
+			return FileInfo{}, err
+		}
+	}
+	return FileInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Put copies r into a partial file next to name and renames it into place
+// once it is synced, so a pulled drive or power cut never leaves a truncated
+// archive under the real name.
+func (d *localDestination) Put(ctx context.Context, name string, r io.Reader, size int64, progress ProgressFunc) error {
+	if size >= 0 {
+		free, err := freeSpace(d.config.Dir)
+		if err != nil {
+			return fmt.Errorf("checking free space on %s: %w", d.config.Dir, err)
+		}
+		if free >= 0 && free < size {
+			return fmt.Errorf("not enough space on %s: %s needs %d bytes, %d free", d.config.Dir, name, size, free)
+		}
+	}
+
+	dstPath := d.path(name)
+	partialPath := d.path("." + name + ".partial")
+	err := d.copyFile(ctx, partialPath, r, progress)
+	if err == nil {
+		err = os.Rename(partialPath, dstPath)
+	}
+	if err != nil {
+		if removeErr := os.Remove(partialPath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			log.Printf("Couldn't remove partial file %s: %v", partialPath, removeErr)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to copy file %s: %w", name, err)
+	}
+
+	return syncDir(d.config.Dir)
+}
+
+func (d *localDestination) copyFile(ctx context.Context, dstPath string, r io.Reader, progress ProgressFunc) error {
+	dstFile, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dstFile, newProgressReader(ctx, r, progress))
+	if err == nil {
+		err = dstFile.Sync()
+	}
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir flushes a directory so a rename in it survives a crash. Not every
+// platform can sync directories, so failures are only logged.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Sync(); err != nil {
+		log.Printf("Couldn't sync %s: %v", dir, err)
+	}
+	return nil
+}
+
+func (d *localDestination) List(_ context.Context) ([]FileInfo, error) {
+	entries, err := os.ReadDir(d.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []FileInfo{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasSuffix(entry.Name(), ".partial") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, FileInfo{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+func (d *localDestination) Delete(_ context.Context, name string) error {
+	err := os.Remove(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (d *localDestination) Get(ctx context.Context, name string, w io.Writer, progress ProgressFunc) error {
+	srcFile, err := os.Open(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	_, err = io.Copy(&progressWriter{ctx: ctx, w: w, progress: progress}, srcFile)
+	return err
+}
+
+func (d *localDestination) Close() error {
+	return nil
+}
+
+func (d *localDestination) String() string {
+	return d.config.String()
+}
@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package destination
+
+// checkMounted can't tell drives apart on this platform, so any directory
+// is accepted.
+func checkMounted(string) error {
+	return nil
+}
+
+// freeSpace can't be determined on this platform, -1 means unknown.
+func freeSpace(string) (int64, error) {
+	return -1, nil
+}
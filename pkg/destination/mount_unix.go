@@ -0,0 +1,46 @@
+//go:build linux || darwin || freebsd
+
+package destination
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkMounted returns an error if dir is not a mount point, that is on the
+// same filesystem as its parent, so a backup doesn't silently fill whatever
+// disk holds the empty mount directory when the drive isn't plugged in.
+func checkMounted(dir string) error {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return err
+	}
+
+	var dirStat, parentStat unix.Stat_t
+	if err := unix.Stat(resolved, &dirStat); err != nil {
+		return err
+	}
+	if err := unix.Stat(filepath.Dir(resolved), &parentStat); err != nil {
+		return err
+	}
+
+	if dirStat.Dev == parentStat.Dev {
+		return fmt.Errorf("%s is not a mount point, is the drive mounted?", dir)
+	}
+	return nil
+}
+
+// freeSpace returns the bytes available to unprivileged users at dir.
+func freeSpace(dir string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil //nolint:gosec,unconvert
+}
@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd
+
+package destination
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A directory on the same filesystem as its parent is refused, wherever
+// that filesystem is mounted, while a mount point is accepted.
+func TestCheckMounted(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkMounted(dir); err == nil {
+		t.Errorf("checkMounted(%s) accepted a plain directory", dir)
+	}
+	if err := checkMounted("/"); err == nil {
+		t.Error("checkMounted(/) accepted the root filesystem")
+	}
+
+	if _, err := os.Stat("/proc/self"); err != nil {
+		t.Skip("no /proc mount to check against")
+	}
+	if err := checkMounted("/proc"); err != nil {
+		t.Errorf("checkMounted(/proc): %v", err)
+	}
+	link := filepath.Join(dir, "proc")
+	if err := os.Symlink("/proc", link); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkMounted(link); err != nil {
+		t.Errorf("checkMounted(%s): %v", link, err)
+	}
+}
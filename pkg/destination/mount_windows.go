@@ -0,0 +1,42 @@
+//go:build windows
+
+package destination
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// checkMounted returns an error if dir is on the system drive rather than a
+// separate drive, so a backup doesn't silently fill the system disk when the
+// drive isn't plugged in.
+func checkMounted(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	systemDrive := os.Getenv("SystemDrive")
+	if systemDrive != "" && strings.EqualFold(filepath.VolumeName(abs), systemDrive) {
+		return fmt.Errorf("%s is on the system drive, is the drive connected?", dir)
+	}
+	return nil
+}
+
+// freeSpace returns the bytes available to the current user at dir.
+func freeSpace(dir string) (int64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return int64(available), nil //nolint:gosec
+}
@@ -0,0 +1,341 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+
+	// Archives larger than minPartSize are sent as multipart uploads. S3
+	// allows at most maxParts parts, so the part size grows for huge files.
+	minPartSize     = 16 << 20
+	unknownPartSize = 64 << 20
+	maxParts        = 10000
+)
+
+// s3Destination talks to S3 and S3-compatible servers (MinIO, Garage,
+// Backblaze B2, ...) using path-style requests signed with signature v4.
+type s3Destination struct {
+	config   Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+type s3InitiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+func openS3(config Config) (Destination, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("no S3 bucket set")
+	}
+	if config.Region == "" {
+		config.Region = defaultS3Region
+	}
+
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %w", config.Endpoint, err)
+	}
+
+	return &s3Destination{config: config, endpoint: u, client: &http.Client{}}, nil
+}
+
+func (d *s3Destination) key(name string) string {
+	if d.config.Prefix == "" {
+		return name
+	}
+	return path.Join(d.config.Prefix, name)
+}
+
+// listPrefix is the key prefix files in the destination's root share.
+func (d *s3Destination) listPrefix() string {
+	prefix := strings.Trim(d.config.Prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// do sends a signed request for key (the bucket itself if key is empty).
+func (d *s3Destination) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *d.endpoint
+	u.Path = "/" + d.config.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, true)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	signV4(req, d.config.AccessKey, d.config.Password, d.config.Region, unsignedPayload, time.Now())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound && key != "" {
+			return nil, ErrNotFound
+		}
+		return nil, d.responseError(resp)
+	}
+	return resp, nil
+}
+
+func (d *s3Destination) responseError(resp *http.Response) error {
+	var s3Err s3Error
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+		return fmt.Errorf("s3: %s: %s", s3Err.Code, s3Err.Message)
+	}
+	return fmt.Errorf("s3: %s", resp.Status)
+}
+
+func (d *s3Destination) Stat(ctx context.Context, name string) (FileInfo, error) {
+	if name == "" {
+		// The prefix is no object of its own, so the bucket is checked.
+		resp, err := d.do(ctx, http.MethodHead, "", nil, nil, 0)
+		if err != nil {
+			return FileInfo{}, err
+		}
+		resp.Body.Close()
+		return FileInfo{}, nil
+	}
+
+	resp, err := d.do(ctx, http.MethodHead, d.key(name), nil, nil, 0)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return FileInfo{Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (d *s3Destination) Put(ctx context.Context, name string, r io.Reader, size int64, progress ProgressFunc) error {
+	reader := newProgressReader(ctx, r, progress)
+	if size >= 0 && size <= minPartSize {
+		resp, err := d.do(ctx, http.MethodPut, d.key(name), nil, reader, size)
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	partSize := int64(unknownPartSize)
+	if size >= 0 {
+		partSize = max(int64(minPartSize), (size+maxParts-1)/maxParts)
+	}
+	return d.putMultipart(ctx, name, reader, partSize)
+}
+
+func (d *s3Destination) putMultipart(ctx context.Context, name string, r io.Reader, partSize int64) error {
+	key := d.key(name)
+	resp, err := d.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to start upload of %s: %w", name, err)
+	}
+	var initiate s3InitiateResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiate)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start upload of %s: %w", name, err)
+	}
+
+	err = d.uploadParts(ctx, key, initiate.UploadID, r, partSize)
+	if err != nil {
+		d.abortMultipart(key, initiate.UploadID)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to upload %s: %w", name, err)
+	}
+	return nil
+}
+
+func (d *s3Destination) uploadParts(ctx context.Context, key, uploadID string, r io.Reader, partSize int64) error {
+	complete := s3CompleteUpload{}
+	buf := make([]byte, partSize)
+
+	for partNumber := 1; ; partNumber++ {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.ErrUnexpectedEOF && readErr != io.EOF {
+			return readErr
+		}
+		// An empty file still needs one (empty) part.
+		if n == 0 && partNumber > 1 {
+			break
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+		resp, err := d.do(ctx, http.MethodPut, key, query, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, s3CompletedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// S3 can report a failed completion with a 200 status and an error body.
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var s3Err s3Error
+	if xml.Unmarshal(respBody, &s3Err) == nil && s3Err.Code != "" {
+		return fmt.Errorf("s3: %s: %s", s3Err.Code, s3Err.Message)
+	}
+	return nil
+}
+
+// abortMultipart discards the parts of a failed upload. It uses its own
+// context so it still runs after the upload was cancelled.
+func (d *s3Destination) abortMultipart(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := d.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, 0)
+	if err != nil {
+		log.Printf("Couldn't abort multipart upload of %s: %v", key, err)
+		return
+	}
+	resp.Body.Close()
+	log.Printf("Aborted multipart upload of %s", key)
+}
+
+func (d *s3Destination) List(ctx context.Context) ([]FileInfo, error) {
+	prefix := d.listPrefix()
+	files := []FileInfo{}
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "delimiter": {"/"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := d.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range result.Contents {
+			files = append(files, FileInfo{
+				Name:    strings.TrimPrefix(obj.Key, prefix),
+				Size:    obj.Size,
+				ModTime: obj.LastModified,
+			})
+		}
+
+		if !result.IsTruncated {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (d *s3Destination) Delete(ctx context.Context, name string) error {
+	resp, err := d.do(ctx, http.MethodDelete, d.key(name), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (d *s3Destination) Get(ctx context.Context, name string, w io.Writer, progress ProgressFunc) error {
+	resp, err := d.do(ctx, http.MethodGet, d.key(name), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(&progressWriter{ctx: ctx, w: w, progress: progress}, resp.Body)
+	return err
+}
+
+func (d *s3Destination) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
+func (d *s3Destination) String() string {
+	return d.config.String()
+}
@@ -0,0 +1,97 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestS3RoundTrip stores, lists, reads and deletes a file on an S3
+// compatible server such as MinIO. It only runs if BACKUP_TUI_S3_ENDPOINT
+// is set, with the credentials in BACKUP_TUI_S3_ACCESS_KEY and
+// BACKUP_TUI_S3_SECRET_KEY and an existing bucket in BACKUP_TUI_S3_BUCKET.
+func TestS3RoundTrip(t *testing.T) {
+	endpoint := os.Getenv("BACKUP_TUI_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("BACKUP_TUI_S3_ENDPOINT not set")
+	}
+	config := Config{
+		Type:      TypeS3,
+		Endpoint:  endpoint,
+		Bucket:    os.Getenv("BACKUP_TUI_S3_BUCKET"),
+		Prefix:    fmt.Sprintf("backup-tui-test-%d", time.Now().UnixNano()),
+		AccessKey: os.Getenv("BACKUP_TUI_S3_ACCESS_KEY"),
+		Password:  os.Getenv("BACKUP_TUI_S3_SECRET_KEY"),
+	}
+	ctx := context.Background()
+	dest, err := Open(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dest.Close()
+
+	if _, err := dest.Stat(ctx, ""); err != nil {
+		t.Fatalf("Stat of the prefixed root: %v", err)
+	}
+
+	const name = "docs 2026$.7z"
+	content := strings.Repeat("backup ", 1000)
+	if err := dest.Put(ctx, name, strings.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	files, err := dest.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != name || files[0].Size != int64(len(content)) {
+		t.Fatalf("List = %v, want %s of %d bytes", files, name, len(content))
+	}
+
+	var got bytes.Buffer
+	if err := dest.Get(ctx, name, &got, nil); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.String() != content {
+		t.Errorf("Get returned %d bytes, want the %d put", got.Len(), len(content))
+	}
+
+	if err := dest.Delete(ctx, name); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := dest.Stat(ctx, name); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+// Stat of the root checks the bucket, not an object named like the prefix,
+// which doesn't exist.
+func TestS3StatRootWithPrefix(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && r.URL.Path == "/backups" {
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	dest, err := Open(context.Background(), Config{Type: TypeS3, Endpoint: server.URL, Bucket: "backups", Prefix: "pi/home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dest.Close()
+
+	if _, err := dest.Stat(context.Background(), ""); err != nil {
+		t.Errorf("Stat(\"\") = %v, want nil", err)
+	}
+	if _, err := dest.Stat(context.Background(), "missing.7z"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat(missing.7z) = %v, want ErrNotFound", err)
+	}
+}
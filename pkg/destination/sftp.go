@@ -0,0 +1,181 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const sftpDialTimeout = 5 * time.Second
+
+type sftpDestination struct {
+	config Config
+	ssh    *ssh.Client
+	client *sftp.Client
+}
+
+func openSFTP(ctx context.Context, config Config) (Destination, error) {
+	sshConfig := &ssh.ClientConfig{
+		User: config.User,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(config.Password),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         sftpDialTimeout,
+	}
+
+	addr := net.JoinHostPort(config.Server, "22")
+	dialer := net.Dialer{Timeout: sftpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to server: %w", err)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("connecting to server: %w", err)
+	}
+	sshClient := ssh.NewClient(c, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, fmt.Errorf("failed to start SFTP: %w", err)
+	}
+
+	return &sftpDestination{config: config, ssh: sshClient, client: client}, nil
+}
+
+func (d *sftpDestination) path(name string) string {
+	if d.config.Dir == "" {
+		if name == "" {
+			return "."
+		}
+		return name
+	}
+	return path.Join(d.config.Dir, name)
+}
+
+func (d *sftpDestination) Stat(_ context.Context, name string) (FileInfo, error) {
+	info, err := d.client.Stat(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		if name == "" {
+			// The remote dir is created on the first upload.
+			return FileInfo{}, nil
+		}
+		return FileInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Put uploads r into a partial file next to name and renames it into place
+// once it is closed, so a dropped connection never leaves a truncated
+// archive under the real name nor removes the one already there.
+func (d *sftpDestination) Put(ctx context.Context, name string, r io.Reader, _ int64, progress ProgressFunc) error {
+	if d.config.Dir != "" {
+		if err := d.client.MkdirAll(d.config.Dir); err != nil {
+			return fmt.Errorf("failed to create remote dir %s: %w", d.config.Dir, err)
+		}
+	}
+
+	remotePath := d.path(name)
+	partialPath := d.path("." + name + ".partial")
+	dstFile, err := d.client.Create(partialPath)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file %s: %w", partialPath, err)
+	}
+
+	_, err = io.Copy(dstFile, newProgressReader(ctx, r, progress))
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = d.rename(partialPath, remotePath)
+	}
+	if err != nil {
+		if removeErr := d.client.Remove(partialPath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			log.Printf("Couldn't remove partial remote file %s: %v", partialPath, removeErr)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to copy file %s: %w", name, err)
+	}
+	return nil
+}
+
+// rename moves oldPath over newPath. Plain SFTP renames fail if newPath
+// exists, so servers without the POSIX rename extension have it removed
+// first.
+func (d *sftpDestination) rename(oldPath, newPath string) error {
+	if _, ok := d.client.HasExtension("posix-rename@openssh.com"); ok {
+		return d.client.PosixRename(oldPath, newPath)
+	}
+	if err := d.client.Remove(newPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return d.client.Rename(oldPath, newPath)
+}
+
+func (d *sftpDestination) List(_ context.Context) ([]FileInfo, error) {
+	entries, err := d.client.ReadDir(d.path(""))
+	if errors.Is(err, os.ErrNotExist) {
+		return []FileInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := []FileInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".partial") {
+			continue
+		}
+		files = append(files, FileInfo{Name: entry.Name(), Size: entry.Size(), ModTime: entry.ModTime()})
+	}
+	return files, nil
+}
+
+func (d *sftpDestination) Delete(_ context.Context, name string) error {
+	err := d.client.Remove(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (d *sftpDestination) Get(ctx context.Context, name string, w io.Writer, progress ProgressFunc) error {
+	srcFile, err := d.client.Open(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	_, err = io.Copy(&progressWriter{ctx: ctx, w: w, progress: progress}, srcFile)
+	return err
+}
+
+func (d *sftpDestination) Close() error {
+	return errors.Join(d.client.Close(), d.ssh.Close())
+}
+
+func (d *sftpDestination) String() string {
+	return d.config.String()
+}
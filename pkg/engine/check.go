@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+)
+
+// openDestination connects to config, reporting a failure as an Error event
+// of stage.
+func openDestination(ctx context.Context, config destination.Config, stage Stage, events chan<- Event) (destination.Destination, error) {
+	dest, err := destination.Open(ctx, config)
+	if err != nil {
+		send(ctx, events, Error{Stage: stage, Destination: config.String(), Err: err})
+		return nil, err
+	}
+	return dest, nil
+}
+
+// Check verifies the job's destinations can be reached. It fails if fewer
+// are reachable than the job's replication requires.
+func Check(ctx context.Context, job Job, events chan<- Event) error {
+	send(ctx, events, StageStarted{Stage: StageCheck, Total: len(job.Destinations)})
+	if len(job.Destinations) == 0 {
+		err := errors.New("no destinations set")
+		send(ctx, events, Error{Stage: StageCheck, Err: err})
+		return err
+	}
+
+	var errs []error
+	reachable := 0
+	for _, config := range job.Destinations {
+		if err := checkDestination(ctx, config, events); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		reachable++
+	}
+
+	if reachable < job.Replication.Required(len(job.Destinations)) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func checkDestination(ctx context.Context, config destination.Config, events chan<- Event) error {
+	dest, err := openDestination(ctx, config, StageCheck, events)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	if _, err := dest.Stat(ctx, ""); err != nil {
+		err = fmt.Errorf("checking %s: %w", dest, err)
+		send(ctx, events, Error{Stage: StageCheck, Destination: dest.String(), Err: err})
+		return err
+	}
+
+	send(ctx, events, FileDone{Stage: StageCheck, Destination: dest.String()})
+	return nil
+}
@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// stderrTail is how much of a failed command's stderr ends up in its error.
+const stderrTail = 1024
+
+// CommandSource is a job item backed up from the output of a command, such
+// as a database dump, instead of from files.
+type CommandSource struct {
+	// Name is the file name the output is stored as, such as "mydb.sql".
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	// Stream compresses the output with zstd and uploads it straight to
+	// the destinations instead of archiving it with 7z first.
+	Stream bool `json:"stream,omitempty"`
+}
+
+func (c CommandSource) String() string {
+	if c.Stream {
+		return fmt.Sprintf("%s: %s (streamed)", c.Name, c.Command)
+	}
+	return fmt.Sprintf("%s: %s", c.Name, c.Command)
+}
+
+// Validate checks the source can be stored under its name.
+func (c CommandSource) Validate() error {
+	if c.Name == "" || c.Name == "." || c.Name == ".." || strings.ContainsAny(c.Name, `/\`) {
+		return fmt.Errorf("invalid file name %q for command %q", c.Name, c.Command)
+	}
+	if strings.TrimSpace(c.Command) == "" {
+		return fmt.Errorf("no command for %s", c.Name)
+	}
+	return nil
+}
+
+// ArchivedCommands are the job's command sources archived by Create.
+func (j Job) ArchivedCommands() []CommandSource {
+	sources := []CommandSource{}
+	for _, source := range j.Commands {
+		if !source.Stream {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// StreamedCommands are the job's command sources uploaded by
+// StreamCommands.
+func (j Job) StreamedCommands() []CommandSource {
+	sources := []CommandSource{}
+	for _, source := range j.Commands {
+		if source.Stream {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// tailWriter keeps the last stderrTail bytes written to it.
+type tailWriter struct {
+	buf []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > stderrTail {
+		w.buf = w.buf[len(w.buf)-stderrTail:]
+	}
+	return len(p), nil
+}
+
+// commandError describes how a source's command failed, with what it
+// printed on stderr.
+func commandError(err error, stderr *tailWriter) error {
+	if msg := strings.TrimSpace(string(stderr.buf)); msg != "" {
+		return fmt.Errorf("command failed: %w: %s", err, msg)
+	}
+	return fmt.Errorf("command failed: %w", err)
+}
+
+// createFromCommand pipes the output of source's command into 7z, which
+// stores it as source.Name in a new archive called archiveName. The archive
+// is removed if either fails.
+func createFromCommand(ctx context.Context, job Job, source CommandSource, archiveName string) (string, error) {
+	if err := source.Validate(); err != nil {
+		return "", err
+	}
+	archivePath := filepath.Join(job.WorkDir, archiveName)
+	log.Printf("Creating archive for command %q at %s", source.Command, archivePath)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	defer w.Close()
+
+	args := append(append([]string{"a"}, archiveArgs(job, false)...), "-si"+source.Name, archivePath)
+	archiver := groupCommand(ctx, "7z", args...)
+	archiver.Stdin = r
+	var stderr tailWriter
+	cmd := groupCommand(ctx, "sh", "-c", source.Command)
+	cmd.Dir = job.WorkDir
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	log.Printf("Executing command: %s", strings.Join(archiver.Args, " "))
+	if err := archiver.Start(); err != nil {
+		return "", err
+	}
+	cmdErr := cmd.Start()
+	// Only the two processes hold the pipe now, so 7z sees the end of the
+	// output when the command exits.
+	r.Close()
+	w.Close()
+	if cmdErr == nil {
+		cmdErr = cmd.Wait()
+	}
+	archiveErr := archiver.Wait()
+
+	if cmdErr == nil && archiveErr == nil {
+		return archivePath, nil
+	}
+	removePartial(archivePath)
+	switch {
+	case ctx.Err() != nil:
+		return "", ctx.Err()
+	case cmdErr != nil:
+		return "", commandError(cmdErr, &stderr)
+	}
+	return "", fmt.Errorf("7z failed: %w", archiveErr)
+}
+
+// StreamCommands runs the job's streamed command sources, compressing their
+// output and uploading it to all destinations at once, without a local
+// copy. A source counts as uploaded once as many destinations as the job's
+// replication requires hold it. The uploads are named by the job's name
+// template.
+func StreamCommands(ctx context.Context, job Job, events chan<- Event) error {
+	sources := job.StreamedCommands()
+	if len(sources) == 0 {
+		return nil
+	}
+	send(ctx, events, StageStarted{Stage: StageUpload, Total: len(sources) * len(job.Destinations)})
+
+	vars := make([]nameVars, len(sources))
+	for i, source := range sources {
+		vars[i] = commandVars(job, source, extStreamZst)
+	}
+	names, nameErrs := nameArchives(ctx, job, vars, time.Now())
+
+	required := job.Replication.Required(len(job.Destinations))
+	var errs []error
+	for i, source := range sources {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		count, err := 0, nameErrs[i]
+		if err == nil {
+			count, err = streamCommand(ctx, job, source, names[i], events)
+		} else {
+			for _, config := range job.Destinations {
+				send(ctx, events, Error{Stage: StageUpload, Destination: config.String(), Item: source.Name, Err: err})
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.Name, err))
+			continue
+		}
+		if count < required {
+			errs = append(errs, fmt.Errorf("%s reached %d of %d required destinations", names[i], count, required))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// streamCommand uploads the compressed output of source's command as name to
+// each destination that can be opened, returning how many hold it
+// afterwards. If the command fails, none keep it.
+func streamCommand(ctx context.Context, job Job, source CommandSource, name string, events chan<- Event) (int, error) {
+	if err := source.Validate(); err != nil {
+		return 0, err
+	}
+	log.Printf("Streaming command %q to %s", source.Command, name)
+
+	upload, err := streamUpload(ctx, job, source.Name, name, func(w io.Writer) error {
+		zw, err := zstd.NewWriter(w, zstdOptions(job.Archive, false)...)
+		if err != nil {
+			return err
+		}
+		var stderr tailWriter
+		cmd := groupCommand(ctx, "sh", "-c", source.Command)
+		cmd.Dir = job.WorkDir
+		cmd.Stdout = zw
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			zw.Close()
+			return commandError(err, &stderr)
+		}
+		return zw.Close()
+	}, events)
+	return upload.count, err
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression methods of 7z archives.
+const (
+	MethodLZMA2 = "lzma2"
+	// MethodZstd needs a 7z built with zstd support, such as 7-Zip ZS.
+	MethodZstd  = "zstd"
+	MethodStore = "store"
+)
+
+// Methods lists the available compression methods.
+var Methods = []string{MethodLZMA2, MethodZstd, MethodStore}
+
+const (
+	// compressedShare is the share of an archive's bytes that must already
+	// be compressed for it to be stored without compression.
+	compressedShare = 0.9
+	// sampleSize is how much of a file of unknown type is read to tell
+	// whether it compresses.
+	sampleSize = 64 << 10
+	// minSampled is the size below which files aren't worth sampling.
+	minSampled = 4 << 10
+	// maxSamples bounds how many files are sampled for one archive, the
+	// others count as compressible.
+	maxSamples = 200
+)
+
+// compressedExts are extensions of formats that are compressed already.
+var compressedExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".lz4": true,
+	".7z": true, ".rar": true, ".jar": true, ".apk": true, ".deb": true, ".rpm": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".epub": true,
+}
+
+// method is the 7z compression method, MethodLZMA2 for jobs saved before
+// it could be chosen.
+func (a ArchiveSettings) method() string {
+	if a.Method == "" {
+		return MethodLZMA2
+	}
+	return a.Method
+}
+
+// CompressionString describes how archives are compressed.
+func (a ArchiveSettings) CompressionString() string {
+	method := a.method()
+	if a.Streamed() {
+		method = MethodZstd
+	}
+	if method == MethodStore {
+		return "store, no compression"
+	}
+	return fmt.Sprintf("%s level %d", method, a.Level)
+}
+
+// ThreadsString describes how many threads compress.
+func (a ArchiveSettings) ThreadsString() string {
+	switch a.Threads {
+	case 0:
+		return "all cores"
+	case 1:
+		return "1 thread"
+	}
+	return fmt.Sprintf("%d threads", a.Threads)
+}
+
+// compressionArgs are the 7z switches selecting the compression of an
+// archive, which is only stored if store is set.
+func compressionArgs(a ArchiveSettings, store bool) []string {
+	var args []string
+	switch {
+	case store || a.method() == MethodStore:
+		args = []string{"-mx=0"}
+	default:
+		args = []string{"-m0=" + a.method(), fmt.Sprintf("-mx=%d", a.Level)}
+	}
+	if a.Threads > 0 {
+		args = append(args, fmt.Sprintf("-mmt=%d", a.Threads))
+	}
+	return args
+}
+
+// zstdOptions are the encoder options of streamed archives, at the fastest
+// level if store is set, since zstd keeps incompressible data as it is
+// anyway.
+func zstdOptions(a ArchiveSettings, store bool) []zstd.EOption {
+	level := zstd.EncoderLevelFromZstd(a.Level)
+	if store {
+		level = zstd.SpeedFastest
+	}
+	options := []zstd.EOption{zstd.WithEncoderLevel(level)}
+	if a.Threads > 0 {
+		options = append(options, zstd.WithEncoderConcurrency(a.Threads))
+	}
+	return options
+}
+
+// compressionSurvey adds up how much of an archive's content is compressed
+// already.
+type compressionSurvey struct {
+	encoder    *zstd.Encoder
+	samples    int
+	total      int64
+	compressed int64
+}
+
+// add counts a file in, by its extension or else by how well a sample of
+// it compresses.
+func (s *compressionSurvey) add(path string, size int64) error {
+	s.total += size
+	if compressedExts[strings.ToLower(filepath.Ext(path))] {
+		s.compressed += size
+		return nil
+	}
+	if size < minSampled || s.samples >= maxSamples {
+		return nil
+	}
+	s.samples++
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sample, err := io.ReadAll(io.LimitReader(f, sampleSize))
+	if err != nil {
+		return err
+	}
+	if len(s.encoder.EncodeAll(sample, nil)) >= len(sample)*95/100 {
+		s.compressed += size
+	}
+	return nil
+}
+
+// storeOnly reports whether the job stores root without compression because
+// it is made mostly of already compressed files, such as photos, videos or
+// zip files. For incremental archives files are the changed ones, relative
+// to the parent of root, otherwise all of root counts.
+func storeOnly(ctx context.Context, job Job, root string, files []string) bool {
+	if !job.Archive.StoreCompressed {
+		return false
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	if err != nil {
+		return false
+	}
+	defer encoder.Close()
+	survey := compressionSurvey{encoder: encoder}
+
+	if files != nil {
+		parent := filepath.Dir(root)
+		for _, name := range files {
+			path := filepath.Join(parent, filepath.FromSlash(name))
+			info, err := os.Lstat(path)
+			if err == nil && info.Mode().IsRegular() {
+				err = survey.add(path, info.Size())
+			}
+			if err != nil {
+				log.Printf("Couldn't tell whether %s is compressed: %v", path, err)
+			}
+		}
+	} else {
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			return survey.add(path, info.Size())
+		})
+		if err != nil {
+			log.Printf("Couldn't tell whether %s is compressed: %v", root, err)
+			return false
+		}
+	}
+
+	if survey.total == 0 || float64(survey.compressed) < compressedShare*float64(survey.total) {
+		return false
+	}
+	log.Printf("Storing %s without compression, %d of %d bytes are compressed already", root, survey.compressed, survey.total)
+	return true
+}
@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// killDelay is how long a cancelled archiver gets to exit before it is
+// killed.
+const killDelay = 5 * time.Second
+
+// createItem is a path or command source to archive.
+type createItem struct {
+	// name is the path or the source's name, which events report it as.
+	name string
+	vars nameVars
+	// archive creates the archive called archiveName, or for incremental
+	// jobs the next one of the chain it names.
+	archive func(archiveName string) (string, error)
+}
+
+// createItems are what Create archives for job at now, in order.
+func createItems(ctx context.Context, job Job, now time.Time) []createItem {
+	items := []createItem{}
+	if !job.Archive.Streamed() {
+		for _, path := range job.Paths {
+			vars := pathVars(job, path)
+			if job.Incremental.Enabled {
+				// The name is the chain's key.
+				vars.ext = ""
+			}
+			items = append(items, createItem{name: path, vars: vars, archive: func(archiveName string) (string, error) {
+				if job.Incremental.Enabled {
+					return createIncremental(ctx, job, path, archiveName, now)
+				}
+				return createArchive(ctx, job, path, archiveName)
+			}})
+		}
+	}
+	for _, source := range job.ArchivedCommands() {
+		items = append(items, createItem{name: source.Name, vars: commandVars(job, source, ext7z), archive: func(archiveName string) (string, error) {
+			return createFromCommand(ctx, job, source, archiveName)
+		}})
+	}
+	return items
+}
+
+// Create archives each of the job's paths into its WorkDir, then the output
+// of its archived command sources, returning the paths of the archives that
+// were created. Up to the job's archive workers items are archived at once.
+// The archives are named by the job's name template, an item whose name
+// collides with an earlier one's fails.
+// Items that fail are reported as Error events and in the returned error,
+// the others are still archived. Incremental jobs skip paths that haven't
+// changed, sending a FileDone without a Path for them. The paths of
+// streamed jobs are left to StreamPaths.
+func Create(ctx context.Context, job Job, events chan<- Event) ([]string, error) {
+	now := time.Now()
+	return create(ctx, job, createItems(ctx, job, now), now, events, nil)
+}
+
+// create is Create for the items of job made at now, also handing each
+// archive to ready as soon as it was created, unless ready is nil.
+func create(ctx context.Context, job Job, items []createItem, now time.Time, events chan<- Event, ready chan<- string) ([]string, error) {
+	send(ctx, events, StageStarted{Stage: StageCreate, Total: len(items)})
+	if _, err := job.Archive.volumeSize(); err != nil {
+		send(ctx, events, Error{Stage: StageCreate, Err: err})
+		return nil, err
+	}
+	vars := make([]nameVars, len(items))
+	for i, item := range items {
+		vars[i] = item.vars
+	}
+	names, nameErrs := nameArchives(ctx, job, vars, now)
+
+	// results[i] and errs[i] are the archive and error of items[i], each
+	// written by the worker that archived it.
+	results := make([]string, len(items))
+	errs := make([]error, len(items))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(job.Archive.workers(), len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i], errs[i] = createOne(ctx, items[i], names[i], nameErrs[i], events)
+				if results[i] != "" && ready != nil {
+					select {
+					case ready <- results[i]:
+					case <-ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < len(items) && ctx.Err() == nil; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	archives := []string{}
+	for _, archivePath := range results {
+		if archivePath != "" {
+			archives = append(archives, archivePath)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return archives, err
+	}
+	return archives, errors.Join(errs...)
+}
+
+// createOne archives item as archiveName, unless naming it failed with
+// nameErr, reporting the result as events about it.
+func createOne(ctx context.Context, item createItem, archiveName string, nameErr error, events chan<- Event) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	send(ctx, events, Progress{Stage: StageCreate, Item: item.name})
+	archivePath, err := "", nameErr
+	if err == nil {
+		archivePath, err = item.archive(archiveName)
+	}
+	if err != nil {
+		err = fmt.Errorf("archiving %s: %w", item.name, err)
+		send(ctx, events, Error{Stage: StageCreate, Item: item.name, Err: err})
+		return "", err
+	}
+	if archivePath == "" {
+		// Nothing changed since the last incremental run.
+		send(ctx, events, FileDone{Stage: StageCreate, Item: item.name})
+		return "", nil
+	}
+	send(ctx, events, archiveDone(item.name, archivePath))
+	return archivePath, nil
+}
+
+// archiveDone reports the archive of item was created at archivePath. The
+// size and checksum of a split archive are those of its volumes put back
+// together.
+func archiveDone(item, archivePath string) FileDone {
+	var size int64
+	var checksum string
+	volumes, err := localVolumes(archivePath)
+	if err == nil {
+		size, checksum, err = hashVolumes(volumes)
+	}
+	if err != nil {
+		log.Printf("Couldn't checksum %s: %v", archivePath, err)
+	}
+	return FileDone{Stage: StageCreate, Item: item, Path: archivePath, Size: size, Checksum: checksum}
+}
+
+// archiveArgs are the 7z switches the job's archive settings ask for, only
+// storing the files if store is set. Create checked the volume size is
+// valid.
+func archiveArgs(job Job, store bool) []string {
+	args := compressionArgs(job.Archive, store)
+	if size, _ := job.Archive.volumeSize(); size > 0 {
+		// 7z writes the volumes next to the archive, as archivePath.001
+		// and on.
+		args = append(args, fmt.Sprintf("-v%db", size))
+	}
+	return args
+}
+
+func createArchive(ctx context.Context, job Job, path, archiveName string) (string, error) {
+	archivePath := filepath.Join(job.WorkDir, archiveName)
+	log.Printf("Creating archive for %s at %s", path, archivePath)
+
+	store := storeOnly(ctx, job, path, nil)
+	args := append(append([]string{"a"}, archiveArgs(job, store)...), archivePath, path)
+	if err := run7z(ctx, archivePath, "", args...); err != nil {
+		return "", err
+	}
+	return archivePath, nil
+}
+
+// groupCommand prepares name to run in a process group of its own. The
+// command may spawn helpers, so when ctx is done the whole group is asked
+// to stop, and killed if it hasn't after killDelay.
+func groupCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = killDelay
+	return cmd
+}
+
+// run7z runs 7z with args in dir, removing archivePath if it fails or is
+// cancelled.
+func run7z(ctx context.Context, archivePath, dir string, args ...string) error {
+	cmd := groupCommand(ctx, "7z", args...)
+	cmd.Dir = dir
+
+	log.Printf("Executing command: %s", strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		log.Printf("7z command failed for %s: %v", archivePath, err)
+		removePartial(archivePath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// removePartial deletes a half written local file, or the volumes of a
+// split archive, after a failed or cancelled step.
+func removePartial(path string) {
+	removeVolumes(path)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Couldn't remove partial file %s: %v", path, err)
+		return
+	}
+	log.Printf("Removed partial file %s", path)
+}
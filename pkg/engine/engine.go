@@ -0,0 +1,132 @@
+// Package engine runs backups independently of any user interface. A job is
+// checked, its paths archived and the archives uploaded, with typed events
+// reporting what happens along the way.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Run runs the whole pipeline for job: check the destination, then Backup.
+// The job's hooks run around the stages, a failing pre hook aborts the run.
+// Events are sent on events, which is closed when Run returns. A nil events
+// discards them.
+func Run(ctx context.Context, job Job, events chan<- Event) (err error) {
+	if events != nil {
+		defer close(events)
+	}
+
+	if job.WorkDir == "" {
+		job.WorkDir, err = os.MkdirTemp("", "backup-tui-*")
+		if err != nil {
+			return fmt.Errorf("creating work dir: %w", err)
+		}
+		defer os.RemoveAll(job.WorkDir)
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, RunHook(ctx, job, HookOnFailure, HookState{Err: err}, events))
+		}
+	}()
+
+	if err := RunHook(ctx, job, HookPreCheck, HookState{}, events); err != nil {
+		return err
+	}
+	if err := Check(ctx, job, events); err != nil {
+		return err
+	}
+	if err := RunHook(ctx, job, HookPreCreate, HookState{}, events); err != nil {
+		return err
+	}
+	return Backup(ctx, job, events)
+}
+
+// Backup creates the archives and uploads each as soon as it was created,
+// then removes the local copies and streams the output of streamed command
+// sources to the destinations. A StageDone for Create is sent once every
+// archive was created and the post-create hook ran. Jobs using FormatTarZst
+// stream their paths to the destinations after the archives were uploaded,
+// with the post-create hook running once that finished. In repository mode
+// a snapshot is stored instead of archives. The pre-create hook must have
+// run already.
+func Backup(ctx context.Context, job Job, events chan<- Event) error {
+	if job.Repository.Enabled {
+		send(ctx, events, StageDone{Stage: StageCreate})
+		// Storing a snapshot both creates and uploads it.
+		snapshotErr := Snapshot(ctx, job, events)
+		state := HookState{Err: snapshotErr}
+		postCreateErr := RunHook(ctx, job, HookPostCreate, state, events)
+		if snapshotErr != nil {
+			return errors.Join(snapshotErr, postCreateErr)
+		}
+		return errors.Join(postCreateErr, RunHook(ctx, job, HookPostUpload, state, events))
+	}
+
+	now := time.Now()
+	items := createItems(ctx, job, now)
+	var ready chan string
+	uploaded := make(chan error, 1)
+	if expected := len(items); expected > 0 {
+		ready = make(chan string, expected)
+		go func() {
+			uploaded <- uploadFrom(ctx, job, ready, expected, events)
+		}()
+	} else {
+		uploaded <- nil
+	}
+	archives, createErr := create(ctx, job, items, now, events, ready)
+	if ready != nil {
+		close(ready)
+	}
+	var postCreateErr error
+	if !job.Archive.Streamed() {
+		postCreateErr = RunHook(ctx, job, HookPostCreate, HookState{Archives: archives, Err: createErr}, events)
+	}
+	send(ctx, events, StageDone{Stage: StageCreate})
+	uploadErr := <-uploaded
+
+	if job.Archive.Streamed() {
+		createErr = errors.Join(createErr, StreamPaths(ctx, job, events))
+		postCreateErr = RunHook(ctx, job, HookPostCreate, HookState{Archives: archives, Err: createErr}, events)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(archives) == 0 && len(job.StreamedCommands()) == 0 && !(job.Archive.Streamed() && len(job.Paths) > 0) {
+		// An incremental run where nothing changed still succeeds.
+		return errors.Join(createErr, postCreateErr)
+	}
+
+	if len(archives) > 0 && uploadErr == nil {
+		if err := CommitManifests(job); err != nil {
+			uploadErr = fmt.Errorf("saving manifests: %w", err)
+		}
+	}
+	uploadErr = errors.Join(uploadErr, StreamCommands(ctx, job, events))
+	postUploadErr := RunHook(ctx, job, HookPostUpload, HookState{Archives: archives, Err: uploadErr}, events)
+
+	Cleanup(ctx, archives, events)
+
+	return errors.Join(createErr, uploadErr, postCreateErr, postUploadErr)
+}
+
+// Cleanup removes the local archives, with all their volumes, once they
+// have been uploaded.
+func Cleanup(ctx context.Context, archives []string, events chan<- Event) {
+	send(ctx, events, StageStarted{Stage: StageCleanup, Total: len(archives)})
+	for _, archive := range archives {
+		volumes, err := localVolumes(archive)
+		for _, volume := range volumes {
+			err = errors.Join(err, os.Remove(volume))
+		}
+		if err != nil {
+			send(ctx, events, Error{Stage: StageCleanup, Item: archive, Err: err})
+			continue
+		}
+		send(ctx, events, FileDone{Stage: StageCleanup, Item: archive})
+	}
+}
@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// Stage is a step of the backup pipeline.
+type Stage string
+
+const (
+	StageCheck   Stage = "check"
+	StageCreate  Stage = "create"
+	StageUpload  Stage = "upload"
+	StageCleanup Stage = "cleanup"
+
+	// StageRetention removes old archives from the destination after an
+	// upload.
+	StageRetention Stage = "retention"
+	// StageRestore downloads and extracts an archive.
+	StageRestore Stage = "restore"
+)
+
+// Event is something that happened while running a job. It is one of
+// StageStarted, StageDone, Progress, FileDone or Error, or for hooks
+// HookStarted, HookOutput or HookDone.
+type Event interface {
+	event()
+}
+
+// StageStarted is sent when a stage begins, Total is the number of items it
+// will work through.
+type StageStarted struct {
+	Stage Stage
+	Total int
+}
+
+// StageDone is sent when a stage ended while the pipeline goes on. Only
+// Create sends it, once every archive was created and its post-create hook
+// ran, while uploads of the archives may still be running.
+type StageDone struct {
+	Stage Stage
+}
+
+// Progress reports how far the current item of a stage is. Total is zero
+// when the size of the work isn't known. Destination names the destination
+// the item is being copied to or from, if any.
+type Progress struct {
+	Stage       Stage
+	Destination string
+	Item        string
+	Done        int64
+	Total       int64
+}
+
+// FileDone is sent when an item finished successfully. Path is where its
+// output ended up: the archive for Create, the remote file for Upload.
+// Checksum is the SHA-256 of created archives.
+type FileDone struct {
+	Stage       Stage
+	Destination string
+	Item        string
+	Path        string
+	Size        int64
+	Checksum    string
+}
+
+// Error is sent when an item of a stage failed. The stage carries on with
+// its other items unless the error is fatal for it. An Error with a
+// Destination but no Item means the whole destination failed.
+type Error struct {
+	Stage       Stage
+	Destination string
+	Item        string
+	Err         error
+}
+
+// HookStarted is sent when a hook's command starts.
+type HookStarted struct {
+	Hook    HookPoint
+	Command string
+}
+
+// HookOutput is a line a hook printed, on stdout or stderr.
+type HookOutput struct {
+	Hook HookPoint
+	Line string
+}
+
+// HookDone is sent when a hook's command exited, Err is nil if it
+// succeeded.
+type HookDone struct {
+	Hook HookPoint
+	Err  error
+}
+
+func (StageStarted) event() {}
+func (StageDone) event()    {}
+func (Progress) event()     {}
+func (FileDone) event()     {}
+func (Error) event()        {}
+func (HookStarted) event()  {}
+func (HookOutput) event()   {}
+func (HookDone) event()     {}
+
+// prefix labels an event with its stage and destination.
+func prefix(stage Stage, destination string) string {
+	if destination == "" {
+		return string(stage)
+	}
+	return fmt.Sprintf("%s [%s]", stage, destination)
+}
+
+func (e StageStarted) String() string {
+	return fmt.Sprintf("%s: started (%d items)", e.Stage, e.Total)
+}
+
+func (e StageDone) String() string {
+	return fmt.Sprintf("%s: done", e.Stage)
+}
+
+func (e Progress) String() string {
+	if e.Total > 0 {
+		return fmt.Sprintf("%s: %s %d/%d bytes", prefix(e.Stage, e.Destination), e.Item, e.Done, e.Total)
+	}
+	return fmt.Sprintf("%s: %s", prefix(e.Stage, e.Destination), e.Item)
+}
+
+func (e FileDone) String() string {
+	return fmt.Sprintf("%s: %s done -> %s (%d bytes)", prefix(e.Stage, e.Destination), e.Item, e.Path, e.Size)
+}
+
+func (e Error) String() string {
+	if e.Item == "" {
+		return fmt.Sprintf("%s: error: %v", prefix(e.Stage, e.Destination), e.Err)
+	}
+	return fmt.Sprintf("%s: %s: error: %v", prefix(e.Stage, e.Destination), e.Item, e.Err)
+}
+
+func (e HookStarted) String() string {
+	return fmt.Sprintf("hook %s: running %s", e.Hook, e.Command)
+}
+
+func (e HookOutput) String() string {
+	return fmt.Sprintf("hook %s: %s", e.Hook, e.Line)
+}
+
+func (e HookDone) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("hook %s: error: %v", e.Hook, e.Err)
+	}
+	return fmt.Sprintf("hook %s: done", e.Hook)
+}
+
+// send delivers e unless ctx is done, so a consumer that stopped reading
+// can't block the pipeline forever.
+func send(ctx context.Context, events chan<- Event, e Event) {
+	if events == nil {
+		return
+	}
+	select {
+	case events <- e:
+	case <-ctx.Done():
+	}
+}
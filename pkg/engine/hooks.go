@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// HookPoint is a point of the pipeline a job can run a command at.
+type HookPoint string
+
+const (
+	HookPreCheck   HookPoint = "pre-check"
+	HookPreCreate  HookPoint = "pre-create"
+	HookPostCreate HookPoint = "post-create"
+	HookPostUpload HookPoint = "post-upload"
+	HookOnFailure  HookPoint = "on-failure"
+)
+
+// HookPoints are all hook points, in the order they run in.
+var HookPoints = []HookPoint{HookPreCheck, HookPreCreate, HookPostCreate, HookPostUpload, HookOnFailure}
+
+// DefaultHookTimeout is how long a hook may run unless it sets a timeout.
+const DefaultHookTimeout = 10 * time.Minute
+
+// Hook is a shell command run at a point of the pipeline.
+type Hook struct {
+	Command string `json:"command,omitempty"`
+	// Timeout is a duration such as "90s" or "5m", DefaultHookTimeout if
+	// empty.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func (h Hook) timeout() (time.Duration, error) {
+	if h.Timeout == "" {
+		return DefaultHookTimeout, nil
+	}
+	timeout, err := time.ParseDuration(h.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", h.Timeout)
+	}
+	return timeout, nil
+}
+
+// Hooks are the commands a job runs around its stages. A failing pre hook
+// aborts the run, failing post hooks only fail it once it finished.
+type Hooks struct {
+	PreCheck   Hook `json:"pre_check"`
+	PreCreate  Hook `json:"pre_create"`
+	PostCreate Hook `json:"post_create"`
+	PostUpload Hook `json:"post_upload"`
+	// OnFailure runs when the run failed or was cancelled.
+	OnFailure Hook `json:"on_failure"`
+}
+
+// Get returns the hook run at point.
+func (h Hooks) Get(point HookPoint) Hook {
+	switch point {
+	case HookPreCheck:
+		return h.PreCheck
+	case HookPreCreate:
+		return h.PreCreate
+	case HookPostCreate:
+		return h.PostCreate
+	case HookPostUpload:
+		return h.PostUpload
+	case HookOnFailure:
+		return h.OnFailure
+	}
+	return Hook{}
+}
+
+// HookState is what a hook is told about the run so far.
+type HookState struct {
+	// Archives are the archives created so far.
+	Archives []string
+	// Err is how the stage before the hook ended.
+	Err error
+}
+
+// status describes Err the way history does.
+func (s HookState) status() string {
+	switch {
+	case s.Err == nil:
+		return "success"
+	case errors.Is(s.Err, context.Canceled):
+		return "cancelled"
+	}
+	return "failed"
+}
+
+// hookEnv describes the job and state to a hook, on top of the environment
+// of this process. Lists are newline separated, paths may contain any
+// other character.
+func hookEnv(job Job, point HookPoint, state HookState) []string {
+	dests := []string{}
+	for _, dest := range job.Destinations {
+		dests = append(dests, dest.String())
+	}
+	env := append(os.Environ(),
+		"BACKUP_TUI_HOOK="+string(point),
+		"BACKUP_TUI_JOB="+job.Name,
+		"BACKUP_TUI_PATHS="+strings.Join(job.Paths, "\n"),
+		"BACKUP_TUI_DESTINATIONS="+strings.Join(dests, "\n"),
+		"BACKUP_TUI_ARCHIVES="+strings.Join(state.Archives, "\n"),
+		"BACKUP_TUI_WORK_DIR="+job.WorkDir,
+		"BACKUP_TUI_STATUS="+state.status(),
+	)
+	if state.Err != nil {
+		env = append(env, "BACKUP_TUI_ERROR="+state.Err.Error())
+	}
+	return env
+}
+
+// RunHook runs the job's hook for point, if it has one, sending its output
+// as HookOutput events. Post-create and on-failure hooks run even when ctx
+// is already cancelled, so they can undo what pre hooks did, such as
+// stopping a service.
+func RunHook(ctx context.Context, job Job, point HookPoint, state HookState, events chan<- Event) error {
+	hook := job.Hooks.Get(point)
+	if hook.Command == "" {
+		return nil
+	}
+
+	send(ctx, events, HookStarted{Hook: point, Command: hook.Command})
+	err := runHook(ctx, job, point, hook, state, events)
+	if err != nil {
+		err = fmt.Errorf("%s hook: %w", point, err)
+	}
+	send(ctx, events, HookDone{Hook: point, Err: err})
+	return err
+}
+
+func runHook(ctx context.Context, job Job, point HookPoint, hook Hook, state HookState, events chan<- Event) error {
+	timeout, err := hook.timeout()
+	if err != nil {
+		return err
+	}
+	runCtx := ctx
+	if point == HookPostCreate || point == HookOnFailure {
+		runCtx = context.WithoutCancel(ctx)
+	}
+	runCtx, cancel := context.WithTimeout(runCtx, timeout)
+	defer cancel()
+
+	cmd := groupCommand(runCtx, "sh", "-c", hook.Command)
+	cmd.Env = hookEnv(job, point, state)
+	cmd.Dir = job.WorkDir
+
+	out, w := io.Pipe()
+	cmd.Stdout = w
+	cmd.Stderr = w
+	scanned := make(chan struct{})
+	go func() {
+		defer close(scanned)
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			log.Printf("%s hook: %s", point, scanner.Text())
+			send(ctx, events, HookOutput{Hook: point, Line: scanner.Text()})
+		}
+		// Keep draining so the hook never blocks on a full pipe.
+		_, _ = io.Copy(io.Discard, out)
+	}()
+
+	log.Printf("Running %s hook: %s", point, hook.Command)
+	err = cmd.Run()
+	w.Close()
+	<-scanned
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("timed out after %s", timeout)
+	case ctx.Err() != nil && runCtx.Err() != nil:
+		return ctx.Err()
+	}
+	return err
+}
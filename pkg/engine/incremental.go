@@ -0,0 +1,248 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+)
+
+// DefaultFullEvery is how many runs a chain of incremental backups has,
+// including its full archive, unless a job says otherwise.
+const DefaultFullEvery = 7
+
+// DeletedListName is the file inside an incremental archive listing the
+// files deleted since the previous archive of the chain.
+const DeletedListName = ".backup-tui-deleted"
+
+const (
+	kindFull        = "full"
+	kindIncremental = "incr"
+	chainTimeFormat = "20060102T150405Z"
+)
+
+// Incremental configures incremental backups.
+type Incremental struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// FullEvery is how many runs a chain has: a full archive is made on
+	// every FullEvery-th run, incremental ones in between. Zero uses
+	// DefaultFullEvery.
+	FullEvery int `json:"full_every,omitempty"`
+}
+
+func (i Incremental) fullEvery() int {
+	if i.FullEvery <= 0 {
+		return DefaultFullEvery
+	}
+	return i.FullEvery
+}
+
+func (i Incremental) String() string {
+	if !i.Enabled {
+		return "full every run"
+	}
+	return fmt.Sprintf("incremental, full every %d runs", i.fullEvery())
+}
+
+// chainArchiveName names a full or incremental archive made at t of the
+// chain called key.
+func chainArchiveName(key, kind string, t time.Time) string {
+	return fmt.Sprintf("%s-%s-%s%s", key, kind, t.UTC().Format(chainTimeFormat), ext7z)
+}
+
+// chainArchive describes an archive name made by chainArchiveName.
+type chainArchive struct {
+	name        string
+	key         string
+	incremental bool
+	time        time.Time
+}
+
+func parseChainArchive(name string) (chainArchive, bool) {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	rest, stamp, ok := cutLast(base, "-")
+	if !ok {
+		return chainArchive{}, false
+	}
+	key, kind, ok := cutLast(rest, "-")
+	if !ok || (kind != kindFull && kind != kindIncremental) {
+		return chainArchive{}, false
+	}
+	t, err := time.Parse(chainTimeFormat, stamp)
+	if err != nil {
+		return chainArchive{}, false
+	}
+	return chainArchive{name: name, key: key, incremental: kind == kindIncremental, time: t}, true
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// chainFor returns the archives needed to restore name, oldest first: the
+// full archive it builds on followed by the incrementals up to name.
+func chainFor(files []destination.FileInfo, name string) ([]string, error) {
+	target, ok := parseChainArchive(name)
+	if !ok {
+		return []string{name}, nil
+	}
+
+	archives := []chainArchive{}
+	for _, file := range files {
+		if a, ok := parseChainArchive(file.Name); ok && a.key == target.key && !a.time.After(target.time) {
+			archives = append(archives, a)
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].time.Before(archives[j].time) })
+
+	for i := len(archives) - 1; i >= 0; i-- {
+		if archives[i].incremental {
+			continue
+		}
+		chain := []string{}
+		for _, a := range archives[i:] {
+			chain = append(chain, a.name)
+		}
+		return chain, nil
+	}
+	return nil, fmt.Errorf("no full archive found for %s", name)
+}
+
+// latestBefore returns the newest archive of path stored in files that was
+// made at or before at.
+func latestBefore(files []destination.FileInfo, job Job, path string, at time.Time) (string, error) {
+	key := chainKey(job, path)
+	var best *chainArchive
+	for _, file := range files {
+		a, ok := parseChainArchive(file.Name)
+		if !ok || a.key != key || a.time.After(at) {
+			continue
+		}
+		if best == nil || a.time.After(best.time) {
+			best = &a
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("no archive of %s from before %s", path, at.Format(time.RFC3339))
+	}
+	return best.name, nil
+}
+
+// createIncremental archives path as part of the chain called key: in full
+// if the chain is due to restart, otherwise only what changed since the
+// last committed manifest. It returns "" if nothing changed.
+func createIncremental(ctx context.Context, job Job, path, key string, now time.Time) (string, error) {
+	if job.ManifestDir == "" {
+		return "", fmt.Errorf("incremental backups need a manifest dir")
+	}
+
+	// A pending manifest left by a run whose upload failed must not be
+	// committed by this one.
+	if err := os.Remove(manifestPath(job.ManifestDir, key, true)); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	previous, err := loadManifest(job.ManifestDir, key)
+	if err != nil {
+		return "", fmt.Errorf("reading manifest: %w", err)
+	}
+	files, err := scan(ctx, path, previous)
+	if err != nil {
+		return "", fmt.Errorf("scanning: %w", err)
+	}
+
+	manifest := Manifest{Created: now, Files: files}
+	full := previous == nil || previous.Chain+1 >= job.Incremental.fullEvery()
+
+	var archivePath string
+	if full {
+		archivePath = filepath.Join(job.WorkDir, chainArchiveName(key, kindFull, now))
+		store := storeOnly(ctx, job, path, nil)
+		args := append(append([]string{"a"}, archiveArgs(job, store)...), archivePath, path)
+		err = run7z(ctx, archivePath, "", args...)
+	} else {
+		manifest.Chain = previous.Chain + 1
+		changed, deleted := diff(previous.Files, files)
+		log.Printf("%s: %d changed, %d deleted since %s", path, len(changed), len(deleted), previous.Archive)
+		if len(changed) == 0 && len(deleted) == 0 {
+			return "", nil
+		}
+		archivePath = filepath.Join(job.WorkDir, chainArchiveName(key, kindIncremental, now))
+		err = createIncrementalArchive(ctx, job, path, key, archivePath, changed, deleted)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	manifest.Archive = filepath.Base(archivePath)
+	if err := savePendingManifest(job.ManifestDir, key, manifest); err != nil {
+		removePartial(archivePath)
+		return "", fmt.Errorf("writing manifest: %w", err)
+	}
+	return archivePath, nil
+}
+
+// createIncrementalArchive archives the changed files of path, stored the
+// same way a full archive stores them, together with the list of deleted
+// files.
+func createIncrementalArchive(ctx context.Context, job Job, path, key, archivePath string, changed, deleted []string) error {
+	listDir, err := os.MkdirTemp(job.WorkDir, key+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(listDir)
+
+	store := storeOnly(ctx, job, path, changed)
+	args := append(append([]string{"a"}, archiveArgs(job, store)...), archivePath)
+	if len(changed) > 0 {
+		listFile := filepath.Join(listDir, "changed.txt")
+		if err := writeList(listFile, changed); err != nil {
+			return err
+		}
+		args = append(args, "@"+listFile)
+	}
+	if len(deleted) > 0 {
+		deletedFile := filepath.Join(listDir, DeletedListName)
+		if err := writeList(deletedFile, deleted); err != nil {
+			return err
+		}
+		args = append(args, deletedFile)
+	}
+
+	// The list holds paths relative to the parent of path, so the archive
+	// has the same layout as a full one.
+	return run7z(ctx, archivePath, filepath.Dir(path), args...)
+}
+
+// applyDeletions removes the files an extracted incremental archive lists as
+// deleted, then the list itself.
+func applyDeletions(targetDir string) error {
+	listPath := filepath.Join(targetDir, DeletedListName)
+	data, err := os.ReadFile(listPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if name == "" || !filepath.IsLocal(filepath.FromSlash(name)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(targetDir, filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Remove(listPath)
+}
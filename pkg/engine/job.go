@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+)
+
+// Archive formats.
+const (
+	// Format7z archives are created in the WorkDir, then uploaded.
+	Format7z = "7z"
+	// FormatTarZst archives are compressed straight into the uploads as
+	// they are written, without a local copy.
+	FormatTarZst = "tar.zst"
+)
+
+// ArchiveFormats lists the available archive formats.
+var ArchiveFormats = []string{Format7z, FormatTarZst}
+
+// ArchiveSettings describe how the selected paths are archived.
+type ArchiveSettings struct {
+	Format string `json:"format"`
+	Level  int    `json:"level"`
+	// Method is the 7z compression method, one of Methods. Streamed
+	// archives are always compressed with zstd.
+	Method string `json:"method,omitempty"`
+	// Threads is how many threads compress, zero uses all cores.
+	Threads int `json:"threads,omitempty"`
+	// StoreCompressed stores paths made mostly of already compressed files,
+	// such as photos and videos, without compressing them again.
+	StoreCompressed bool `json:"store_compressed,omitempty"`
+	// Workers is how many paths are archived at once, one if zero. Each
+	// worker runs its own 7z, using Threads threads.
+	Workers int `json:"workers,omitempty"`
+	// NameTemplate names the archives, by the default of NameTemplateString
+	// if empty. See parseNameTemplate for its placeholders.
+	NameTemplate string `json:"name_template,omitempty"`
+	// VolumeSize splits archives into volumes of at most this size, such
+	// as "700MB" or "4GB", for destinations that can't take large files.
+	// Empty keeps each archive in a single file.
+	VolumeSize string `json:"volume_size,omitempty"`
+}
+
+// WorkerCounts are the worker counts offered when editing a job.
+var WorkerCounts = []int{1, 2, 4, 8}
+
+func (a ArchiveSettings) workers() int {
+	return max(a.Workers, 1)
+}
+
+// WorkersString describes how many paths are archived at once.
+func (a ArchiveSettings) WorkersString() string {
+	if a.workers() == 1 {
+		return "one path at a time"
+	}
+	return fmt.Sprintf("%d paths at once", a.workers())
+}
+
+// Streamed reports whether the paths are streamed to the destinations
+// instead of archived locally first.
+func (a ArchiveSettings) Streamed() bool {
+	return a.Format == FormatTarZst
+}
+
+// DefaultArchiveSettings are the settings backups are created with unless a
+// job says otherwise.
+func DefaultArchiveSettings() ArchiveSettings {
+	return ArchiveSettings{
+		Format:          Format7z,
+		Level:           9,
+		Method:          MethodLZMA2,
+		StoreCompressed: true,
+	}
+}
+
+// Retention decides which old archives are removed from the destination
+// after an upload.
+type Retention struct {
+	// KeepLast is how many archives of each path are kept, zero keeps all.
+	// Incremental archives are kept with the full archive they build on,
+	// so for them it counts whole chains.
+	KeepLast int `json:"keep_last,omitempty"`
+}
+
+// Replication decides how archives are copied to a job's destinations.
+type Replication struct {
+	// Quorum is how many destinations must hold an archive for it to count
+	// as uploaded, zero requires all of them.
+	Quorum int `json:"quorum,omitempty"`
+	// Concurrent uploads to all destinations at once instead of one after
+	// another.
+	Concurrent bool `json:"concurrent,omitempty"`
+}
+
+// Required returns how many of n destinations have to succeed.
+func (r Replication) Required(n int) int {
+	if r.Quorum <= 0 || r.Quorum > n {
+		return n
+	}
+	return r.Quorum
+}
+
+func (r Replication) String() string {
+	policy := "all destinations"
+	if r.Quorum > 0 {
+		policy = fmt.Sprintf("at least %d destinations", r.Quorum)
+	}
+	if r.Concurrent {
+		return policy + ", concurrently"
+	}
+	return policy + ", one after another"
+}
+
+// Job is everything needed to run one backup.
+type Job struct {
+	// Name identifies the job in events and logs, it may be empty.
+	Name string
+
+	// Destinations are where the archives are stored, each archive is
+	// copied to all of them.
+	Destinations []destination.Config
+	Replication  Replication
+
+	// Paths are the local files and directories to back up, one archive is
+	// created for each.
+	Paths []string
+	// Commands are backed up from their output, next to the paths.
+	Commands []CommandSource
+
+	Archive     ArchiveSettings
+	Retention   Retention
+	Incremental Incremental
+
+	// Repository switches the job from archives to snapshots in a chunk
+	// repository. RepositoryPassword unlocks encrypted repositories.
+	Repository         RepositorySettings
+	RepositoryPassword string
+
+	// Hooks are commands run around the stages, such as dumping a
+	// database before archiving it.
+	Hooks Hooks
+
+	// ManifestDir is where incremental backups keep the manifest of each
+	// path between runs.
+	ManifestDir string
+
+	// WorkDir is the local directory archives are created in before being
+	// uploaded.
+	WorkDir string
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+	"github.com/Chanadu/backup-tui/pkg/lock"
+)
+
+// unlockTimeout bounds removing the lock markers, which happens after the
+// run's own context may already be cancelled.
+const unlockTimeout = 30 * time.Second
+
+// LockName is the name of the marker a run of job leaves on each
+// destination while it runs.
+func LockName(job Job) string {
+	name := job.Name
+	if name == "" {
+		name = "unsaved"
+	}
+	return ".backup-tui-" + name + ".lock"
+}
+
+// isLockName reports whether name is a run's lock marker rather than a
+// backup.
+func isLockName(name string) bool {
+	return strings.HasPrefix(name, ".backup-tui-") && strings.HasSuffix(name, ".lock")
+}
+
+// LockDestinations puts the job's lock marker on each of its destinations,
+// failing with a *lock.HeldError if another run holds one. Unreachable
+// destinations are skipped, Check reports them. The returned function
+// removes the markers again.
+func LockDestinations(ctx context.Context, job Job) (func(), error) {
+	name := LockName(job)
+	locked := []destination.Config{}
+	unlock := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+		defer cancel()
+		for _, config := range locked {
+			dest, err := destination.Open(ctx, config)
+			if err == nil {
+				err = lock.ReleaseRemote(ctx, dest, name)
+				dest.Close()
+			}
+			if err != nil {
+				log.Printf("Couldn't remove lock from %s: %v", config, err)
+			}
+		}
+	}
+
+	for _, config := range job.Destinations {
+		dest, err := destination.Open(ctx, config)
+		if err != nil {
+			log.Printf("Not locking unreachable %s: %v", config, err)
+			continue
+		}
+		err = lock.AcquireRemote(ctx, dest, name)
+		dest.Close()
+
+		var held *lock.HeldError
+		if errors.As(err, &held) {
+			unlock()
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("Couldn't lock %s: %v", config, err)
+			continue
+		}
+		locked = append(locked, config)
+	}
+	return unlock, nil
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileState is what a manifest remembers about a backed up file.
+type FileState struct {
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    fs.FileMode `json:"mode"`
+	Hash    string      `json:"sha256"`
+}
+
+// Manifest records the state of every file under a path after a backup, so
+// the next run can tell what changed.
+type Manifest struct {
+	Created time.Time `json:"created"`
+	Archive string    `json:"archive"`
+	// Chain is how many incremental archives have been made since the last
+	// full one, zero for a full archive.
+	Chain int `json:"chain"`
+	// Files are keyed by their slash separated path relative to the parent
+	// of the backed up path, the way they are stored in the archive.
+	Files map[string]FileState `json:"files"`
+}
+
+// manifestPath is where the manifest of the chain called key is kept. A
+// pending manifest is written when the archive is created and only
+// replaces the committed one once the archive was uploaded.
+func manifestPath(dir, key string, pending bool) string {
+	if pending {
+		return filepath.Join(dir, key+".pending.json")
+	}
+	return filepath.Join(dir, key+".json")
+}
+
+// loadManifest reads the committed manifest of the chain called key, nil if
+// there is none yet.
+func loadManifest(dir, key string) (*Manifest, error) {
+	data, err := os.ReadFile(manifestPath(dir, key, false))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func savePendingManifest(dir, key string, m Manifest) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath(dir, key, true), data, 0o600)
+}
+
+// CommitManifests makes the manifests written by Create the base of the next
+// incremental run. Call it once the archives were uploaded, so a failed
+// upload doesn't lose changes from the next incremental archive.
+func CommitManifests(job Job) error {
+	if !job.Incremental.Enabled {
+		return nil
+	}
+
+	var errs []error
+	for _, path := range job.Paths {
+		pending := manifestPath(job.ManifestDir, chainKey(job, path), true)
+		err := os.Rename(pending, manifestPath(job.ManifestDir, chainKey(job, path), false))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// scan records the state of every file under root. Files whose size, mtime
+// and mode match previous keep their recorded hash instead of being read
+// again.
+func scan(ctx context.Context, root string, previous *Manifest) (map[string]FileState, error) {
+	parent := filepath.Dir(root)
+	files := map[string]FileState{}
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(parent, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		state := FileState{Size: info.Size(), ModTime: info.ModTime().UTC(), Mode: info.Mode()}
+		if previous != nil {
+			if old, ok := previous.Files[rel]; ok && old.Size == state.Size && old.ModTime.Equal(state.ModTime) && old.Mode == state.Mode {
+				state.Hash = old.Hash
+			}
+		}
+		if state.Hash == "" {
+			state.Hash, err = hashFile(path, info.Mode())
+			if err != nil {
+				return err
+			}
+		}
+
+		files[rel] = state
+		return nil
+	})
+	return files, err
+}
+
+// hashFile returns the SHA-256 of a regular file, or of the target of a
+// symlink.
+func hashFile(path string, mode fs.FileMode) (string, error) {
+	h := sha256.New()
+	if mode&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(target))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	if !mode.IsRegular() {
+		return "", nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diff returns the files that are new or changed in current, and those of
+// previous that were deleted, both sorted.
+func diff(previous, current map[string]FileState) (changed, deleted []string) {
+	for name, state := range current {
+		old, ok := previous[name]
+		if !ok || old.Hash != state.Hash || old.Mode != state.Mode {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(deleted)
+	return changed, deleted
+}
+
+// writeList writes names one per line, the format of 7z list files and of
+// the deletions file.
+func writeList(path string, names []string) error {
+	return os.WriteFile(path, []byte(strings.Join(names, "\n")+"\n"), 0o600)
+}
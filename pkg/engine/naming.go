@@ -0,0 +1,429 @@
+package engine
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultNameTemplate names archives after what they hold and the day they
+// were made, numbered so no run replaces the archive of an earlier one.
+const DefaultNameTemplate = "{name}-{date:2006-01-02}-{seq}"
+
+// DefaultChainTemplate names the chains of incremental jobs, whose archive
+// names add the time they were made.
+const DefaultChainTemplate = "{name}-backup"
+
+// NameTemplates are the name templates offered when editing a job, empty
+// for the default one. See NameTemplatesFor.
+var NameTemplates = []string{
+	"",
+	"{parent}-{name}-{date:2006-01-02}-{seq}",
+	"{name}-{date:2006-01-02}-{time}",
+	"{host}-{job}-{name}-{date:2006-01-02}-{seq}",
+	"{parent}-{name}-backup",
+}
+
+// NameTemplatesFor are the NameTemplates the job can use. Incremental jobs
+// can only use those without time placeholders or {seq}, other jobs only
+// those with them, as they would name each run's archives the same.
+func NameTemplatesFor(job Job) []string {
+	templates := []string{}
+	for _, template := range NameTemplates {
+		job.Archive.NameTemplate = template
+		t, err := parseNameTemplate(job.NameTemplateString())
+		if err != nil {
+			continue
+		}
+		if job.chained() != (t.has("date") || t.has("time") || t.has("seq")) {
+			templates = append(templates, template)
+		}
+	}
+	return templates
+}
+
+// Archive name extensions, appended to the rendered name template.
+const (
+	ext7z        = ".7z"
+	extTarZst    = ".tar.zst"
+	extStreamZst = ".zst"
+)
+
+// Default layouts of the time placeholders.
+const (
+	dateLayout = "2006-01-02"
+	timeLayout = "150405"
+)
+
+// namePart is a literal piece of a name template, or a placeholder if key
+// is set.
+type namePart struct {
+	literal string
+	key     string
+	layout  string
+}
+
+// nameTemplate is a parsed name template.
+type nameTemplate []namePart
+
+// nameVars are the values of the placeholders for one archive.
+type nameVars struct {
+	// item is the path or command source the archive holds, which
+	// collisions are reported for.
+	item string
+	// ext is appended to the rendered name.
+	ext    string
+	name   string
+	parent string
+	host   string
+	job    string
+	time   time.Time
+	seq    int
+}
+
+// parseNameTemplate parses a template such as "{name}-{date:2006-01-02}".
+// The placeholders are {name}, {parent}, {host}, {job}, {seq}, and {date}
+// and {time}, which take an optional Go time layout after a colon.
+func parseNameTemplate(template string) (nameTemplate, error) {
+	var t nameTemplate
+	rest := template
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t = append(t, namePart{literal: rest})
+			break
+		}
+		if open > 0 {
+			t = append(t, namePart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("name template %q: unclosed {", template)
+		}
+		key, layout, _ := strings.Cut(rest[open+1:open+end], ":")
+		switch key {
+		case "date":
+			layout = cmp.Or(layout, dateLayout)
+		case "time":
+			layout = cmp.Or(layout, timeLayout)
+		case "name", "parent", "host", "job", "seq":
+			if layout != "" {
+				return nil, fmt.Errorf("name template %q: {%s} takes no layout", template, key)
+			}
+		default:
+			return nil, fmt.Errorf("name template %q: unknown placeholder {%s}", template, rest[open+1:open+end])
+		}
+		t = append(t, namePart{key: key, layout: layout})
+		rest = rest[open+end+1:]
+	}
+	if len(t) == 0 {
+		return nil, fmt.Errorf("empty name template")
+	}
+	return t, nil
+}
+
+// nameTemplate returns the job's parsed name template. Incremental archive
+// names carry the time they were made, so their templates can't use
+// {date}, {time} or {seq}, which would start a new chain on every run.
+func (j Job) nameTemplate() (nameTemplate, error) {
+	t, err := parseNameTemplate(j.NameTemplateString())
+	if err != nil {
+		return nil, err
+	}
+	if j.chained() && (t.has("date") || t.has("time") || t.has("seq")) {
+		return nil, fmt.Errorf("incremental backups need a name template without {date}, {time} or {seq}")
+	}
+	return t, nil
+}
+
+// chained reports whether the job's archives are named as incremental
+// chains.
+func (j Job) chained() bool {
+	return j.Incremental.Enabled && !j.Archive.Streamed()
+}
+
+// NameTemplateString is the template the job's archives are named by.
+func (j Job) NameTemplateString() string {
+	if j.Archive.NameTemplate != "" {
+		return j.Archive.NameTemplate
+	}
+	if j.chained() {
+		return DefaultChainTemplate
+	}
+	return DefaultNameTemplate
+}
+
+// has reports whether the template uses the placeholder key.
+func (t nameTemplate) has(key string) bool {
+	for _, part := range t {
+		if part.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// render fills in the template's placeholders with v. Slashes in the values
+// are replaced, so the name stays a single file name.
+func (t nameTemplate) render(v nameVars) string {
+	var s strings.Builder
+	for _, part := range t {
+		switch part.key {
+		case "":
+			s.WriteString(part.literal)
+		case "name":
+			s.WriteString(nameSafe(v.name))
+		case "parent":
+			s.WriteString(nameSafe(v.parent))
+		case "host":
+			s.WriteString(nameSafe(v.host))
+		case "job":
+			s.WriteString(nameSafe(v.job))
+		case "date", "time":
+			s.WriteString(nameSafe(v.time.Format(part.layout)))
+		case "seq":
+			fmt.Fprintf(&s, "%03d", v.seq)
+		}
+	}
+	return s.String()
+}
+
+// pattern matches the names the template gives the archives of v's item on
+// any run, whatever their time and sequence number.
+func (t nameTemplate) pattern(v nameVars) string {
+	var s strings.Builder
+	for _, part := range t {
+		switch part.key {
+		case "date", "time":
+			s.WriteString(layoutPattern(part.layout))
+		case "seq":
+			s.WriteString(`\d+`)
+		default:
+			s.WriteString(regexp.QuoteMeta(nameTemplate{part}.render(v)))
+		}
+	}
+	return s.String()
+}
+
+// layoutPattern matches times formatted with layout: digits stand for any
+// digit and words for any word, such as another month's name.
+func layoutPattern(layout string) string {
+	var s strings.Builder
+	word := false
+	for _, r := range nameSafe(time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC).Format(layout)) {
+		switch {
+		case unicode.IsDigit(r):
+			s.WriteString(`\d`)
+		case unicode.IsLetter(r):
+			if !word {
+				s.WriteString(`\pL+`)
+			}
+		default:
+			s.WriteString(regexp.QuoteMeta(string(r)))
+		}
+		word = unicode.IsLetter(r)
+	}
+	return s.String()
+}
+
+func nameSafe(s string) string {
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(s)
+}
+
+// validName checks name can be stored as a file of its own.
+func validName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("invalid archive name %q", name)
+	}
+	return nil
+}
+
+// hostname is the {host} of archive names.
+func hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Printf("Couldn't get the host name: %v", err)
+		return "localhost"
+	}
+	return host
+}
+
+// pathVars are the placeholder values of the archive of path.
+func pathVars(job Job, path string) nameVars {
+	return nameVars{
+		item:   path,
+		ext:    pathArchiveExt(job),
+		name:   filepath.Base(path),
+		parent: filepath.Base(filepath.Dir(path)),
+		host:   hostname(),
+		job:    job.Name,
+	}
+}
+
+// commandVars are the placeholder values of the archive of a command
+// source with extension ext, whose {parent} is "command".
+func commandVars(job Job, source CommandSource, ext string) nameVars {
+	return nameVars{item: source.Name, ext: ext, name: source.Name, parent: "command", host: hostname(), job: job.Name}
+}
+
+// nameArchives names the archives of the items described by vars, made at
+// now. An archive may not replace one already in the WorkDir or at one of
+// the job's destinations, nor share its name with another of this run:
+// with {seq} in the job's template each name gets the number after the
+// highest one of the archives otherwise named the same, without it the
+// colliding item gets an error instead. errs[i] is set if vars[i] couldn't
+// be named. Incremental jobs are named by their chain keys, whose archive
+// names carry the time, so only keys of the same run can collide.
+func nameArchives(ctx context.Context, job Job, vars []nameVars, now time.Time) (names []string, errs []error) {
+	names = make([]string, len(vars))
+	errs = make([]error, len(vars))
+	t, err := job.nameTemplate()
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return names, errs
+	}
+
+	// taken maps the names in use to where they are used.
+	taken := existingNames(ctx, job)
+	for i, v := range vars {
+		v.time = now
+		if t.has("seq") {
+			v.seq = lastSeq(taken, t, v) + 1
+		}
+		names[i] = t.render(v) + v.ext
+		if where, ok := taken[names[i]]; ok {
+			errs[i] = fmt.Errorf("archive name %s is taken %s, add {seq} to the name template", names[i], where)
+		}
+		if errs[i] == nil {
+			errs[i] = validName(names[i])
+		}
+		if errs[i] != nil {
+			names[i] = ""
+			continue
+		}
+		taken[names[i]] = "by " + v.item
+	}
+	return names, errs
+}
+
+// lastSeq returns the highest {seq} of the names in taken that t gives the
+// archives of v, which only differ by it, zero if there is none.
+func lastSeq(taken map[string]string, t nameTemplate, v nameVars) int {
+	var s strings.Builder
+	s.WriteString("^")
+	for _, part := range t {
+		if part.key == "seq" {
+			s.WriteString(`(\d+)`)
+			continue
+		}
+		s.WriteString(regexp.QuoteMeta(nameTemplate{part}.render(v)))
+	}
+	s.WriteString(regexp.QuoteMeta(v.ext) + "$")
+	pattern := regexp.MustCompile(s.String())
+
+	last := 0
+	for name := range taken {
+		for _, match := range pattern.FindAllStringSubmatch(name, -1) {
+			for _, seq := range match[1:] {
+				if n, err := strconv.Atoi(seq); err == nil {
+					last = max(last, n)
+				}
+			}
+		}
+	}
+	return last
+}
+
+// existingNames maps the names of the archives in the WorkDir and at the
+// job's destinations, split ones by the name of the archive, to where they
+// are. Destinations that can't be listed are skipped, their upload will
+// fail anyway.
+func existingNames(ctx context.Context, job Job) map[string]string {
+	names := map[string]string{}
+	if entries, err := os.ReadDir(job.WorkDir); err == nil {
+		for _, entry := range entries {
+			names[entry.Name()] = "in the work dir"
+			if archive, _, ok := parseVolume(entry.Name()); ok {
+				names[archive] = "in the work dir"
+			}
+		}
+	}
+	for _, config := range job.Destinations {
+		files, err := List(ctx, config)
+		if err != nil {
+			log.Printf("Couldn't list %s for archive names: %v", config, err)
+			continue
+		}
+		for _, file := range files {
+			names[file.Name] = "at " + config.String()
+		}
+	}
+	return names
+}
+
+// pathArchiveExt is the extension of the archives of the job's paths.
+func pathArchiveExt(job Job) string {
+	if job.Archive.Streamed() {
+		return extTarZst
+	}
+	return ext7z
+}
+
+// chainKey is the part of the archive names of path shared by all its
+// archives, the job's name template rendered for it. Incremental
+// templates can't use time placeholders, so it is the same on every run.
+// An invalid template, which Create already reported, falls back to
+// DefaultChainTemplate.
+func chainKey(job Job, path string) string {
+	t, err := job.nameTemplate()
+	if err != nil {
+		t, _ = parseNameTemplate(DefaultChainTemplate)
+	}
+	return t.render(pathVars(job, path))
+}
+
+// archivePattern matches the names of the archives of path at a
+// destination, including the full and incremental archives of its chain.
+func archivePattern(job Job, path string) *regexp.Regexp {
+	t, err := job.nameTemplate()
+	if err != nil {
+		t, _ = parseNameTemplate(DefaultChainTemplate)
+	}
+	chain := fmt.Sprintf(`(-(%s|%s)-\d{8}T\d{6}Z)?`, kindFull, kindIncremental)
+	return regexp.MustCompile("^" + t.pattern(pathVars(job, path)) + chain + regexp.QuoteMeta(pathArchiveExt(job)) + "$")
+}
+
+// ExampleName is the name the job's template gives the archive of its first
+// path, or else of its first command source, if it was made now.
+func ExampleName(job Job) (string, error) {
+	t, err := job.nameTemplate()
+	if err != nil {
+		return "", err
+	}
+	var v nameVars
+	switch {
+	case len(job.Paths) > 0:
+		v = pathVars(job, job.Paths[0])
+	case len(job.Commands) > 0:
+		ext := ext7z
+		if job.Commands[0].Stream {
+			ext = extStreamZst
+		}
+		v = commandVars(job, job.Commands[0], ext)
+	default:
+		return "", nil
+	}
+	v.time = time.Now()
+	v.seq = 1
+	return t.render(v) + v.ext, nil
+}
@@ -0,0 +1,20 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+)
+
+// Every job is offered templates naming each run's archives apart, or for
+// incremental jobs the same so the chain continues.
+func TestNameTemplatesFor(t *testing.T) {
+	full := NameTemplatesFor(Job{})
+	if slices.Contains(full, "{parent}-{name}-backup") {
+		t.Errorf("full backups are offered a template without a date or {seq}: %q", full)
+	}
+	incremental := NameTemplatesFor(Job{Incremental: Incremental{Enabled: true}})
+	want := []string{"", "{parent}-{name}-backup"}
+	if !slices.Equal(incremental, want) {
+		t.Errorf("incremental backups are offered %q, want %q", incremental, want)
+	}
+}
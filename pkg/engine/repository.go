@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+	"github.com/Chanadu/backup-tui/pkg/repository"
+	"github.com/dustin/go-humanize"
+)
+
+// RepositorySettings configure repository mode, where instead of archives
+// each run stores a deduplicated snapshot in a chunk repository at every
+// destination.
+type RepositorySettings struct {
+	Enabled  bool `json:"enabled,omitempty"`
+	Compress bool `json:"compress,omitempty"`
+	// Encrypt only applies when a repository is created, existing ones
+	// keep the setting they were created with.
+	Encrypt bool `json:"encrypt,omitempty"`
+}
+
+func (r RepositorySettings) String() string {
+	if !r.Enabled {
+		return "archives"
+	}
+	s := "repository"
+	if r.Compress {
+		s += ", compressed"
+	}
+	if r.Encrypt {
+		s += ", encrypted"
+	}
+	return s
+}
+
+// openRepository opens the repository at config, reporting a failure as an
+// Error event of stage. The caller closes the returned destination.
+func openRepository(ctx context.Context, job Job, config destination.Config, stage Stage, events chan<- Event) (destination.Destination, *repository.Repository, error) {
+	dest, err := openDestination(ctx, config, stage, events)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, err := repository.Open(ctx, dest, repository.Options{
+		Compress: job.Repository.Compress,
+		Encrypt:  job.Repository.Encrypt,
+		Password: job.RepositoryPassword,
+	})
+	if err != nil {
+		send(ctx, events, Error{Stage: stage, Destination: dest.String(), Err: err})
+		dest.Close()
+		return nil, nil, err
+	}
+	return dest, repo, nil
+}
+
+// Snapshot stores the job's paths as a new snapshot in the repository at
+// each destination and applies the job's retention to its snapshots. Like
+// Upload it succeeds once as many destinations as the job's replication
+// requires hold the snapshot. Events are sent under StageUpload with the
+// backed up path as Item. Command sources can't be stored in a repository.
+func Snapshot(ctx context.Context, job Job, events chan<- Event) error {
+	if len(job.Commands) > 0 {
+		err := errors.New("command sources aren't supported in repository mode")
+		send(ctx, events, Error{Stage: StageUpload, Err: err})
+		return err
+	}
+	send(ctx, events, StageStarted{Stage: StageUpload, Total: len(job.Paths) * len(job.Destinations)})
+
+	stored := make([]bool, len(job.Destinations))
+	if job.Replication.Concurrent {
+		var wg sync.WaitGroup
+		for i, config := range job.Destinations {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stored[i] = snapshotTo(ctx, job, config, events) == nil
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i, config := range job.Destinations {
+			stored[i] = snapshotTo(ctx, job, config, events) == nil
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	count := 0
+	for _, ok := range stored {
+		if ok {
+			count++
+		}
+	}
+	if required := job.Replication.Required(len(job.Destinations)); count < required {
+		return fmt.Errorf("snapshot reached %d of %d required destinations", count, required)
+	}
+	return nil
+}
+
+func snapshotTo(ctx context.Context, job Job, config destination.Config, events chan<- Event) error {
+	dest, repo, err := openRepository(ctx, job, config, StageUpload, events)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	// Progress is throttled per path, like uploads are per archive.
+	var current string
+	var report destination.ProgressFunc
+	snapshot, stats, err := repo.Backup(ctx, job.Name, job.Paths, func(path string, done, total int64) {
+		if path != current {
+			current = path
+			report = progressFunc(ctx, events, StageUpload, dest.String(), path, total)
+		}
+		report(done)
+	})
+	if err != nil {
+		send(ctx, events, Error{Stage: StageUpload, Destination: dest.String(), Item: current, Err: err})
+		return err
+	}
+
+	log.Printf("Snapshot %s on %s: %d files, %s, %d new chunks (%s), %s deduplicated",
+		snapshot.ID, dest, stats.Files, humanize.Bytes(uint64(stats.Bytes)), stats.NewChunks, //nolint:gosec
+		humanize.Bytes(uint64(stats.NewBytes)), humanize.Bytes(uint64(stats.ReusedBytes))) //nolint:gosec
+	for _, path := range job.Paths {
+		send(ctx, events, FileDone{Stage: StageUpload, Destination: dest.String(), Item: path, Path: snapshot.ID})
+	}
+
+	pruneSnapshots(ctx, repo, dest, job, events)
+	return nil
+}
+
+// pruneSnapshots forgets the job's snapshots beyond its retention.
+func pruneSnapshots(ctx context.Context, repo *repository.Repository, dest destination.Destination, job Job, events chan<- Event) {
+	if job.Retention.KeepLast <= 0 {
+		return
+	}
+
+	snapshots, err := repo.Snapshots(ctx)
+	if err != nil {
+		send(ctx, events, Error{Stage: StageRetention, Destination: dest.String(), Err: err})
+		return
+	}
+	expired := repository.Expired(snapshots, job.Name, job.Retention.KeepLast)
+	if len(expired) == 0 {
+		return
+	}
+
+	send(ctx, events, StageStarted{Stage: StageRetention, Total: len(expired)})
+	log.Printf("Retention: forgetting %d snapshots on %s", len(expired), dest)
+	chunks, err := repo.Forget(ctx, expired...)
+	if err != nil {
+		send(ctx, events, Error{Stage: StageRetention, Destination: dest.String(), Err: err})
+		return
+	}
+	log.Printf("Retention: removed %d unused chunks from %s", chunks, dest)
+	for _, id := range expired {
+		send(ctx, events, FileDone{Stage: StageRetention, Destination: dest.String(), Item: id})
+	}
+}
+
+// Snapshots returns the snapshots in the repository at config, newest
+// first. A destination without a repository has none.
+func Snapshots(ctx context.Context, job Job, config destination.Config) ([]repository.Snapshot, error) {
+	dest, err := destination.Open(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer dest.Close()
+
+	exists, err := repository.Exists(ctx, dest)
+	if err != nil || !exists {
+		return []repository.Snapshot{}, err
+	}
+	repo, err := repository.Open(ctx, dest, repository.Options{Password: job.RepositoryPassword})
+	if err != nil {
+		return nil, err
+	}
+	return repo.Snapshots(ctx)
+}
+
+// RestoreSnapshot writes the files of the snapshot with the given ID from
+// the repository at config into targetDir.
+func RestoreSnapshot(ctx context.Context, job Job, config destination.Config, id, targetDir string, events chan<- Event) error {
+	send(ctx, events, StageStarted{Stage: StageRestore, Total: 1})
+	dest, repo, err := openRepository(ctx, job, config, StageRestore, events)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	fail := func(err error) error {
+		send(ctx, events, Error{Stage: StageRestore, Destination: dest.String(), Item: id, Err: err})
+		return err
+	}
+
+	snapshots, err := repo.Snapshots(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.ID != id {
+			continue
+		}
+
+		report := progressFunc(ctx, events, StageRestore, dest.String(), id, snapshot.Size())
+		if err := repo.Restore(ctx, snapshot, targetDir, func(_ string, done, _ int64) { report(done) }); err != nil {
+			return fail(err)
+		}
+		send(ctx, events, FileDone{Stage: StageRestore, Destination: dest.String(), Item: id, Path: targetDir, Size: snapshot.Size()})
+		return nil
+	}
+	return fail(errors.New("no such snapshot"))
+}
+
+// ForgetSnapshots deletes the snapshots with the given IDs from the
+// repository at config along with the chunks only they used.
+func ForgetSnapshots(ctx context.Context, job Job, config destination.Config, ids []string) error {
+	dest, repo, err := openRepository(ctx, job, config, StageRetention, nil)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	chunks, err := repo.Forget(ctx, ids...)
+	if err != nil {
+		return err
+	}
+	log.Printf("Forgot %d snapshots and %d unused chunks on %s", len(ids), chunks, dest)
+	return nil
+}
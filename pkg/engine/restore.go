@@ -0,0 +1,202 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+)
+
+// List returns the files stored at the destination described by config,
+// newest first. The volumes of a split archive are listed as one file,
+// named like the archive.
+func List(ctx context.Context, config destination.Config) ([]destination.FileInfo, error) {
+	dest, err := destination.Open(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer dest.Close()
+
+	all, err := dest.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files := []destination.FileInfo{}
+	for _, file := range groupVolumes(all) {
+		if !isLockName(file.Name) {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime.After(files[j].ModTime)
+	})
+	return files, nil
+}
+
+// findArchive opens the first of the job's destinations that holds name,
+// whole or split. The caller closes the returned destination.
+func findArchive(ctx context.Context, job Job, name string, events chan<- Event) (destination.Destination, error) {
+	var errs []error
+	for _, config := range job.Destinations {
+		dest, err := openDestination(ctx, config, StageRestore, events)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		_, _, err = remoteVolumes(ctx, dest, name)
+		if err == nil {
+			return dest, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", dest, err))
+		dest.Close()
+	}
+
+	if len(errs) == 0 {
+		return nil, errors.New("no destinations set")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Restore downloads the archive called name from the first of the job's
+// destinations that has it and extracts it into targetDir. For an
+// incremental archive the full archive it builds on and the incrementals
+// between them are restored first, rebuilding the files as they were when
+// name was made.
+func Restore(ctx context.Context, job Job, name, targetDir string, events chan<- Event) error {
+	fail := func(err error) error {
+		send(ctx, events, Error{Stage: StageRestore, Item: name, Err: err})
+		return err
+	}
+
+	dest, err := findArchive(ctx, job, name, events)
+	if err != nil {
+		send(ctx, events, StageStarted{Stage: StageRestore, Total: 1})
+		return fail(fmt.Errorf("finding %s: %w", name, err))
+	}
+	defer dest.Close()
+
+	chain := []string{name}
+	if _, ok := parseChainArchive(name); ok {
+		files, err := dest.List(ctx)
+		if err != nil {
+			return fail(err)
+		}
+		if chain, err = chainFor(groupVolumes(files), name); err != nil {
+			return fail(err)
+		}
+	}
+
+	send(ctx, events, StageStarted{Stage: StageRestore, Total: len(chain)})
+	if err := os.MkdirAll(targetDir, 0o755); err != nil {
+		return fail(err)
+	}
+	for _, archive := range chain {
+		if err := restoreArchive(ctx, job, dest, archive, targetDir, events); err != nil {
+			send(ctx, events, Error{Stage: StageRestore, Destination: dest.String(), Item: archive, Err: err})
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreAt restores path as it was at the time at, from the newest full or
+// incremental archive of it made no later than at.
+func RestoreAt(ctx context.Context, job Job, path string, at time.Time, targetDir string, events chan<- Event) error {
+	var errs []error
+	for _, config := range job.Destinations {
+		files, err := List(ctx, config)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", config, err))
+			continue
+		}
+		name, err := latestBefore(files, job, path, at)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", config, err))
+			continue
+		}
+
+		log.Printf("Restoring %s as of %s from %s", path, at.Format(time.RFC3339), name)
+		return Restore(ctx, job, name, targetDir, events)
+	}
+
+	if len(errs) == 0 {
+		return errors.New("no destinations set")
+	}
+	return errors.Join(errs...)
+}
+
+// restoreArchive downloads one archive into the WorkDir, extracts it over
+// targetDir and applies the deletions it lists. Streamed archives are
+// extracted as they download instead. Split archives are downloaded volume
+// by volume.
+func restoreArchive(ctx context.Context, job Job, dest destination.Destination, name, targetDir string, events chan<- Event) error {
+	volumes, size, err := remoteVolumes(ctx, dest, name)
+	if err != nil {
+		return fmt.Errorf("finding %s: %w", name, err)
+	}
+	progress := progressFunc(ctx, events, StageRestore, dest.String(), name, size)
+
+	if strings.HasSuffix(name, ".zst") {
+		if err := extractStream(ctx, dest, volumes, name, targetDir, progress); err != nil {
+			return err
+		}
+		send(ctx, events, FileDone{Stage: StageRestore, Destination: dest.String(), Item: name, Path: targetDir, Size: size})
+		return nil
+	}
+
+	// Removes the download whether it was split or not.
+	defer removePartial(filepath.Join(job.WorkDir, filepath.Base(name)))
+	var sent int64
+	for _, volume := range volumes {
+		n, err := download(ctx, dest, volume, job.WorkDir, offsetProgress(progress, sent))
+		if err != nil {
+			return fmt.Errorf("downloading %s: %w", volume, err)
+		}
+		sent += n
+	}
+
+	// 7z finds the other volumes next to the first.
+	localPath := filepath.Join(job.WorkDir, filepath.Base(volumes[0]))
+	cmd := exec.CommandContext(ctx, "7z", "x", "-y", "-o"+targetDir, localPath)
+	log.Printf("Executing command: %s", strings.Join(cmd.Args, " "))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("7z extract failed: %s", out)
+		return fmt.Errorf("extracting %s: %w", name, err)
+	}
+	if err := applyDeletions(targetDir); err != nil {
+		return fmt.Errorf("applying deletions of %s: %w", name, err)
+	}
+
+	send(ctx, events, FileDone{Stage: StageRestore, Destination: dest.String(), Item: name, Path: targetDir, Size: size})
+	return nil
+}
+
+// download copies the file called name from dest into dir, returning its
+// size.
+func download(ctx context.Context, dest destination.Destination, name, dir string, progress destination.ProgressFunc) (int64, error) {
+	file, err := os.Create(filepath.Join(dir, filepath.Base(name)))
+	if err != nil {
+		return 0, err
+	}
+	err = dest.Get(ctx, name, file, progress)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(file.Name())
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
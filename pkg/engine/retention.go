@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+)
+
+// archivesOf returns the files at the destination that are archives the job
+// made of path, newest first.
+func archivesOf(files []destination.FileInfo, job Job, path string) []destination.FileInfo {
+	pattern := archivePattern(job, path)
+
+	matches := []destination.FileInfo{}
+	for _, file := range files {
+		if pattern.MatchString(file.Name) {
+			matches = append(matches, file)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return archiveTime(matches[i]).After(archiveTime(matches[j]))
+	})
+	return matches
+}
+
+// archiveTime is when an archive was made: the time in its name for chain
+// archives, otherwise its modification time.
+func archiveTime(file destination.FileInfo) time.Time {
+	if a, ok := parseChainArchive(file.Name); ok {
+		return a.time
+	}
+	return file.ModTime
+}
+
+// expiredArchives returns the archives beyond the newest keep, which are
+// sorted newest first. An incremental archive is never separated from the
+// full archive it builds on.
+func expiredArchives(archives []destination.FileInfo, keep int) []string {
+	expired := []string{}
+	kept := 0
+	for _, file := range archives {
+		if kept >= keep {
+			expired = append(expired, file.Name)
+			continue
+		}
+		if a, ok := parseChainArchive(file.Name); !ok || !a.incremental {
+			kept++
+		}
+	}
+	return expired
+}
+
+// prune deletes archives beyond the job's retention, never the ones named
+// in fresh, which this run uploaded. Failures are reported but don't fail
+// the backup, the next run tries again.
+func prune(ctx context.Context, dest destination.Destination, job Job, fresh []string, events chan<- Event) {
+	if job.Retention.KeepLast <= 0 {
+		return
+	}
+
+	files, err := dest.List(ctx)
+	if err != nil {
+		send(ctx, events, Error{Stage: StageRetention, Destination: dest.String(), Err: err})
+		return
+	}
+	// A split archive is kept or removed as a whole.
+	files = groupVolumes(files)
+
+	// Paths the name template names alike, such as /a/config and
+	// /b/config, share their archives, so the newest of one path can
+	// expire another's. Those of this run are kept for every path.
+	expired := []string{}
+	for _, path := range job.Paths {
+		for _, name := range expiredArchives(archivesOf(files, job, path), job.Retention.KeepLast) {
+			if !slices.Contains(expired, name) && !slices.Contains(fresh, name) {
+				expired = append(expired, name)
+			}
+		}
+	}
+
+	if len(expired) == 0 {
+		return
+	}
+	send(ctx, events, StageStarted{Stage: StageRetention, Total: len(expired)})
+	for _, name := range expired {
+		log.Printf("Retention: removing %s from %s", name, dest)
+		if err := deleteArchive(ctx, dest, name); err != nil {
+			send(ctx, events, Error{Stage: StageRetention, Destination: dest.String(), Item: name, Err: err})
+			continue
+		}
+		send(ctx, events, FileDone{Stage: StageRetention, Destination: dest.String(), Item: name})
+	}
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+)
+
+// memDestination is a destination holding files in memory.
+type memDestination struct {
+	files map[string]destination.FileInfo
+}
+
+func newMemDestination(files ...destination.FileInfo) *memDestination {
+	d := &memDestination{files: map[string]destination.FileInfo{}}
+	for _, file := range files {
+		d.files[file.Name] = file
+	}
+	return d
+}
+
+func (d *memDestination) Stat(_ context.Context, name string) (destination.FileInfo, error) {
+	if name == "" {
+		return destination.FileInfo{}, nil
+	}
+	file, ok := d.files[name]
+	if !ok {
+		return destination.FileInfo{}, destination.ErrNotFound
+	}
+	return file, nil
+}
+
+func (d *memDestination) Put(_ context.Context, name string, r io.Reader, _ int64, _ destination.ProgressFunc) error {
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return err
+	}
+	d.files[name] = destination.FileInfo{Name: name, Size: n, ModTime: time.Now()}
+	return nil
+}
+
+func (d *memDestination) List(_ context.Context) ([]destination.FileInfo, error) {
+	files := []destination.FileInfo{}
+	for _, file := range d.files {
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func (d *memDestination) Delete(_ context.Context, name string) error {
+	if _, ok := d.files[name]; !ok {
+		return destination.ErrNotFound
+	}
+	delete(d.files, name)
+	return nil
+}
+
+func (d *memDestination) Get(_ context.Context, _ string, _ io.Writer, _ destination.ProgressFunc) error {
+	return nil
+}
+
+func (d *memDestination) Close() error {
+	return nil
+}
+
+func (d *memDestination) String() string {
+	return "mem"
+}
+
+func (d *memDestination) names() []string {
+	names := []string{}
+	for name := range d.files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Paths with the same base name share the default template's archive
+// pattern, neither may lose the archive this run made of it.
+func TestPruneSameNamedPaths(t *testing.T) {
+	job := Job{
+		Paths:     []string{"/a/config", "/b/config"},
+		Retention: Retention{KeepLast: 1},
+	}
+	day := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	dest := newMemDestination(
+		destination.FileInfo{Name: "config-2026-10-18-001.7z", ModTime: day},
+		destination.FileInfo{Name: "config-2026-10-18-002.7z", ModTime: day.Add(time.Minute)},
+		destination.FileInfo{Name: "config-2026-10-19-003.7z", ModTime: day.Add(24 * time.Hour)},
+		destination.FileInfo{Name: "config-2026-10-19-004.7z", ModTime: day.Add(24*time.Hour + time.Minute)},
+	)
+
+	prune(context.Background(), dest, job, []string{"config-2026-10-19-003.7z", "config-2026-10-19-004.7z"}, nil)
+
+	want := []string{"config-2026-10-19-003.7z", "config-2026-10-19-004.7z"}
+	if got := dest.names(); !slices.Equal(got, want) {
+		t.Errorf("after prune the destination holds %v, want %v", got, want)
+	}
+}
+
+// Each of the same-named paths gets an archive name of its own.
+func TestNameArchivesSameNamedPaths(t *testing.T) {
+	job := Job{Paths: []string{"/a/config", "/b/config"}, WorkDir: t.TempDir()}
+	vars := []nameVars{pathVars(job, job.Paths[0]), pathVars(job, job.Paths[1])}
+	now := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+
+	names, errs := nameArchives(context.Background(), job, vars, now)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("naming %s: %v", job.Paths[i], err)
+		}
+	}
+	want := []string{"config-2026-10-19-001.7z", "config-2026-10-19-002.7z"}
+	if !slices.Equal(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+}
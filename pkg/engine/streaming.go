@@ -0,0 +1,618 @@
+package engine
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+	"github.com/klauspost/compress/zstd"
+)
+
+// fanout writes a file to uploads to several destinations at once, hashing
+// what it writes and splitting it into volumes if the job asks for it. A
+// destination whose upload failed is dropped, writing only fails once all
+// of them were.
+type fanout struct {
+	ctx        context.Context
+	dests      []destination.Destination
+	name       string
+	volumeSize int64
+	progress   []destination.ProgressFunc
+
+	// failed[i] is why dests[i] was dropped, stored[i] the volumes it holds
+	// so far.
+	failed []error
+	stored [][]string
+
+	// The volume being written, numbered from 1, with an upload to each
+	// destination left. writers is nil between volumes.
+	volume   int
+	writers  []*io.PipeWriter
+	putErrs  []error
+	inVolume int64
+	wg       sync.WaitGroup
+
+	written int64
+	hash    hash.Hash
+}
+
+// volumeName is the file name the current volume is stored as.
+func (f *fanout) volumeName() string {
+	if f.volumeSize == 0 {
+		return f.name
+	}
+	return VolumeName(f.name, f.volume)
+}
+
+// open starts uploading the next volume.
+func (f *fanout) open() {
+	f.volume++
+	f.inVolume = 0
+	f.writers = make([]*io.PipeWriter, len(f.dests))
+	f.putErrs = make([]error, len(f.dests))
+	name := f.volumeName()
+	for i, dest := range f.dests {
+		if f.failed[i] != nil {
+			continue
+		}
+		r, w := io.Pipe()
+		f.writers[i] = w
+		progress := offsetProgress(f.progress[i], f.written)
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.putErrs[i] = dest.Put(f.ctx, name, r, -1, progress)
+			// Stops writes to an upload that gave up early.
+			r.CloseWithError(f.putErrs[i])
+		}()
+	}
+}
+
+// finish ends the current volume, or aborts its uploads with err, and
+// verifies what was stored.
+func (f *fanout) finish(err error) {
+	if f.writers == nil {
+		return
+	}
+	for _, w := range f.writers {
+		if w == nil {
+			continue
+		}
+		if err != nil {
+			// The uploads see the error and remove what they stored.
+			w.CloseWithError(err)
+		} else {
+			w.Close()
+		}
+	}
+	f.wg.Wait()
+
+	name := f.volumeName()
+	for i, w := range f.writers {
+		if w == nil || err != nil {
+			continue
+		}
+		putErr := f.putErrs[i]
+		if putErr == nil {
+			putErr = verify(f.ctx, f.dests[i], name, f.inVolume)
+		}
+		if putErr != nil {
+			f.failed[i] = putErr
+			continue
+		}
+		f.stored[i] = append(f.stored[i], name)
+	}
+	f.writers = nil
+}
+
+func (f *fanout) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if f.writers == nil {
+			f.open()
+		}
+		chunk := p
+		if f.volumeSize > 0 && int64(len(chunk)) > f.volumeSize-f.inVolume {
+			chunk = chunk[:f.volumeSize-f.inVolume]
+		}
+
+		alive := 0
+		for i, w := range f.writers {
+			if w == nil || f.failed[i] != nil {
+				continue
+			}
+			if _, err := w.Write(chunk); err != nil {
+				f.failed[i] = err
+				continue
+			}
+			alive++
+		}
+		if alive == 0 {
+			return written, errors.New("all uploads failed")
+		}
+		f.hash.Write(chunk)
+		f.written += int64(len(chunk))
+		f.inVolume += int64(len(chunk))
+		written += len(chunk)
+		p = p[len(chunk):]
+
+		if f.volumeSize > 0 && f.inVolume == f.volumeSize {
+			f.finish(nil)
+		}
+	}
+	return written, nil
+}
+
+// remove deletes the volumes dests[i] stored, after its upload or the
+// file as a whole failed.
+func (f *fanout) remove(i int) {
+	for _, name := range f.stored[i] {
+		if err := f.dests[i].Delete(context.WithoutCancel(f.ctx), name); err != nil {
+			log.Printf("Couldn't remove %s from %s: %v", name, f.dests[i], err)
+		}
+	}
+	f.stored[i] = nil
+}
+
+// streamed is what streamUpload stored.
+type streamed struct {
+	// count is how many destinations hold it.
+	count    int
+	size     int64
+	checksum string
+}
+
+// streamUpload uploads what write writes as name to each of the job's
+// destinations that can be opened at once, reporting it as item. It is
+// split into volumes named by VolumeName if the job sets a volume size.
+// Nothing is kept on any destination if write fails, nor on a destination
+// that failed to store one of the volumes.
+func streamUpload(ctx context.Context, job Job, item, name string, write func(io.Writer) error, events chan<- Event) (streamed, error) {
+	volumeSize, err := job.Archive.volumeSize()
+	if err != nil {
+		return streamed{}, err
+	}
+	dests := []destination.Destination{}
+	for _, config := range job.Destinations {
+		dest, err := openDestination(ctx, config, StageUpload, events)
+		if err != nil {
+			continue
+		}
+		defer dest.Close()
+		dests = append(dests, dest)
+	}
+	if len(dests) == 0 {
+		return streamed{}, errors.New("no destination could be opened")
+	}
+
+	out := &fanout{
+		ctx:        ctx,
+		dests:      dests,
+		name:       name,
+		volumeSize: volumeSize,
+		failed:     make([]error, len(dests)),
+		stored:     make([][]string, len(dests)),
+		hash:       sha256.New(),
+	}
+	for _, dest := range dests {
+		out.progress = append(out.progress, progressFunc(ctx, events, StageUpload, dest.String(), item, 0))
+	}
+
+	err = write(out)
+	if err == nil && out.volume == 0 {
+		// Nothing was written, which is still stored as an empty file.
+		out.open()
+	}
+	out.finish(err)
+
+	// When every upload failed, writing failed because of them, and their
+	// errors are reported below instead.
+	uploadsFailed := !slices.ContainsFunc(out.failed, func(err error) bool { return err == nil })
+	if ctx.Err() != nil || (err != nil && !uploadsFailed) {
+		for i := range dests {
+			out.remove(i)
+		}
+		if ctx.Err() != nil {
+			return streamed{}, ctx.Err()
+		}
+		for _, dest := range dests {
+			send(ctx, events, Error{Stage: StageUpload, Destination: dest.String(), Item: item, Err: err})
+		}
+		return streamed{}, err
+	}
+
+	result := streamed{size: out.written, checksum: hex.EncodeToString(out.hash.Sum(nil))}
+	for i, dest := range dests {
+		if out.failed[i] != nil {
+			out.remove(i)
+			send(ctx, events, Error{Stage: StageUpload, Destination: dest.String(), Item: item, Err: out.failed[i]})
+			continue
+		}
+		split := 0
+		if volumeSize > 0 {
+			split = out.volume
+		}
+		removeStale(ctx, dest, name, split)
+		result.count++
+		send(ctx, events, FileDone{Stage: StageUpload, Destination: dest.String(), Item: item, Path: name, Size: out.written})
+	}
+	return result, nil
+}
+
+// StreamPaths archives each of the job's paths as tar+zstd straight into
+// uploads to all destinations at once, for jobs using FormatTarZst. No
+// local copy is made, so it needs no free disk space. Reading the files is
+// reported as Create progress, sending them as Upload progress, and each
+// finished archive with a Create FileDone carrying its checksum. A path
+// counts as uploaded once as many destinations as the job's replication
+// requires hold it. The archives are named by the job's name template.
+func StreamPaths(ctx context.Context, job Job, events chan<- Event) error {
+	if !job.Archive.Streamed() || len(job.Paths) == 0 {
+		return nil
+	}
+	send(ctx, events, StageStarted{Stage: StageUpload, Total: len(job.Paths) * len(job.Destinations)})
+	if job.Incremental.Enabled {
+		err := fmt.Errorf("incremental backups need the %s format", Format7z)
+		send(ctx, events, Error{Stage: StageUpload, Err: err})
+		return err
+	}
+
+	vars := make([]nameVars, len(job.Paths))
+	for i, path := range job.Paths {
+		vars[i] = pathVars(job, path)
+	}
+	names, nameErrs := nameArchives(ctx, job, vars, time.Now())
+
+	required := job.Replication.Required(len(job.Destinations))
+	var errs []error
+	for i, path := range job.Paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name, err := names[i], nameErrs[i]
+		if err != nil {
+			for _, config := range job.Destinations {
+				send(ctx, events, Error{Stage: StageUpload, Destination: config.String(), Item: path, Err: err})
+			}
+			errs = append(errs, fmt.Errorf("archiving %s: %w", path, err))
+			continue
+		}
+		result, err := streamPath(ctx, job, path, name, events)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("archiving %s: %w", path, err))
+			continue
+		}
+		send(ctx, events, FileDone{Stage: StageCreate, Item: path, Path: name, Size: result.size, Checksum: result.checksum})
+		if result.count < required {
+			errs = append(errs, fmt.Errorf("%s reached %d of %d required destinations", name, result.count, required))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+func streamPath(ctx context.Context, job Job, path, name string, events chan<- Event) (streamed, error) {
+	total, err := treeSize(path)
+	if err != nil {
+		return streamed{}, err
+	}
+	log.Printf("Streaming %s (%d bytes) to %s", path, total, name)
+	store := storeOnly(ctx, job, path, nil)
+
+	return streamUpload(ctx, job, path, name, func(w io.Writer) error {
+		zw, err := zstd.NewWriter(w, zstdOptions(job.Archive, store)...)
+		if err != nil {
+			return err
+		}
+		progress := progressFunc(ctx, events, StageCreate, "", path, total)
+		if err := writeTar(ctx, path, zw, progress); err != nil {
+			zw.Close()
+			return err
+		}
+		return zw.Close()
+	}, events)
+}
+
+// treeSize adds up the size of the regular files under root.
+func treeSize(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// readCounter reports how much has been read from all the readers it
+// wrapped so far.
+type readCounter struct {
+	r        io.Reader
+	done     *int64
+	progress destination.ProgressFunc
+}
+
+func (c readCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		*c.done += int64(n)
+		c.progress(*c.done)
+	}
+	return n, err
+}
+
+// zeroReader reads an endless run of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// writeTar writes root and everything under it to w as a tar archive,
+// stored relative to the parent of root the way 7z stores it. Special files
+// such as sockets are skipped.
+func writeTar(ctx context.Context, root string, w io.Writer, progress destination.ProgressFunc) error {
+	tw := tar.NewWriter(w)
+	parent := filepath.Dir(root)
+	var done int64
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		mode := info.Mode()
+		if !mode.IsRegular() && !mode.IsDir() && mode&fs.ModeSymlink == 0 {
+			log.Printf("Skipping special file %s", path)
+			return nil
+		}
+
+		var link string
+		if mode&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(parent, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if mode.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !mode.IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// A file that grew since it was stat'ed is cut at its header's size,
+		// one that shrank is padded with zeros to it.
+		n, err := io.Copy(tw, io.LimitReader(readCounter{r: f, done: &done, progress: progress}, header.Size))
+		if err != nil {
+			return err
+		}
+		if n < header.Size {
+			log.Printf("%s shrank while being read, padding it from %d to %d bytes", path, n, header.Size)
+			_, err = io.CopyN(tw, zeroReader{}, header.Size-n)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extractStream decompresses a streamed archive called name, or streamed
+// command output, into targetDir as it downloads, without a local copy. A
+// split archive is downloaded from its volumes one after another.
+func extractStream(ctx context.Context, dest destination.Destination, volumes []string, name, targetDir string, progress destination.ProgressFunc) error {
+	r, w := io.Pipe()
+	got := make(chan error, 1)
+	go func() {
+		var err error
+		var sent int64
+		for _, volume := range volumes {
+			counter := &writeCounter{w: w}
+			if err = dest.Get(ctx, volume, counter, offsetProgress(progress, sent)); err != nil {
+				break
+			}
+			sent += counter.n
+		}
+		w.CloseWithError(err)
+		got <- err
+	}()
+
+	err := decompress(r, name, targetDir)
+	if err == nil {
+		// Padding after the end of the archive is still being sent.
+		_, err = io.Copy(io.Discard, r)
+	}
+	r.CloseWithError(err)
+	if getErr := <-got; getErr != nil {
+		return fmt.Errorf("downloading %s: %w", name, getErr)
+	}
+	if err != nil {
+		return fmt.Errorf("extracting %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeCounter counts what is written through it.
+type writeCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *writeCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func decompress(r io.Reader, name, targetDir string) error {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	if strings.HasSuffix(name, extTarZst) {
+		return extractTar(zr, targetDir)
+	}
+	// Command output is a single file.
+	f, err := os.Create(filepath.Join(targetDir, outputName(name)))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, zr); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// extractTar extracts a tar archive written by writeTar into targetDir.
+// Nothing is written outside of it: entries below a symlink and symlinks
+// pointing out of targetDir are rejected.
+func extractTar(r io.Reader, targetDir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(strings.TrimSuffix(header.Name, "/"))
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("unsafe path %q in archive", header.Name)
+		}
+		if err := checkParents(targetDir, name); err != nil {
+			return err
+		}
+		target := filepath.Join(targetDir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+
+		mode := header.FileInfo().Mode()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode.Perm()|0o700); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			link := filepath.FromSlash(header.Linkname)
+			if filepath.IsAbs(link) || !filepath.IsLocal(filepath.Join(filepath.Dir(name), link)) {
+				return fmt.Errorf("unsafe symlink %q to %q in archive", header.Name, header.Linkname)
+			}
+			if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			// Replaces a symlink rather than writing through it.
+			if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+			if err := extractFile(tr, target, mode.Perm()); err != nil {
+				return err
+			}
+			if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkParents checks that none of the directories below targetDir that
+// hold name is a symlink, which could lead the entry out of targetDir.
+func checkParents(targetDir, name string) error {
+	dir := targetDir
+	for _, part := range strings.Split(filepath.Dir(name), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("unsafe path %q in archive: %s is a symlink", name, dir)
+		}
+	}
+	return nil
+}
+
+// outputName is the file the streamed command output called name is
+// restored as: the name without its extension, and without the suffix of
+// DefaultChainTemplate, which leaves the source's name.
+func outputName(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, extStreamZst), "-backup")
+}
+
+func extractFile(r io.Reader, target string, perm fs.FileMode) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
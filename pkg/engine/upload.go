@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+)
+
+const progressInterval = 200 * time.Millisecond
+
+// progressFunc sends Progress events for item as a transfer advances, at most
+// every progressInterval.
+func progressFunc(ctx context.Context, events chan<- Event, stage Stage, dest, item string, total int64) destination.ProgressFunc {
+	var last time.Time
+	return func(done int64) {
+		if time.Since(last) >= progressInterval || done == total {
+			last = time.Now()
+			send(ctx, events, Progress{Stage: stage, Destination: dest, Item: item, Done: done, Total: total})
+		}
+	}
+}
+
+// Upload copies archives to each of the job's destinations, verifies them
+// and applies the job's retention. An archive counts as uploaded once as
+// many destinations as the job's replication requires hold it, failures on
+// the other destinations are only reported as events.
+func Upload(ctx context.Context, job Job, archives []string, events chan<- Event) error {
+	ready := make(chan string, len(archives))
+	for _, archive := range archives {
+		ready <- archive
+	}
+	close(ready)
+	return uploadFrom(ctx, job, ready, len(archives), events)
+}
+
+// uploadFrom is Upload for archives handed over on ready as they are
+// created, until it is closed. At most expected archives arrive.
+func uploadFrom(ctx context.Context, job Job, ready <-chan string, expected int, events chan<- Event) error {
+	send(ctx, events, StageStarted{Stage: StageUpload, Total: expected * len(job.Destinations)})
+
+	// Uploads to different destinations take turns unless they are
+	// concurrent.
+	var turn *sync.Mutex
+	if !job.Replication.Concurrent {
+		turn = &sync.Mutex{}
+	}
+
+	var mu sync.Mutex
+	// reached counts the destinations each archive reached.
+	reached := map[string]int{}
+	queues := make([]chan string, len(job.Destinations))
+	var wg sync.WaitGroup
+	for i, config := range job.Destinations {
+		// Never full, so a slow destination doesn't hold up the others.
+		queues[i] = make(chan string, expected)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uploadTo(ctx, job, config, queues[i], turn, events, func(archive string) {
+				mu.Lock()
+				defer mu.Unlock()
+				reached[archive]++
+			})
+		}()
+	}
+
+	archives := []string{}
+	for archive := range ready {
+		archives = append(archives, archive)
+		for _, queue := range queues {
+			queue <- archive
+		}
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	required := job.Replication.Required(len(job.Destinations))
+	var errs []error
+	for _, archive := range archives {
+		if count := reached[archive]; count < required {
+			errs = append(errs, fmt.Errorf("%s reached %d of %d required destinations", filepath.Base(archive), count, required))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// uploadTo copies the archives arriving on queue to one destination, taking
+// turn for each if it isn't nil, and calls uploaded for each that
+// succeeded. Old archives are only pruned if every upload succeeded. The
+// queue is drained even when the destination can't be opened.
+func uploadTo(ctx context.Context, job Job, config destination.Config, queue <-chan string, turn *sync.Mutex, events chan<- Event, uploaded func(archive string)) {
+	dest, err := openDestination(ctx, config, StageUpload, events)
+	if err != nil {
+		for range queue {
+		}
+		return
+	}
+	defer dest.Close()
+
+	failed := false
+	stored := []string{}
+	for archive := range queue {
+		if ctx.Err() != nil {
+			continue
+		}
+
+		if turn != nil {
+			turn.Lock()
+		}
+		name, size, err := uploadFile(ctx, dest, archive, events)
+		if turn != nil {
+			turn.Unlock()
+		}
+		if err != nil {
+			failed = true
+			send(ctx, events, Error{Stage: StageUpload, Destination: dest.String(), Item: archive, Err: err})
+			continue
+		}
+		uploaded(archive)
+		stored = append(stored, name)
+		send(ctx, events, FileDone{Stage: StageUpload, Destination: dest.String(), Item: archive, Path: name, Size: size})
+	}
+
+	if !failed && ctx.Err() == nil {
+		prune(ctx, dest, job, stored, events)
+	}
+}
+
+// uploadFile copies a local archive to dest, volume by volume if it was
+// split, returning its name and total size. If a volume fails, those
+// already uploaded are removed again so no incomplete set is left behind.
+func uploadFile(ctx context.Context, dest destination.Destination, localPath string, events chan<- Event) (string, int64, error) {
+	volumes, err := localVolumes(localPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to stat local file %s: %w", localPath, err)
+	}
+	sizes := make([]int64, len(volumes))
+	var total int64
+	for i, volume := range volumes {
+		info, err := os.Stat(volume)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to stat local file %s: %w", volume, err)
+		}
+		sizes[i] = info.Size()
+		total += info.Size()
+	}
+
+	progress := progressFunc(ctx, events, StageUpload, dest.String(), localPath, total)
+	var sent int64
+	uploaded := []string{}
+	for i, volume := range volumes {
+		err := uploadVolume(ctx, dest, volume, sizes[i], offsetProgress(progress, sent))
+		if err != nil {
+			for _, name := range uploaded {
+				if err := dest.Delete(context.WithoutCancel(ctx), name); err != nil {
+					log.Printf("Couldn't remove volume %s: %v", name, err)
+				}
+			}
+			return "", 0, err
+		}
+		uploaded = append(uploaded, filepath.Base(volume))
+		sent += sizes[i]
+	}
+
+	split := 0
+	if volumes[0] != localPath {
+		split = len(volumes)
+	}
+	removeStale(ctx, dest, filepath.Base(localPath), split)
+	return filepath.Base(localPath), total, nil
+}
+
+// uploadVolume copies one local file of an archive to dest and verifies it.
+func uploadVolume(ctx context.Context, dest destination.Destination, localPath string, size int64, progress destination.ProgressFunc) error {
+	name := filepath.Base(localPath)
+
+	srcFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file %s: %w", localPath, err)
+	}
+	defer srcFile.Close()
+
+	if err := dest.Put(ctx, name, srcFile, size, progress); err != nil {
+		return err
+	}
+	return verify(ctx, dest, name, size)
+}
+
+// verify checks the uploaded file has the expected size, deleting it if it
+// doesn't so a truncated archive isn't mistaken for a good one.
+func verify(ctx context.Context, dest destination.Destination, name string, size int64) error {
+	info, err := dest.Stat(ctx, name)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", name, err)
+	}
+	if info.Size == size {
+		return nil
+	}
+
+	if err := dest.Delete(ctx, name); err != nil {
+		log.Printf("Couldn't remove bad upload %s: %v", name, err)
+	}
+	return fmt.Errorf("verifying %s: uploaded %d bytes, expected %d", name, info.Size, size)
+}
@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+	"github.com/dustin/go-humanize"
+)
+
+// VolumeSizes are the volume sizes offered when editing a job, none first.
+// 4GB fits FAT32, whose files must stay under 4GiB.
+var VolumeSizes = []string{"", "100MB", "700MB", "1GB", "4GB"}
+
+// volumeSize returns the size archives are split at, zero if they aren't.
+func (a ArchiveSettings) volumeSize() (int64, error) {
+	if a.VolumeSize == "" {
+		return 0, nil
+	}
+	size, err := humanize.ParseBytes(a.VolumeSize)
+	if err != nil || size == 0 || size > 1<<62 {
+		return 0, fmt.Errorf("invalid volume size %q", a.VolumeSize)
+	}
+	return int64(size), nil
+}
+
+// VolumeName is the file name of the nth volume, counted from 1, of the
+// archive called name when it is split.
+func VolumeName(name string, n int) string {
+	return fmt.Sprintf("%s.%03d", name, n)
+}
+
+// parseVolume splits a volume's file name into the name of its archive and
+// its number.
+func parseVolume(name string) (string, int, bool) {
+	archive, number, ok := cutLast(name, ".")
+	if !ok || len(number) < 3 || strings.Trim(number, "0123456789") != "" {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		return "", 0, false
+	}
+	return archive, n, true
+}
+
+// groupVolumes merges the volumes of each split archive in files into a
+// single entry named like the unsplit archive, with their total size and
+// the newest modification time. Other files are kept as they are.
+func groupVolumes(files []destination.FileInfo) []destination.FileInfo {
+	grouped := []destination.FileInfo{}
+	sets := map[string]int{}
+	for _, file := range files {
+		archive, _, ok := parseVolume(file.Name)
+		if !ok {
+			grouped = append(grouped, file)
+			continue
+		}
+		i, ok := sets[archive]
+		if !ok {
+			i = len(grouped)
+			sets[archive] = i
+			grouped = append(grouped, destination.FileInfo{Name: archive})
+		}
+		grouped[i].Size += file.Size
+		if file.ModTime.After(grouped[i].ModTime) {
+			grouped[i].ModTime = file.ModTime
+		}
+	}
+	return grouped
+}
+
+// localVolumes returns the files a local archive is stored in: the archive
+// itself, or its volumes in order if it was split.
+func localVolumes(archivePath string) ([]string, error) {
+	if _, err := os.Stat(archivePath); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return []string{archivePath}, err
+	}
+	volumes := []string{}
+	for n := 1; ; n++ {
+		volume := VolumeName(archivePath, n)
+		if _, err := os.Stat(volume); err != nil {
+			break
+		}
+		volumes = append(volumes, volume)
+	}
+	if len(volumes) == 0 {
+		return nil, fmt.Errorf("%s: %w", archivePath, fs.ErrNotExist)
+	}
+	return volumes, nil
+}
+
+// hashVolumes returns the size and SHA-256 of the archive stored in
+// volumes, which is that of the volumes put back together.
+func hashVolumes(volumes []string) (int64, string, error) {
+	h := sha256.New()
+	var size int64
+	for _, volume := range volumes {
+		f, err := os.Open(volume)
+		if err != nil {
+			return 0, "", err
+		}
+		n, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return 0, "", err
+		}
+		size += n
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// remoteVolumes returns the files the archive called name is stored in at
+// dest, with their total size: the archive itself, or its volumes in order
+// if it was split.
+func remoteVolumes(ctx context.Context, dest destination.Destination, name string) ([]string, int64, error) {
+	info, err := dest.Stat(ctx, name)
+	if err == nil {
+		return []string{name}, info.Size, nil
+	}
+
+	volumes := []string{}
+	var size int64
+	for n := 1; ; n++ {
+		info, statErr := dest.Stat(ctx, VolumeName(name, n))
+		if statErr != nil {
+			break
+		}
+		volumes = append(volumes, VolumeName(name, n))
+		size += info.Size
+	}
+	if len(volumes) == 0 {
+		return nil, 0, err
+	}
+	return volumes, size, nil
+}
+
+// deleteArchive removes the archive called name from dest, with all its
+// volumes if it was split.
+func deleteArchive(ctx context.Context, dest destination.Destination, name string) error {
+	volumes, _, err := remoteVolumes(ctx, dest, name)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, volume := range volumes {
+		if err := dest.Delete(ctx, volume); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// offsetProgress reports progress through one volume as progress through the
+// whole archive, of which done bytes were sent before it.
+func offsetProgress(progress destination.ProgressFunc, done int64) destination.ProgressFunc {
+	return func(n int64) {
+		progress(done + n)
+	}
+}
+
+// removeVolumes deletes the local volumes of a split archive.
+func removeVolumes(archivePath string) {
+	for n := 1; ; n++ {
+		err := os.Remove(VolumeName(archivePath, n))
+		if errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			log.Printf("Couldn't remove volume %s: %v", VolumeName(archivePath, n), err)
+		}
+	}
+}
+
+// removeStale deletes what earlier uploads of the archive called name left
+// at dest that the one just made in volumes files didn't overwrite: the
+// unsplit archive, or volumes beyond its last one. Zero volumes means it
+// wasn't split.
+func removeStale(ctx context.Context, dest destination.Destination, name string, volumes int) {
+	stale := []string{}
+	if volumes > 0 {
+		if _, err := dest.Stat(ctx, name); err == nil {
+			stale = append(stale, name)
+		}
+	}
+	for n := volumes + 1; ; n++ {
+		if _, err := dest.Stat(ctx, VolumeName(name, n)); err != nil {
+			break
+		}
+		stale = append(stale, VolumeName(name, n))
+	}
+	for _, file := range stale {
+		log.Printf("Removing stale %s from %s", file, dest)
+		if err := dest.Delete(ctx, file); err != nil {
+			log.Printf("Couldn't remove stale %s: %v", file, err)
+		}
+	}
+}
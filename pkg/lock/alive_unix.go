@@ -0,0 +1,19 @@
+//go:build unix
+
+package lock
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// alive reports whether a process with pid exists.
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := unix.Kill(pid, 0)
+	// EPERM means it exists but belongs to someone else.
+	return err == nil || errors.Is(err, unix.EPERM)
+}
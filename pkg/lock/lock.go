@@ -0,0 +1,214 @@
+// Package lock keeps two runs of the same job from overlapping: a lock
+// file on the local machine holding the owner's PID, and a marker file on
+// each destination for runs on other machines.
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+)
+
+// StaleAfter is how old a remote marker has to be before it is assumed to
+// be left over from a run that died, when its owner can't be checked.
+const StaleAfter = 24 * time.Hour
+
+// unreadableGrace is how old a lock file that can't be parsed has to be
+// before it is assumed to be left over from a crash.
+const unreadableGrace = time.Minute
+
+// Holder describes the process holding a lock.
+type Holder struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+// Current describes this process.
+func Current() Holder {
+	host, _ := os.Hostname()
+	return Holder{PID: os.Getpid(), Host: host, Started: time.Now()}
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("PID %d on %s since %s", h.PID, h.Host, h.Started.Local().Format("2006-01-02 15:04:05"))
+}
+
+// Stale reports whether the lock h holds is left over from a run that
+// died: its process is gone, or for other hosts, it is older than
+// StaleAfter.
+func (h Holder) Stale() bool {
+	host, _ := os.Hostname()
+	if h.Host == host {
+		return !alive(h.PID)
+	}
+	return time.Since(h.Started) > StaleAfter
+}
+
+// HeldError is returned when someone else holds a lock.
+type HeldError struct {
+	// Where is the lock file or destination holding the lock.
+	Where  string
+	Holder Holder
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("locked by %s (%s)", e.Holder, e.Where)
+}
+
+// File is a held local lock.
+type File struct {
+	path string
+}
+
+// Acquire creates the lock file at path. A lock file left by a process
+// that no longer runs is replaced, one held by a live process fails with a
+// *HeldError. The holder is written to a temporary file first and linked
+// into place, so the lock file never exists half written.
+func Acquire(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(Current())
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A second attempt is made after removing a stale lock.
+	for range 2 {
+		err := os.Link(tmp.Name(), path)
+		if err == nil {
+			return &File{path: path}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		holder, held, live, err := readHolder(path)
+		if err != nil {
+			return nil, err
+		}
+		if live {
+			return nil, &HeldError{Where: path, Holder: holder}
+		}
+		if err := removeStale(path, held); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("couldn't take lock %s", path)
+}
+
+// readHolder reads the lock file at path, returning its holder and
+// contents and whether the holder still runs. A lock file that can't be
+// parsed counts as held until it is older than unreadableGrace, as its
+// owner may still be writing it.
+func readHolder(path string) (Holder, []byte, bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Released in the meantime, so it is retried.
+		return Holder{}, nil, false, nil
+	}
+	if err != nil {
+		return Holder{}, nil, false, err
+	}
+	var holder Holder
+	if err := json.Unmarshal(data, &holder); err != nil {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return Holder{}, nil, false, nil
+		}
+		if err != nil {
+			return Holder{}, nil, false, err
+		}
+		holder = Holder{Host: "unknown host", Started: info.ModTime()}
+		return holder, data, time.Since(info.ModTime()) < unreadableGrace, nil
+	}
+	return holder, data, !holder.Stale(), nil
+}
+
+// removeStale removes the stale lock file at path, which held data. It is
+// moved aside first and checked, so a lock another process took in the
+// meantime is put back rather than removed.
+func removeStale(path string, data []byte) error {
+	aside := fmt.Sprintf("%s.stale-%d", path, os.Getpid())
+	if err := os.Rename(path, aside); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer os.Remove(aside)
+
+	moved, err := os.ReadFile(aside)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(moved, data) {
+		if err := os.Link(aside, path); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release removes the lock file.
+func (f *File) Release() error {
+	err := os.Remove(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// AcquireRemote writes the marker called name to dest, unless a live run
+// holds it, which fails with a *HeldError. Destinations can't create files
+// atomically, so two runs starting at the same moment can both succeed.
+func AcquireRemote(ctx context.Context, dest destination.Destination, name string) error {
+	var buf bytes.Buffer
+	err := dest.Get(ctx, name, &buf, nil)
+	switch {
+	case errors.Is(err, destination.ErrNotFound):
+	case err != nil:
+		return fmt.Errorf("reading lock on %s: %w", dest, err)
+	default:
+		var holder Holder
+		if json.Unmarshal(buf.Bytes(), &holder) == nil && !holder.Stale() {
+			return &HeldError{Where: dest.String(), Holder: holder}
+		}
+	}
+
+	data, err := json.Marshal(Current())
+	if err != nil {
+		return err
+	}
+	return dest.Put(ctx, name, bytes.NewReader(data), int64(len(data)), nil)
+}
+
+// ReleaseRemote removes the marker called name from dest.
+func ReleaseRemote(ctx context.Context, dest destination.Destination, name string) error {
+	err := dest.Delete(ctx, name)
+	if errors.Is(err, destination.ErrNotFound) {
+		return nil
+	}
+	return err
+}
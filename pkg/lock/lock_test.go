@@ -0,0 +1,107 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeLock(t *testing.T, path string, data []byte, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func holderJSON(t *testing.T, holder Holder) []byte {
+	t.Helper()
+	data, err := json.Marshal(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAcquire(t *testing.T) {
+	host, _ := os.Hostname()
+	tests := []struct {
+		name string
+		// data is the lock file already there, none if nil.
+		data []byte
+		age  time.Duration
+		held bool
+	}{
+		{name: "free"},
+		{name: "live holder", data: holderJSON(t, Current()), held: true},
+		{name: "dead holder", data: holderJSON(t, Holder{PID: -1, Host: host, Started: time.Now()})},
+		{name: "being written", data: []byte{}, held: true},
+		{name: "unreadable", data: []byte("{"), age: time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "job.lock")
+			if test.data != nil {
+				writeLock(t, path, test.data, test.age)
+			}
+
+			file, err := Acquire(path)
+			var held *HeldError
+			if test.held {
+				if !errors.As(err, &held) {
+					t.Fatalf("Acquire = %v, want a *HeldError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Acquire: %v", err)
+			}
+			if _, err := Acquire(path); !errors.As(err, &held) {
+				t.Errorf("second Acquire = %v, want a *HeldError", err)
+			}
+			if err := file.Release(); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := os.ReadDir(filepath.Dir(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("files left after Release: %v", entries)
+			}
+		})
+	}
+}
+
+// Of many runs starting at once exactly one gets the lock.
+func TestAcquireConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.lock")
+	const runs = 20
+	results := make(chan error, runs)
+	for range runs {
+		go func() {
+			_, err := Acquire(path)
+			results <- err
+		}()
+	}
+	acquired := 0
+	for range runs {
+		err := <-results
+		var held *HeldError
+		switch {
+		case err == nil:
+			acquired++
+		case !errors.As(err, &held):
+			t.Errorf("Acquire = %v, want a *HeldError", err)
+		}
+	}
+	if acquired != 1 {
+		t.Errorf("%d runs got the lock, want 1", acquired)
+	}
+}
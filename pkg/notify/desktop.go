@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// appName is what desktop notifications are sent as.
+const appName = "backup-tui"
+
+// sendDesktop shows summary as a desktop notification with notify-send, or
+// where that isn't installed, by calling the notification service over
+// D-Bus with gdbus.
+func sendDesktop(ctx context.Context, summary Summary) error {
+	urgency := "normal"
+	if summary.Failed() {
+		urgency = "critical"
+	}
+
+	if path, err := exec.LookPath("notify-send"); err == nil {
+		out, err := exec.CommandContext(ctx, path, "--app-name", appName, "--urgency", urgency, summary.Title(), summary.Text()).CombinedOutput()
+		return commandError(err, out)
+	}
+
+	path, err := exec.LookPath("gdbus")
+	if err != nil {
+		return errors.New("neither notify-send nor gdbus is installed")
+	}
+	level := map[string]int{"normal": 1, "critical": 2}[urgency]
+	out, err := exec.CommandContext(ctx, path, "call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.Notify",
+		"--",
+		gvariantString(appName), "0", gvariantString(""),
+		gvariantString(summary.Title()), gvariantString(summary.Text()),
+		"[]", fmt.Sprintf("{'urgency': <byte %d>}", level), "-1",
+	).CombinedOutput()
+	return commandError(err, out)
+}
+
+// gvariantString quotes s in the GVariant text format gdbus parses its
+// arguments in.
+func gvariantString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return "'" + s + "'"
+}
+
+func commandError(err error, out []byte) error {
+	if err == nil {
+		return nil
+	}
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
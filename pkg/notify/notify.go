@@ -0,0 +1,175 @@
+// Package notify tells people how a backup run ended. Each Config selects a
+// notifier, a webhook, an email or a desktop notification, and Send
+// delivers the run's Summary through it.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Notifier types a Config can select.
+const (
+	TypeWebhook = "webhook"
+	TypeSMTP    = "smtp"
+	TypeDesktop = "desktop"
+)
+
+// Types lists the available notifier types.
+var Types = []string{TypeWebhook, TypeSMTP, TypeDesktop}
+
+// Statuses a Summary can have, the same as the history's.
+const (
+	StatusSuccess   = "success"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// sendTimeout bounds delivering one notification.
+const sendTimeout = 30 * time.Second
+
+// Config selects and configures a notifier. Which fields are used depends
+// on Type. Password is never saved.
+type Config struct {
+	Type string `json:"type"`
+	// OnlyFailures skips runs that succeeded or were cancelled.
+	OnlyFailures bool `json:"only_failures,omitempty"`
+
+	// Webhook
+	URL string `json:"url,omitempty"`
+	// Template is one of Presets or a text/template rendering the request
+	// body from the Summary. Empty posts the Summary as JSON.
+	Template string            `json:"template,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+
+	// SMTP
+	Server string   `json:"server,omitempty"`
+	User   string   `json:"user,omitempty"`
+	From   string   `json:"from,omitempty"`
+	To     []string `json:"to,omitempty"`
+	// TLS connects with TLS right away, as on port 465, instead of
+	// upgrading with STARTTLS when the server offers it.
+	TLS bool `json:"tls,omitempty"`
+
+	Password string `json:"-"`
+}
+
+func (c Config) String() string {
+	switch c.Type {
+	case TypeWebhook:
+		return "webhook " + c.URL
+	case TypeSMTP:
+		return fmt.Sprintf("email to %s via %s", strings.Join(c.To, ", "), c.Server)
+	case TypeDesktop:
+		return "desktop notification"
+	}
+	return fmt.Sprintf("unknown notifier %q", c.Type)
+}
+
+// Archive is an archive or snapshot a run made.
+type Archive struct {
+	Name         string   `json:"name"`
+	Size         int64    `json:"size"`
+	Checksum     string   `json:"sha256,omitempty"`
+	Destinations []string `json:"destinations"`
+}
+
+// Summary describes a finished run.
+type Summary struct {
+	Job          string        `json:"job"`
+	Host         string        `json:"host"`
+	Status       string        `json:"status"`
+	Started      time.Time     `json:"started"`
+	Finished     time.Time     `json:"finished"`
+	Duration     time.Duration `json:"duration_ns"`
+	Size         int64         `json:"size"`
+	Paths        []string      `json:"paths"`
+	Destinations []string      `json:"destinations"`
+	Archives     []Archive     `json:"archives"`
+	Errors       []string      `json:"errors"`
+}
+
+// Failed reports whether the run failed.
+func (s Summary) Failed() bool {
+	return s.Status == StatusFailed
+}
+
+// Title is a one line description of how the run ended.
+func (s Summary) Title() string {
+	job := s.Job
+	if job == "" {
+		job = "unsaved job"
+	}
+	switch s.Status {
+	case StatusSuccess:
+		return fmt.Sprintf("Backup %s succeeded", job)
+	case StatusCancelled:
+		return fmt.Sprintf("Backup %s was cancelled", job)
+	}
+	return fmt.Sprintf("Backup %s failed", job)
+}
+
+// Text describes the run in a few lines of plain text.
+func (s Summary) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Host: %s\n", s.Host)
+	fmt.Fprintf(&b, "Started: %s\n", s.Started.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "Duration: %s\n", s.Duration.Round(time.Second))
+	fmt.Fprintf(&b, "Size: %s in %d archives\n", humanize.Bytes(uint64(s.Size)), len(s.Archives)) //nolint:gosec
+	fmt.Fprintf(&b, "Destinations: %s\n", strings.Join(s.Destinations, ", "))
+	if len(s.Errors) > 0 {
+		b.WriteString("Errors:\n")
+		for _, err := range s.Errors {
+			fmt.Fprintf(&b, "  %s\n", err)
+		}
+	}
+	return b.String()
+}
+
+// Wants reports whether the notifier is sent summary.
+func (c Config) Wants(summary Summary) bool {
+	return !c.OnlyFailures || summary.Failed()
+}
+
+// Send delivers summary through the notifier config selects.
+func Send(ctx context.Context, config Config, summary Summary) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	switch config.Type {
+	case TypeWebhook:
+		return sendWebhook(ctx, config, summary)
+	case TypeSMTP:
+		return sendMail(ctx, config, summary)
+	case TypeDesktop:
+		return sendDesktop(ctx, summary)
+	}
+	return fmt.Errorf("unknown notifier type %q", config.Type)
+}
+
+// SendAll delivers summary through each of configs that wants it, all at
+// once, returning the errors of those that failed.
+func SendAll(ctx context.Context, configs []Config, summary Summary) error {
+	errs := make([]error, len(configs))
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		if !config.Wants(summary) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := Send(ctx, config, summary); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", config, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
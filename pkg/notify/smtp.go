@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// message formats summary as a plain text email.
+func message(config Config, summary Summary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", summary.Title()))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(summary.Text())
+	return b.String()
+}
+
+// sendMail sends summary by email through config's server. Plain
+// authentication is only used over TLS, or to a server on localhost.
+func sendMail(ctx context.Context, config Config, summary Summary) error {
+	if config.Server == "" || config.From == "" || len(config.To) == 0 {
+		return fmt.Errorf("email needs a server, from and to")
+	}
+	host, _, err := net.SplitHostPort(config.Server)
+	if err != nil {
+		return fmt.Errorf("server %q: %w", config.Server, err)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", config.Server)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if config.TLS {
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !config.TLS {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if config.User != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.User, config.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(config.From); err != nil {
+		return err
+	}
+	for _, to := range config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(message(config, summary))); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+)
+
+// mail is what the stand-in SMTP server received.
+type mail struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer accepts one SMTP session, offering plain authentication, and
+// hands over what it received.
+func smtpServer(t *testing.T) (string, <-chan mail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	mails := make(chan mail, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		var m mail
+		reply("220 localhost stand-in")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				m.auth = arg
+				reply("235 2.7.0 Authentication successful")
+			case "MAIL":
+				m.from = arg
+				reply("250 OK")
+			case "RCPT":
+				m.to = append(m.to, arg)
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				m.data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				mails <- m
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), mails
+}
+
+func TestSendMail(t *testing.T) {
+	server, mails := smtpServer(t)
+	config := Config{
+		Type:     TypeSMTP,
+		Server:   server,
+		User:     "pi",
+		Password: "secret",
+		From:     "backups@pi.local",
+		To:       []string{"me@example.com", "admin@example.com"},
+	}
+	summary := testSummary()
+	if err := Send(context.Background(), config, summary); err != nil {
+		t.Fatal(err)
+	}
+
+	m := <-mails
+	wantAuth := "PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00pi\x00secret"))
+	if m.auth != wantAuth {
+		t.Errorf("AUTH %q, want %q", m.auth, wantAuth)
+	}
+	if m.from != "FROM:<backups@pi.local>" {
+		t.Errorf("MAIL %q, want FROM:<backups@pi.local>", m.from)
+	}
+	if want := []string{"TO:<me@example.com>", "TO:<admin@example.com>"}; strings.Join(m.to, ",") != strings.Join(want, ",") {
+		t.Errorf("RCPT %q, want %q", m.to, want)
+	}
+
+	header, body, ok := strings.Cut(m.data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message without a header: %q", m.data)
+	}
+	for _, want := range []string{
+		"From: backups@pi.local",
+		"To: me@example.com, admin@example.com",
+		"Subject: Backup pi-home succeeded",
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(header+"\r\n", want+"\r\n") {
+			t.Errorf("header lacks %q:\n%s", want, header)
+		}
+	}
+	// Lines end in CRLF on the wire.
+	if body != strings.ReplaceAll(summary.Text(), "\n", "\r\n") {
+		t.Errorf("body = %q, want the summary text %q", body, summary.Text())
+	}
+}
+
+func TestSendMailNeedsAddresses(t *testing.T) {
+	if err := Send(context.Background(), Config{Type: TypeSMTP, Server: "127.0.0.1:25"}, testSummary()); err == nil {
+		t.Error("Send without from and to succeeded")
+	}
+}
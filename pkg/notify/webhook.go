@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Presets are templates for services with a webhook format of their own,
+// usable by name as a Config's Template.
+var Presets = map[string]string{
+	"slack":   `{"text": {{json (print "*" .Title "*\n" .Text)}}}`,
+	"discord": `{"content": {{json (truncate 1900 (print "**" .Title "**\n" .Text))}}}`,
+	// ntfy takes the message as plain text, see ntfyHeaders.
+	"ntfy": `{{.Text}}`,
+}
+
+var templateFuncs = template.FuncMap{
+	// json quotes a value for use inside a JSON template.
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"bytes": func(size int64) string {
+		return humanize.Bytes(uint64(size)) //nolint:gosec
+	},
+	"truncate": func(n int, s string) string {
+		if len(s) <= n {
+			return s
+		}
+		return s[:n] + "..."
+	},
+}
+
+// webhookBody renders the request body of config for summary, and its
+// content type.
+func webhookBody(config Config, summary Summary) ([]byte, string, error) {
+	if config.Template == "" {
+		data, err := json.Marshal(summary)
+		return data, "application/json", err
+	}
+
+	text, ok := Presets[config.Template]
+	contentType := "application/json"
+	if config.Template == "ntfy" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	if !ok {
+		text = config.Template
+	}
+	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, "", fmt.Errorf("parsing template: %w", err)
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, summary); err != nil {
+		return nil, "", fmt.Errorf("rendering template: %w", err)
+	}
+	return body.Bytes(), contentType, nil
+}
+
+// ntfyHeaders carry the title and urgency ntfy can't take from a plain text
+// message.
+func ntfyHeaders(summary Summary) map[string]string {
+	headers := map[string]string{"Title": summary.Title(), "Tags": "white_check_mark"}
+	switch summary.Status {
+	case StatusFailed:
+		headers["Tags"] = "rotating_light"
+		headers["Priority"] = "high"
+	case StatusCancelled:
+		headers["Tags"] = "warning"
+	}
+	return headers
+}
+
+func sendWebhook(ctx context.Context, config Config, summary Summary) error {
+	if config.URL == "" {
+		return fmt.Errorf("webhook has no url")
+	}
+	body, contentType, err := webhookBody(config, summary)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if config.Template == "ntfy" {
+		for key, value := range ntfyHeaders(summary) {
+			req.Header.Set(key, value)
+		}
+	}
+	// Configured headers, such as an Authorization token, come last so
+	// they can override the defaults.
+	for key, value := range config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSummary() Summary {
+	started := time.Date(2026, time.October, 19, 3, 0, 0, 0, time.UTC)
+	return Summary{
+		Job:          "pi-home",
+		Host:         "pi",
+		Status:       StatusSuccess,
+		Started:      started,
+		Finished:     started.Add(90 * time.Second),
+		Duration:     90 * time.Second,
+		Size:         2048,
+		Paths:        []string{"/home/pi"},
+		Destinations: []string{"sftp://pi@nas/backups"},
+		Archives:     []Archive{{Name: "home-2026-10-19-001.7z", Size: 2048, Destinations: []string{"sftp://pi@nas/backups"}}},
+		Errors:       []string{},
+	}
+}
+
+// request is what the stand-in webhook server received.
+type request struct {
+	header http.Header
+	body   string
+}
+
+// webhookServer records the requests it gets, answering with status.
+func webhookServer(t *testing.T, status int) (*httptest.Server, <-chan request) {
+	t.Helper()
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{header: r.Header, body: string(body)}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestWebhookSummaryJSON(t *testing.T) {
+	server, requests := webhookServer(t, http.StatusNoContent)
+	config := Config{Type: TypeWebhook, URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}}
+	if err := Send(context.Background(), config, testSummary()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-requests
+	if got := req.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := req.header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization = %q, want the configured header", got)
+	}
+	var got Summary
+	if err := json.Unmarshal([]byte(req.body), &got); err != nil {
+		t.Fatalf("body %q: %v", req.body, err)
+	}
+	if got.Job != "pi-home" || got.Status != StatusSuccess || len(got.Archives) != 1 || got.Archives[0].Name != "home-2026-10-19-001.7z" {
+		t.Errorf("body decoded to %+v", got)
+	}
+}
+
+func TestWebhookTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		// want is the whole body, or only a JSON field of it if field is
+		// set.
+		field string
+		want  string
+	}{
+		{
+			name:     "custom",
+			template: `{"job": {{json .Job}}, "size": {{json (bytes .Size)}}, "status": "{{.Status}}"}`,
+			want:     `{"job": "pi-home", "size": "2.0 kB", "status": "success"}`,
+		},
+		{
+			name:     "slack",
+			template: "slack",
+			field:    "text",
+			want:     "*Backup pi-home succeeded*\n" + testSummary().Text(),
+		},
+		{
+			name:     "discord",
+			template: "discord",
+			field:    "content",
+			want:     "**Backup pi-home succeeded**\n" + testSummary().Text(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := webhookServer(t, http.StatusOK)
+			config := Config{Type: TypeWebhook, URL: server.URL, Template: test.template}
+			if err := Send(context.Background(), config, testSummary()); err != nil {
+				t.Fatal(err)
+			}
+
+			body := (<-requests).body
+			if test.field != "" {
+				var fields map[string]string
+				if err := json.Unmarshal([]byte(body), &fields); err != nil {
+					t.Fatalf("body %q isn't JSON: %v", body, err)
+				}
+				body = fields[test.field]
+			}
+			if body != test.want {
+				t.Errorf("body = %q, want %q", body, test.want)
+			}
+		})
+	}
+}
+
+func TestWebhookNtfy(t *testing.T) {
+	server, requests := webhookServer(t, http.StatusOK)
+	summary := testSummary()
+	summary.Status = StatusFailed
+	summary.Errors = []string{"nas: connection refused"}
+	if err := Send(context.Background(), Config{Type: TypeWebhook, URL: server.URL, Template: "ntfy"}, summary); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-requests
+	if req.body != summary.Text() {
+		t.Errorf("body = %q, want the summary text", req.body)
+	}
+	for key, want := range map[string]string{"Title": "Backup pi-home failed", "Priority": "high", "Tags": "rotating_light"} {
+		if got := req.header.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	server, _ := webhookServer(t, http.StatusUnauthorized)
+	err := Send(context.Background(), Config{Type: TypeWebhook, URL: server.URL}, testSummary())
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Send = %v, want the 401 reported", err)
+	}
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"io"
+)
+
+// Chunk size bounds. Cut points are content defined, so inserting or
+// removing bytes only changes the chunks around the edit and the rest
+// deduplicate against earlier snapshots.
+const (
+	MinChunkSize = 512 << 10
+	AvgChunkSize = 1 << 20
+	MaxChunkSize = 8 << 20
+)
+
+// cutMask selects the top bits of the gear hash, as many as make a cut
+// point every AvgChunkSize bytes on average.
+const cutMask = uint64(AvgChunkSize-1) << (64 - 20)
+
+// gear maps each byte to a pseudo random value for the rolling hash. It is
+// derived from a fixed seed so chunk boundaries never change between
+// versions.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6261636b75702d74) // "backup-t"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker splits a stream into content defined chunks using a gear rolling
+// hash, like FastCDC.
+type Chunker struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+func NewChunker(r io.Reader) *Chunker {
+	return &Chunker{r: r, buf: make([]byte, MaxChunkSize)}
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the following call.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < MaxChunkSize && !c.eof {
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+		for c.end < len(c.buf) && !c.eof {
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data := c.buf[c.start:c.end]
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	n := cutPoint(data)
+	c.start += n
+	return data[:n], nil
+}
+
+// cutPoint returns the length of the chunk at the start of data.
+func cutPoint(data []byte) int {
+	if len(data) <= MinChunkSize {
+		return len(data)
+	}
+
+	limit := min(len(data), MaxChunkSize)
+	var h uint64
+	for i := MinChunkSize; i < limit; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&cutMask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
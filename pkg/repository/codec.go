@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Blob flags, stored in the first byte of every blob.
+const (
+	flagCompressed = 1 << 0
+	flagEncrypted  = 1 << 1
+)
+
+// ErrWrongPassword is returned when opening an encrypted repository with the
+// wrong password.
+var ErrWrongPassword = errors.New("wrong repository password")
+
+// codec turns chunks and index files into the blobs stored at the
+// destination: optionally zstd compressed, then optionally sealed with
+// AES-256-GCM.
+type codec struct {
+	compress bool
+	aead     cipher.AEAD
+	// idKey keys chunk IDs of encrypted repositories, so the stored names
+	// don't reveal the hashes of known content.
+	idKey []byte
+
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newCodec(compress bool) (*codec, error) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &codec{compress: compress, encoder: encoder, decoder: decoder}, nil
+}
+
+// deriveKeys turns password into the encryption and ID keys.
+func (c *codec) deriveKeys(password string, salt []byte) error {
+	key, err := scrypt.Key([]byte(password), salt, 1<<15, 8, 1, 64)
+	if err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return err
+	}
+	c.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	c.idKey = key[32:]
+	return nil
+}
+
+// id names a chunk by its content.
+func (c *codec) id(data []byte) string {
+	if c.idKey != nil {
+		mac := hmac.New(sha256.New, c.idKey)
+		mac.Write(data)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *codec) encode(data []byte) ([]byte, error) {
+	flags := byte(0)
+	if c.compress {
+		data = c.encoder.EncodeAll(data, nil)
+		flags |= flagCompressed
+	}
+	if c.aead == nil {
+		return append([]byte{flags}, data...), nil
+	}
+
+	flags |= flagEncrypted
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	blob := append([]byte{flags}, nonce...)
+	return c.aead.Seal(blob, nonce, data, []byte{flags}), nil
+}
+
+func (c *codec) decode(blob []byte) ([]byte, error) {
+	if len(blob) == 0 {
+		return nil, errors.New("empty blob")
+	}
+	flags, data := blob[0], blob[1:]
+
+	if flags&flagEncrypted != 0 {
+		if c.aead == nil {
+			return nil, errors.New("blob is encrypted but no password was given")
+		}
+		if len(data) < c.aead.NonceSize() {
+			return nil, errors.New("truncated blob")
+		}
+		nonce, sealed := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+		var err error
+		data, err = c.aead.Open(nil, nonce, sealed, []byte{flags})
+		if err != nil {
+			return nil, fmt.Errorf("decrypting blob: %w", err)
+		}
+	}
+	if flags&flagCompressed != 0 {
+		return c.decoder.DecodeAll(data, nil)
+	}
+	return data, nil
+}
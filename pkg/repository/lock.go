@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+	"github.com/Chanadu/backup-tui/pkg/lock"
+)
+
+// Prefixes of the markers runs leave at the repository while they use it,
+// followed by a random ID. Any number of backups share the repository, but
+// Forget, which deletes chunks, needs it to itself.
+const (
+	sharedLockPrefix    = "lock-shared-"
+	exclusiveLockPrefix = "lock-exclusive-"
+)
+
+// lock leaves a shared or exclusive marker at the repository, failing with
+// a *lock.HeldError if a live marker of another run conflicts with it: an
+// exclusive marker conflicts with all others, a shared one only with
+// exclusive ones. The marker is written before the others are read, so of
+// two runs starting at once at least one sees the other. The returned
+// function removes the marker.
+func (r *Repository) lock(ctx context.Context, exclusive bool) (func(), error) {
+	prefix := sharedLockPrefix
+	if exclusive {
+		prefix = exclusiveLockPrefix
+	}
+	name := prefix + randomHex(8)
+	data, err := json.Marshal(lock.Current())
+	if err != nil {
+		return nil, err
+	}
+	if err := r.dest.Put(ctx, name, bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		return nil, fmt.Errorf("locking repository: %w", err)
+	}
+	unlock := func() {
+		if err := lock.ReleaseRemote(context.WithoutCancel(ctx), r.dest, name); err != nil {
+			log.Printf("Couldn't remove repository lock %s from %s: %v", name, r.dest, err)
+		}
+	}
+
+	files, err := r.dest.List(ctx)
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("locking repository: %w", err)
+	}
+	for _, file := range files {
+		if file.Name == name || !conflicts(file.Name, exclusive) {
+			continue
+		}
+		if holder, live := r.lockHolder(ctx, file); live {
+			unlock()
+			return nil, &lock.HeldError{Where: r.dest.String(), Holder: holder}
+		}
+	}
+	return unlock, nil
+}
+
+// conflicts reports whether the marker called name keeps a run from taking
+// a lock that is exclusive or not.
+func conflicts(name string, exclusive bool) bool {
+	if strings.HasPrefix(name, exclusiveLockPrefix) {
+		return true
+	}
+	return exclusive && strings.HasPrefix(name, sharedLockPrefix)
+}
+
+// lockHolder reads the marker file and reports whether the run holding it
+// is still alive. A marker that can't be read counts as live until it is
+// older than lock.StaleAfter.
+func (r *Repository) lockHolder(ctx context.Context, file destination.FileInfo) (lock.Holder, bool) {
+	var buf bytes.Buffer
+	var holder lock.Holder
+	err := r.dest.Get(ctx, file.Name, &buf, nil)
+	if errors.Is(err, destination.ErrNotFound) {
+		// Released in the meantime.
+		return holder, false
+	}
+	if err != nil || json.Unmarshal(buf.Bytes(), &holder) != nil {
+		holder = lock.Holder{Host: "unknown host", Started: file.ModTime}
+		return holder, time.Since(file.ModTime) <= lock.StaleAfter
+	}
+	return holder, !holder.Stale()
+}
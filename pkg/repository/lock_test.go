@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+	"github.com/Chanadu/backup-tui/pkg/lock"
+)
+
+func openTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	ctx := context.Background()
+	dest, err := destination.Open(ctx, destination.Config{Type: destination.TypeLocal, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dest.Close() })
+	repo, err := Open(ctx, dest, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// putMarker leaves the lock marker called name of a live run.
+func putMarker(t *testing.T, repo *Repository, name string) {
+	t.Helper()
+	data, err := json.Marshal(lock.Current())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.dest.Put(context.Background(), name, bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForgetWaitsForBackups(t *testing.T) {
+	repo := openTestRepository(t)
+	putMarker(t, repo, sharedLockPrefix+"other")
+
+	var held *lock.HeldError
+	if _, err := repo.Forget(context.Background()); !errors.As(err, &held) {
+		t.Fatalf("Forget during a backup = %v, want a *lock.HeldError", err)
+	}
+	unlock, err := repo.lock(context.Background(), false)
+	if err != nil {
+		t.Fatalf("shared lock during a backup: %v", err)
+	}
+	unlock()
+}
+
+func TestBackupWaitsForForget(t *testing.T) {
+	repo := openTestRepository(t)
+	putMarker(t, repo, exclusiveLockPrefix+"other")
+
+	var held *lock.HeldError
+	if _, _, err := repo.Backup(context.Background(), "job", []string{t.TempDir()}, nil); !errors.As(err, &held) {
+		t.Fatalf("Backup during a Forget = %v, want a *lock.HeldError", err)
+	}
+}
+
+func TestLockReleased(t *testing.T) {
+	repo := openTestRepository(t)
+	if _, _, err := repo.Backup(context.Background(), "job", []string{t.TempDir()}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Forget(context.Background()); err != nil {
+		t.Fatalf("Forget after a backup: %v", err)
+	}
+	files, err := repo.dest.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if conflicts(file.Name, true) {
+			t.Errorf("lock marker %s left behind", file.Name)
+		}
+	}
+}
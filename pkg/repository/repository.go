@@ -0,0 +1,603 @@
+// Package repository stores backups as deduplicated, content defined chunks
+// at a destination. Each backup is a snapshot: an index of the files it
+// contains and the chunks they are made of. Chunks already stored by an
+// earlier snapshot are never uploaded again.
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Chanadu/backup-tui/pkg/destination"
+)
+
+// Names of the files a repository keeps at its destination.
+const (
+	configName     = "repo-config.json"
+	chunkPrefix    = "chunk-"
+	snapshotPrefix = "snapshot-"
+
+	snapshotTimeFormat = "20060102T150405Z"
+	keyCheckText       = "backup-tui repository"
+	version            = 1
+)
+
+// Options configure a repository when it is opened.
+type Options struct {
+	// Compress zstd compresses new chunks and snapshots.
+	Compress bool
+	// Encrypt creates new repositories encrypted with Password. Existing
+	// repositories keep the setting they were created with.
+	Encrypt  bool
+	Password string
+}
+
+// config is stored unencrypted at the destination, it describes how to read
+// the repository.
+type config struct {
+	Version   int    `json:"version"`
+	Encrypted bool   `json:"encrypted"`
+	Salt      []byte `json:"salt,omitempty"`
+	// KeyCheck is keyCheckText encoded with the repository's key, to tell
+	// a wrong password apart from corrupted data.
+	KeyCheck []byte `json:"key_check,omitempty"`
+}
+
+// File is a file in a snapshot.
+type File struct {
+	// Path is slash separated and relative to the deepest directory
+	// holding all of the snapshot's Paths.
+	Path    string      `json:"path"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size"`
+	Link    string      `json:"link,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+}
+
+// Snapshot is the index of one backup.
+type Snapshot struct {
+	ID    string    `json:"id"`
+	Time  time.Time `json:"time"`
+	Job   string    `json:"job"`
+	Paths []string  `json:"paths"`
+	Files []File    `json:"files"`
+}
+
+// Size is the total size of the snapshot's files.
+func (s Snapshot) Size() int64 {
+	var size int64
+	for _, file := range s.Files {
+		size += file.Size
+	}
+	return size
+}
+
+// Stats describe what a backup stored.
+type Stats struct {
+	Files       int
+	Bytes       int64
+	NewChunks   int
+	NewBytes    int64
+	ReusedBytes int64
+}
+
+// Progress is called as a backed up or restored path advances, with the
+// bytes of it processed so far out of total.
+type Progress func(path string, done, total int64)
+
+// Repository is a chunk repository at a destination.
+type Repository struct {
+	dest   destination.Destination
+	codec  *codec
+	chunks map[string]bool
+}
+
+// Open opens the repository at dest, creating it if dest holds none yet.
+func Open(ctx context.Context, dest destination.Destination, opts Options) (*Repository, error) {
+	c, err := newCodec(opts.Compress)
+	if err != nil {
+		return nil, err
+	}
+	r := &Repository{dest: dest, codec: c, chunks: map[string]bool{}}
+
+	var buf bytes.Buffer
+	err = dest.Get(ctx, configName, &buf, nil)
+	switch {
+	case errors.Is(err, destination.ErrNotFound):
+		if err := r.initialize(ctx, opts); err != nil {
+			return nil, fmt.Errorf("creating repository: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("reading repository config: %w", err)
+	default:
+		if err := r.unlock(buf.Bytes(), opts.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.loadChunks(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// loadChunks lists the chunks the repository holds.
+func (r *Repository) loadChunks(ctx context.Context) error {
+	files, err := r.dest.List(ctx)
+	if err != nil {
+		return err
+	}
+	r.chunks = map[string]bool{}
+	for _, file := range files {
+		if id, ok := strings.CutPrefix(file.Name, chunkPrefix); ok {
+			r.chunks[id] = true
+		}
+	}
+	return nil
+}
+
+func (r *Repository) initialize(ctx context.Context, opts Options) error {
+	cfg := config{Version: version}
+	if opts.Encrypt {
+		if opts.Password == "" {
+			return errors.New("encryption needs a password")
+		}
+		cfg.Encrypted = true
+		cfg.Salt = make([]byte, 32)
+		if _, err := rand.Read(cfg.Salt); err != nil {
+			return err
+		}
+		if err := r.codec.deriveKeys(opts.Password, cfg.Salt); err != nil {
+			return err
+		}
+		var err error
+		if cfg.KeyCheck, err = r.codec.encode([]byte(keyCheckText)); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.dest.Put(ctx, configName, bytes.NewReader(data), int64(len(data)), nil)
+}
+
+func (r *Repository) unlock(data []byte, password string) error {
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("reading repository config: %w", err)
+	}
+	if cfg.Version != version {
+		return fmt.Errorf("unsupported repository version %d", cfg.Version)
+	}
+	if !cfg.Encrypted {
+		return nil
+	}
+
+	if password == "" {
+		return errors.New("repository is encrypted, a password is needed")
+	}
+	if err := r.codec.deriveKeys(password, cfg.Salt); err != nil {
+		return err
+	}
+	check, err := r.codec.decode(cfg.KeyCheck)
+	if err != nil || string(check) != keyCheckText {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// Exists reports whether dest holds a repository.
+func Exists(ctx context.Context, dest destination.Destination) (bool, error) {
+	_, err := dest.Stat(ctx, configName)
+	if errors.Is(err, destination.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Encrypted reports whether the repository's contents are encrypted.
+func (r *Repository) Encrypted() bool {
+	return r.codec.aead != nil
+}
+
+func (r *Repository) putBlob(ctx context.Context, name string, data []byte) (int64, error) {
+	blob, err := r.codec.encode(data)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(blob)), r.dest.Put(ctx, name, bytes.NewReader(blob), int64(len(blob)), nil)
+}
+
+func (r *Repository) getBlob(ctx context.Context, name string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.dest.Get(ctx, name, &buf, nil); err != nil {
+		return nil, err
+	}
+	return r.codec.decode(buf.Bytes())
+}
+
+// Backup stores paths as a new snapshot of job, uploading only the chunks
+// the repository doesn't have yet. It holds a shared lock on the
+// repository, so no Forget deletes chunks it reuses or stored but hasn't
+// referenced from the snapshot yet.
+func (r *Repository) Backup(ctx context.Context, job string, paths []string, progress Progress) (Snapshot, Stats, error) {
+	unlock, err := r.lock(ctx, false)
+	if err != nil {
+		return Snapshot{}, Stats{}, err
+	}
+	defer unlock()
+	// A Forget may have deleted chunks since Open listed them.
+	if err := r.loadChunks(ctx); err != nil {
+		return Snapshot{}, Stats{}, err
+	}
+
+	now := time.Now().UTC()
+	snapshot := Snapshot{
+		ID:    now.Format(snapshotTimeFormat) + "-" + randomHex(4),
+		Time:  now,
+		Job:   job,
+		Paths: paths,
+	}
+	var stats Stats
+
+	// Paths are stored relative to the directory holding all roots, so
+	// /a/config and /b/config don't both become config.
+	base := commonParent(paths)
+	for _, root := range paths {
+		total, err := treeSize(root)
+		if err != nil {
+			return Snapshot{}, stats, err
+		}
+		var done int64
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+
+			file := File{Path: filepath.ToSlash(rel), Mode: info.Mode(), ModTime: info.ModTime().UTC()}
+			switch {
+			case info.Mode()&fs.ModeSymlink != 0:
+				if file.Link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			case info.Mode().IsRegular():
+				file.Size = info.Size()
+				if file.Chunks, err = r.storeFile(ctx, path, &stats, func(n int64) {
+					if progress != nil {
+						progress(root, done+n, total)
+					}
+				}); err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				done += file.Size
+				stats.Files++
+				stats.Bytes += file.Size
+			case !info.IsDir():
+				// Devices, sockets and pipes can't be backed up.
+				return nil
+			}
+
+			snapshot.Files = append(snapshot.Files, file)
+			return nil
+		})
+		if err != nil {
+			return Snapshot{}, stats, err
+		}
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return Snapshot{}, stats, err
+	}
+	if _, err := r.putBlob(ctx, snapshotPrefix+snapshot.ID, data); err != nil {
+		return Snapshot{}, stats, fmt.Errorf("saving snapshot: %w", err)
+	}
+	return snapshot, stats, nil
+}
+
+func (r *Repository) storeFile(ctx context.Context, path string, stats *Stats, progress func(int64)) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ids := []string{}
+	chunker := NewChunker(f)
+	var done int64
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		id := r.codec.id(chunk)
+		if r.chunks[id] {
+			stats.ReusedBytes += int64(len(chunk))
+		} else {
+			size, err := r.putBlob(ctx, chunkPrefix+id, chunk)
+			if err != nil {
+				return nil, err
+			}
+			r.chunks[id] = true
+			stats.NewChunks++
+			stats.NewBytes += size
+		}
+
+		ids = append(ids, id)
+		done += int64(len(chunk))
+		progress(done)
+	}
+}
+
+// Snapshots returns the repository's snapshots, newest first.
+func (r *Repository) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	files, err := r.dest.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name, snapshotPrefix) {
+			continue
+		}
+		data, err := r.getBlob(ctx, file.Name)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", file.Name, err)
+		}
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", file.Name, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Time.After(snapshots[j].Time) })
+	return snapshots, nil
+}
+
+// Restore writes the files of snapshot into targetDir. Nothing is written
+// outside of it: files below a restored symlink are rejected.
+func (r *Repository) Restore(ctx context.Context, snapshot Snapshot, targetDir string, progress Progress) error {
+	total := snapshot.Size()
+	var done int64
+	for _, file := range snapshot.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			return fmt.Errorf("snapshot has unsafe path %q", file.Path)
+		}
+		if err := checkParents(targetDir, filepath.FromSlash(file.Path)); err != nil {
+			return err
+		}
+		target := filepath.Join(targetDir, filepath.FromSlash(file.Path))
+
+		switch {
+		case file.Mode.IsDir():
+			if err := os.MkdirAll(target, file.Mode.Perm()|0o700); err != nil {
+				return err
+			}
+		case file.Mode&fs.ModeSymlink != 0:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			_ = os.Remove(target)
+			if err := os.Symlink(file.Link, target); err != nil {
+				return err
+			}
+		default:
+			if err := r.restoreFile(ctx, file, target); err != nil {
+				return fmt.Errorf("%s: %w", file.Path, err)
+			}
+			done += file.Size
+			if progress != nil {
+				progress(file.Path, done, total)
+			}
+		}
+	}
+	return nil
+}
+
+// checkParents checks that none of the directories below targetDir that
+// hold path is a symlink, which could lead the file out of targetDir.
+func checkParents(targetDir, path string) error {
+	dir := targetDir
+	for _, part := range strings.Split(filepath.Dir(path), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("snapshot has unsafe path %q: %s is a symlink", path, dir)
+		}
+	}
+	return nil
+}
+
+func (r *Repository) restoreFile(ctx context.Context, file File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	// Replaces a symlink rather than writing through it.
+	if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, file.Mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	for _, id := range file.Chunks {
+		var chunk []byte
+		chunk, err = r.getBlob(ctx, chunkPrefix+id)
+		if err != nil {
+			err = fmt.Errorf("chunk %s: %w", id, err)
+			break
+		}
+		if _, err = f.Write(chunk); err != nil {
+			break
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(target, file.ModTime, file.ModTime)
+}
+
+// Forget deletes the snapshots with the given IDs, then every chunk no
+// remaining snapshot uses. It returns how many chunks were deleted. It
+// holds an exclusive lock on the repository, failing with a
+// *lock.HeldError while another run uses it.
+func (r *Repository) Forget(ctx context.Context, ids ...string) (int, error) {
+	unlock, err := r.lock(ctx, true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if err := r.loadChunks(ctx); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := r.dest.Delete(ctx, snapshotPrefix+id); err != nil {
+			return 0, fmt.Errorf("deleting snapshot %s: %w", id, err)
+		}
+	}
+
+	snapshots, err := r.Snapshots(ctx)
+	if err != nil {
+		return 0, err
+	}
+	used := map[string]bool{}
+	for _, snapshot := range snapshots {
+		for _, file := range snapshot.Files {
+			for _, id := range file.Chunks {
+				used[id] = true
+			}
+		}
+	}
+
+	deleted := 0
+	for id := range r.chunks {
+		if used[id] {
+			continue
+		}
+		if err := r.dest.Delete(ctx, chunkPrefix+id); err != nil && !errors.Is(err, destination.ErrNotFound) {
+			return deleted, fmt.Errorf("deleting chunk %s: %w", id, err)
+		}
+		delete(r.chunks, id)
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Expired returns the IDs of job's snapshots beyond the newest keep, given
+// snapshots sorted newest first.
+func Expired(snapshots []Snapshot, job string, keep int) []string {
+	ids := []string{}
+	kept := 0
+	for _, snapshot := range snapshots {
+		if snapshot.Job != job {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		ids = append(ids, snapshot.ID)
+	}
+	return ids
+}
+
+// commonParent is the deepest directory holding all of paths, the parent
+// of the only one if there is just one.
+func commonParent(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	base := filepath.Dir(paths[0])
+	for _, path := range paths[1:] {
+		for !within(base, filepath.Dir(path)) {
+			parent := filepath.Dir(base)
+			if parent == base {
+				// Paths on different volumes share no directory.
+				break
+			}
+			base = parent
+		}
+	}
+	return base
+}
+
+// within reports whether path is dir or below it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// treeSize is the total size of the regular files under root.
+func treeSize(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
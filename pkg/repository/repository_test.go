@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A snapshot with a symlink to elsewhere and a file below it mustn't write
+// the file through the link.
+func TestRestoreRejectsFilesBelowSymlinks(t *testing.T) {
+	repo := openTestRepository(t)
+	elsewhere := t.TempDir()
+	snapshot := Snapshot{Files: []File{
+		{Path: "x", Mode: fs.ModeSymlink | 0o777, Link: elsewhere},
+		{Path: "x/file", Mode: 0o644},
+	}}
+
+	if err := repo.Restore(context.Background(), snapshot, t.TempDir(), nil); err == nil {
+		t.Error("Restore wrote a file below a symlink")
+	}
+	if _, err := os.Stat(filepath.Join(elsewhere, "file")); err == nil {
+		t.Error("Restore wrote outside the target dir")
+	}
+}
+
+func TestCommonParent(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  string
+	}{
+		{[]string{"/home/pi/docs"}, "/home/pi"},
+		{[]string{"/a/config", "/b/config"}, "/"},
+		{[]string{"/home/pi/a/config", "/home/pi/b/config"}, "/home/pi"},
+		{[]string{"/home/pi/docs", "/home/pi/docs/notes"}, "/home/pi"},
+		{[]string{"/home/pi", "/home/pia"}, "/home"},
+	}
+	for _, test := range tests {
+		if got := commonParent(test.paths); got != test.want {
+			t.Errorf("commonParent(%q) = %q, want %q", test.paths, got, test.want)
+		}
+	}
+}
+
+// Paths with the same base name are restored side by side.
+func TestBackupSameNamedPaths(t *testing.T) {
+	repo := openTestRepository(t)
+	source := t.TempDir()
+	paths := []string{filepath.Join(source, "a", "config"), filepath.Join(source, "b", "config")}
+	for i, path := range paths {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "settings"), []byte{byte('a' + i)}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot, _, err := repo.Backup(context.Background(), "job", paths, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := t.TempDir()
+	if err := repo.Restore(context.Background(), snapshot, target, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i, dir := range []string{"a", "b"} {
+		data, err := os.ReadFile(filepath.Join(target, dir, "config", "settings"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := string(rune('a' + i)); string(data) != want {
+			t.Errorf("%s/config/settings = %q, want %q", dir, data, want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// calendarKeywords are the OnCalendar shorthands systemd accepts.
+var calendarKeywords = map[string]bool{
+	"minutely": true, "hourly": true, "daily": true, "weekly": true,
+	"monthly": true, "quarterly": true, "semiannually": true,
+	"yearly": true, "annually": true,
+}
+
+var weekdays = map[string]int{
+	"mon": 1, "monday": 1, "tue": 2, "tuesday": 2, "wed": 3, "wednesday": 3,
+	"thu": 4, "thursday": 4, "fri": 5, "friday": 5, "sat": 6, "saturday": 6,
+	"sun": 7, "sunday": 7,
+}
+
+// ValidateCalendar checks expr is a systemd OnCalendar expression of the
+// form "[weekdays] [year-]month-day [hour:minute[:second]]" or one of its
+// keywords such as daily. Time zones and the ~ last-day syntax aren't
+// supported.
+func ValidateCalendar(expr string) error {
+	expr = strings.TrimSpace(expr)
+	if calendarKeywords[strings.ToLower(expr)] {
+		return nil
+	}
+
+	tokens := strings.Fields(expr)
+	if len(tokens) == 0 || len(tokens) > 3 {
+		return fmt.Errorf("invalid calendar expression %q", expr)
+	}
+
+	seen := map[string]bool{}
+	for _, token := range tokens {
+		var kind string
+		var err error
+		switch {
+		case strings.Contains(token, ":"):
+			kind, err = "time", validateTime(token)
+		case strings.Contains(token, "-"):
+			kind, err = "date", validateDate(token)
+		default:
+			kind, err = "weekday", validateWeekdays(token)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid calendar expression %q: %w", expr, err)
+		}
+		if seen[kind] {
+			return fmt.Errorf("invalid calendar expression %q: %s given twice", expr, kind)
+		}
+		seen[kind] = true
+	}
+	return nil
+}
+
+func validateWeekdays(token string) error {
+	for _, part := range strings.Split(token, ",") {
+		first, last, isRange := strings.Cut(part, "..")
+		if _, ok := weekdays[strings.ToLower(first)]; !ok {
+			return fmt.Errorf("unknown weekday %q", first)
+		}
+		if _, ok := weekdays[strings.ToLower(last)]; isRange && !ok {
+			return fmt.Errorf("unknown weekday %q", last)
+		}
+	}
+	return nil
+}
+
+func validateDate(token string) error {
+	parts := strings.Split(token, "-")
+	switch len(parts) {
+	case 2:
+		parts = append([]string{"*"}, parts...)
+	case 3:
+	default:
+		return fmt.Errorf("invalid date %q", token)
+	}
+
+	if err := validateComponent(parts[0], "year", 1970, 2199); err != nil {
+		return err
+	}
+	if err := validateComponent(parts[1], "month", 1, 12); err != nil {
+		return err
+	}
+	return validateComponent(parts[2], "day", 1, 31)
+}
+
+func validateTime(token string) error {
+	parts := strings.Split(token, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid time %q", token)
+	}
+	if err := validateComponent(parts[0], "hour", 0, 23); err != nil {
+		return err
+	}
+	if err := validateComponent(parts[1], "minute", 0, 59); err != nil {
+		return err
+	}
+	if len(parts) == 3 {
+		// Seconds may have a fraction.
+		seconds, _, _ := strings.Cut(parts[2], ".")
+		return validateComponent(seconds, "second", 0, 59)
+	}
+	return nil
+}
+
+// validateComponent checks a date or time component: "*", or a list of
+// values, "a..b" ranges and "a/step" repetitions.
+func validateComponent(s, name string, lo, hi int) error {
+	for _, part := range strings.Split(s, ",") {
+		value, step, hasStep := strings.Cut(part, "/")
+		if hasStep {
+			if n, err := strconv.Atoi(step); err != nil || n <= 0 {
+				return fmt.Errorf("invalid %s repetition %q", name, part)
+			}
+		}
+		if value == "*" {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(value, "..")
+		values := []string{first}
+		if isRange {
+			values = append(values, last)
+		}
+		for _, v := range values {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < lo || n > hi {
+				return fmt.Errorf("invalid %s %q, expected %d-%d", name, v, lo, hi)
+			}
+		}
+	}
+	return nil
+}
+
+// calendarShorthands maps cron shorthands to OnCalendar keywords.
+var calendarShorthands = map[string]string{
+	"@yearly":   "yearly",
+	"@annually": "yearly",
+	"@monthly":  "monthly",
+	"@weekly":   "weekly",
+	"@daily":    "daily",
+	"@midnight": "daily",
+	"@hourly":   "hourly",
+}
+
+var weekdayNames = [...]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// OnCalendar converts a cron expression or shorthand into the equivalent
+// systemd OnCalendar expression. Intervals and expressions systemd can't
+// express, like ranges with steps or cron's "day of month or day of week",
+// are errors.
+func OnCalendar(spec string) (string, error) {
+	spec = strings.TrimSpace(spec)
+	if keyword, ok := calendarShorthands[spec]; ok {
+		return keyword, nil
+	}
+	if _, err := Parse(spec); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(spec, "@") {
+		return "", fmt.Errorf("%q isn't a calendar schedule", spec)
+	}
+
+	fields := strings.Fields(spec)
+	if fields[2] != "*" && fields[4] != "*" {
+		return "", fmt.Errorf("%q restricts both day of month and day of week, which systemd can't express", spec)
+	}
+
+	var converted [5]string
+	for i, f := range []field{minuteField, hourField, domField, monthField, dowField} {
+		var err error
+		if converted[i], err = f.calendar(fields[i]); err != nil {
+			return "", fmt.Errorf("converting %q: %w", spec, err)
+		}
+	}
+
+	expr := fmt.Sprintf("*-%s-%s %s:%s:00", converted[3], converted[2], converted[1], converted[0])
+	if converted[4] != "*" {
+		expr = converted[4] + " " + expr
+	}
+	return expr, ValidateCalendar(expr)
+}
+
+// calendar converts one cron field to the OnCalendar syntax.
+func (f field) calendar(s string) (string, error) {
+	if s == "*" {
+		return "*", nil
+	}
+
+	parts := []string{}
+	for _, part := range strings.Split(s, ",") {
+		rangePart, step, hasStep := strings.Cut(part, "/")
+		first, last, isRange := strings.Cut(rangePart, "-")
+		if hasStep && isRange {
+			return "", fmt.Errorf("%s range %q with a step", f.name, part)
+		}
+
+		if rangePart == "*" {
+			first = strconv.Itoa(f.min)
+		}
+		lo, err := f.calendarValue(first)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case hasStep:
+			parts = append(parts, lo+"/"+step)
+		case isRange:
+			hi, err := f.calendarValue(last)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, lo+".."+hi)
+		default:
+			parts = append(parts, lo)
+		}
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func (f field) calendarValue(s string) (string, error) {
+	v, err := f.value(s)
+	if err != nil {
+		return "", err
+	}
+	if f.name == dowField.name {
+		return weekdayNames[v], nil
+	}
+	if f.name == minuteField.name || f.name == hourField.name {
+		return fmt.Sprintf("%02d", v), nil
+	}
+	return strconv.Itoa(v), nil
+}
@@ -0,0 +1,226 @@
+// Package schedule parses the schedules jobs run on: five field cron
+// expressions, the usual @daily style shorthands, and fixed intervals
+// written as "@every 6h".
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a job runs next.
+type Schedule interface {
+	// Next returns the first time after after the job should run.
+	Next(after time.Time) time.Time
+	String() string
+}
+
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression ("minute hour day-of-month month
+// day-of-week"), a shorthand such as @daily, or "@every <duration>".
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, errors.New("empty schedule")
+	}
+
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in %q: %w", spec, err)
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("interval in %q is shorter than a minute", spec)
+		}
+		return Every{Interval: d, spec: spec}, nil
+	}
+	if expr, ok := shorthands[spec]; ok {
+		c, err := parseCron(expr)
+		c.spec = spec
+		return c, err
+	}
+	if strings.HasPrefix(spec, "@") {
+		return nil, fmt.Errorf("unknown schedule %q", spec)
+	}
+	return parseCron(spec)
+}
+
+// Every runs a job at a fixed interval after its last run.
+type Every struct {
+	Interval time.Duration
+	spec     string
+}
+
+func (e Every) Next(after time.Time) time.Time {
+	return after.Add(e.Interval)
+}
+
+func (e Every) String() string {
+	if e.spec != "" {
+		return e.spec
+	}
+	return "@every " + e.Interval.String()
+}
+
+// field describes one field of a cron expression.
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Day of week 7 is accepted as Sunday and folded onto 0.
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// Cron runs a job at the times matching a cron expression, in local time.
+type Cron struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+func parseCron(spec string) (Cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Cron{}, fmt.Errorf("cron expression %q needs 5 fields, has %d", spec, len(fields))
+	}
+
+	c := Cron{spec: spec}
+	var err error
+	if c.minute, err = minuteField.parse(fields[0]); err != nil {
+		return Cron{}, err
+	}
+	if c.hour, err = hourField.parse(fields[1]); err != nil {
+		return Cron{}, err
+	}
+	if c.dom, err = domField.parse(fields[2]); err != nil {
+		return Cron{}, err
+	}
+	if c.month, err = monthField.parse(fields[3]); err != nil {
+		return Cron{}, err
+	}
+	if c.dow, err = dowField.parse(fields[4]); err != nil {
+		return Cron{}, err
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domRestricted = fields[2] != "*"
+	c.dowRestricted = fields[4] != "*"
+
+	if c.Next(time.Now()).IsZero() {
+		return Cron{}, fmt.Errorf("cron expression %q never matches", spec)
+	}
+	return c, nil
+}
+
+// parse turns a field such as "*/15", "1-5" or "mon,wed" into a bit set of
+// the values it matches.
+func (f field) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepPart, f.name)
+			}
+		}
+
+		lo, hi := f.min, f.max
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = f.value(first); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(last); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangePart, f.name)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, expected %d-%d", s, f.name, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (c Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	// Like cron, a day matches either restricted field when both are set.
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (c Cron) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// Give up after a few years, the expression can never match (say
+	// February 30th) and the zero time is returned.
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c Cron) String() string {
+	return c.spec
+}